@@ -0,0 +1,242 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"liquidator/competitor"
+	"liquidator/contract"
+	"liquidator/executor"
+	"liquidator/handler"
+	"liquidator/log"
+	"liquidator/opportunity"
+)
+
+type liquidateReq struct {
+	Protocol    string `json:"protocol"`
+	Borrower    string `json:"borrower"`
+	RepayMarket string `json:"repayMarket"`
+	Collateral  string `json:"collateral"`
+	Amount      string `json:"amount"`
+}
+
+type marketReq struct {
+	Market string `json:"market"`
+}
+
+type borrowerReq struct {
+	Borrower string `json:"borrower"`
+}
+
+// Start 启动本地管理接口，token 为空时不启动
+func Start(listen, token string) {
+	if listen == "" || token == "" {
+		log.Print("admin api disabled: listen or token not configured")
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/markets", get(markets))
+	mux.HandleFunc("/params", get(params))
+	mux.HandleFunc("/accounts", get(accounts))
+	mux.HandleFunc("/watchlist", get(watchlist))
+	mux.HandleFunc("/liquidations", get(liquidations))
+	mux.HandleFunc("/subgraphs", get(subgraphs))
+	mux.HandleFunc("/opportunities", get(opportunities))
+	mux.HandleFunc("/competitors", get(competitors))
+	mux.HandleFunc("/pause", post(pause))
+	mux.HandleFunc("/resume", post(resume))
+	mux.HandleFunc("/blacklist", post(blacklist))
+	mux.HandleFunc("/scan", post(scan))
+	mux.HandleFunc("/liquidate", post(liquidate))
+
+	server := &http.Server{Addr: listen, Handler: auth(token, mux)}
+	go func() {
+		log.Printf("admin api listening on %s", listen)
+		if err := server.ListenAndServe(); err != nil {
+			log.Printf("admin api error: %s", err)
+		}
+	}()
+}
+
+func auth(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 只接受文档中的 Authorization: Bearer <token>
+		header := r.Header.Get("Authorization")
+		got := strings.TrimPrefix(header, "Bearer ")
+		if got == header || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func get(h http.HandlerFunc) http.HandlerFunc {
+	return method(http.MethodGet, h)
+}
+
+func post(h http.HandlerFunc) http.HandlerFunc {
+	return method(http.MethodPost, h)
+}
+
+func method(m string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != m {
+			writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+			return
+		}
+		h(w, r)
+	}
+}
+
+func markets(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, handler.Markets())
+}
+
+func params(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, handler.Params())
+}
+
+func accounts(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, handler.UnderwaterAccounts())
+}
+
+func watchlist(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, handler.Watchlist())
+}
+
+func liquidations(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"queued":       handler.Queued(),
+		"liquidations": executor.Liquidations(),
+		"deferred":     executor.DeferredLiquidations(),
+		"rejections":   executor.Rejections(),
+		"paused":       executor.PausedMarkets(),
+		"blacklisted":  executor.Blacklisted(),
+	})
+}
+
+func opportunities(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, opportunity.Recent())
+}
+
+func competitors(w http.ResponseWriter, r *http.Request) {
+	records := competitor.Records()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"report":  competitor.BuildReport(records),
+		"records": records,
+	})
+}
+
+func subgraphs(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, handler.SubgraphStatus())
+}
+
+func pause(w http.ResponseWriter, r *http.Request) {
+	var req marketReq
+	if !decode(w, r, &req) {
+		return
+	}
+	executor.Pause(req.Market)
+	log.Printf("admin: pause market %q", req.Market)
+	writeJSON(w, http.StatusOK, executor.PausedMarkets())
+}
+
+func resume(w http.ResponseWriter, r *http.Request) {
+	var req marketReq
+	if !decode(w, r, &req) {
+		return
+	}
+	executor.Resume(req.Market)
+	log.Printf("admin: resume market %q", req.Market)
+	writeJSON(w, http.StatusOK, executor.PausedMarkets())
+}
+
+func blacklist(w http.ResponseWriter, r *http.Request) {
+	var req borrowerReq
+	if !decode(w, r, &req) {
+		return
+	}
+	if !common.IsHexAddress(req.Borrower) {
+		writeError(w, http.StatusBadRequest, errors.New("invalid borrower address"))
+		return
+	}
+	executor.Blacklist(req.Borrower)
+	log.Printf("admin: blacklist borrower %s", req.Borrower)
+	writeJSON(w, http.StatusOK, executor.Blacklisted())
+}
+
+func scan(w http.ResponseWriter, r *http.Request) {
+	handler.TriggerScan()
+	log.Print("admin: scan triggered")
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "scanning"})
+}
+
+func liquidate(w http.ResponseWriter, r *http.Request) {
+	var req liquidateReq
+	if !decode(w, r, &req) {
+		return
+	}
+	for _, address := range []string{req.Borrower, req.RepayMarket, req.Collateral} {
+		if !common.IsHexAddress(address) {
+			writeError(w, http.StatusBadRequest, errors.New("invalid address: "+address))
+			return
+		}
+	}
+	amount, ok := new(big.Int).SetString(req.Amount, 10)
+	if !ok {
+		writeError(w, http.StatusBadRequest, errors.New("invalid amount"))
+		return
+	}
+
+	p, err := contract.GetProtocol(req.Protocol)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	plan := executor.Plan{
+		Protocol:      p.Name,
+		Borrower:      req.Borrower,
+		RepayMarket:   req.RepayMarket,
+		Collateral:    req.Collateral,
+		RepayAmount:   amount,
+		CorrelationID: log.NewCorrelationID(),
+	}
+	plan.Logger().Printf("admin: manual liquidation")
+	tx, err := executor.Execute(plan)
+	if err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"tx": tx})
+}
+
+func decode(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if r.ContentLength == 0 {
+		return true
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("admin api encode error: %s", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,278 @@
+// Package alert 把需要人工处理的事件（钱包余额不足、清算 revert、交易卡住、RPC 或 subgraph 不可用、
+// 无力清算的大额账户）发送到 webhook、Telegram 和 Slack，按事件类型设置级别，并做去重和限流
+package alert
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"liquidator/conf"
+	"liquidator/log"
+	"liquidator/utils"
+)
+
+type Severity int
+
+const (
+	Info Severity = iota
+	Warning
+	Critical
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Info:
+		return "info"
+	case Warning:
+		return "warning"
+	case Critical:
+		return "critical"
+	default:
+		return fmt.Sprintf("severity(%d)", int(s))
+	}
+}
+
+// ParseSeverity 空字符串为 Info
+func ParseSeverity(s string) (Severity, error) {
+	switch strings.ToLower(s) {
+	case "", "info":
+		return Info, nil
+	case "warning", "warn":
+		return Warning, nil
+	case "critical":
+		return Critical, nil
+	default:
+		return Info, fmt.Errorf("unknown alert severity %q", s)
+	}
+}
+
+// 事件类型
+const (
+	LowBalance          = "low_balance"
+	LiquidationReverted = "liquidation_reverted"
+	TxStuck             = "tx_stuck"
+	RPCDown             = "rpc_down"
+	SubgraphDown        = "subgraph_down"
+	Unaffordable        = "unaffordable"
+)
+
+// defaultSeverity 未在 alerts.severity 中配置的事件类型使用的级别
+var defaultSeverity = map[string]Severity{
+	LowBalance:          Warning,
+	LiquidationReverted: Critical,
+	TxStuck:             Warning,
+	RPCDown:             Critical,
+	SubgraphDown:        Critical,
+	Unaffordable:        Warning,
+}
+
+const (
+	defaultDedup     = 30 * time.Minute
+	defaultRateLimit = 20
+	queueSize        = 100
+)
+
+// Event 一次告警；同一 Type 和 Key 的事件在去重窗口内只发送一次
+type Event struct {
+	Type     string
+	Severity Severity
+	Key      string
+	Title    string
+	Message  string
+	Fields   map[string]string `json:",omitempty"`
+	Time     time.Time
+	// Suppressed 上一次发送之后被去重或限流的同类事件数量
+	Suppressed int `json:",omitempty"`
+}
+
+func (e Event) dedupKey() string {
+	return e.Type + ":" + e.Key
+}
+
+// Text 单条文本消息，Telegram 和 Slack 使用
+func (e Event) Text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[%s] %s", strings.ToUpper(e.Severity.String()), e.Title)
+	if e.Message != "" {
+		b.WriteString("\n" + e.Message)
+	}
+	keys := make([]string, 0, len(e.Fields))
+	for k := range e.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, "\n%s: %s", k, e.Fields[k])
+	}
+	if e.Suppressed > 0 {
+		fmt.Fprintf(&b, "\n(%d similar alerts suppressed)", e.Suppressed)
+	}
+	return b.String()
+}
+
+// Notifier 告警发送渠道
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, e Event) error
+}
+
+type target struct {
+	notifier    Notifier
+	minSeverity Severity
+}
+
+// Manager 对事件设置级别、去重、限流后放入队列，由后台 goroutine 依次发送到所有级别足够的渠道
+type Manager struct {
+	targets   []target
+	severity  map[string]Severity
+	dedup     time.Duration
+	rateLimit int
+	timeout   time.Duration
+
+	mu         sync.Mutex
+	lastSent   map[string]time.Time
+	suppressed map[string]int
+	sent       []time.Time
+	queue      chan Event
+}
+
+// New 按配置创建渠道，没有配置任何渠道时返回的 Manager 只记录日志
+func New(c conf.Alerts) (*Manager, error) {
+	m := &Manager{
+		severity:   make(map[string]Severity, len(defaultSeverity)),
+		dedup:      c.Dedup,
+		rateLimit:  c.RateLimit,
+		timeout:    10 * time.Second,
+		lastSent:   make(map[string]time.Time),
+		suppressed: make(map[string]int),
+		queue:      make(chan Event, queueSize),
+	}
+	if m.dedup <= 0 {
+		m.dedup = defaultDedup
+	}
+	if m.rateLimit <= 0 {
+		m.rateLimit = defaultRateLimit
+	}
+	for t, s := range defaultSeverity {
+		m.severity[t] = s
+	}
+	for t, s := range c.Severity {
+		severity, err := ParseSeverity(s)
+		if err != nil {
+			return nil, err
+		}
+		m.severity[strings.ToLower(t)] = severity
+	}
+	add := func(n Notifier, minSeverity string) error {
+		severity, err := ParseSeverity(minSeverity)
+		if err != nil {
+			return err
+		}
+		m.targets = append(m.targets, target{notifier: n, minSeverity: severity})
+		return nil
+	}
+	for _, w := range c.Webhooks {
+		if err := add(NewWebhook(w.Url), w.MinSeverity); err != nil {
+			return nil, err
+		}
+	}
+	for _, t := range c.Telegram {
+		if err := add(NewTelegram(t.ApiUrl, t.Token, t.ChatId), t.MinSeverity); err != nil {
+			return nil, err
+		}
+	}
+	for _, s := range c.Slack {
+		if err := add(NewSlack(s.Url), s.MinSeverity); err != nil {
+			return nil, err
+		}
+	}
+	utils.Go("alert", m.run)
+	return m, nil
+}
+
+// Send 设置级别并去重、限流，被丢弃的事件只记录日志
+func (m *Manager) Send(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if s, ok := m.severity[e.Type]; ok {
+		e.Severity = s
+	}
+	logger := log.With("alert", e.Type, "severity", e.Severity, "key", e.Key)
+	if !m.allow(&e) {
+		logger.Debug("alert suppressed: %s", e.Title)
+		return
+	}
+	logger.Warn("alert: %s %s", e.Title, e.Message)
+	select {
+	case m.queue <- e:
+	default:
+		logger.Warn("alert queue full, drop: %s", e.Title)
+	}
+}
+
+// allow 去重窗口内的重复事件和超过每分钟上限的事件返回 false，并计入下一次发送的 Suppressed
+func (m *Manager) allow(e *Event) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := e.dedupKey()
+	if last, ok := m.lastSent[key]; ok && e.Time.Sub(last) < m.dedup {
+		m.suppressed[key]++
+		return false
+	}
+	cutoff := e.Time.Add(-time.Minute)
+	for len(m.sent) > 0 && !m.sent[0].After(cutoff) {
+		m.sent = m.sent[1:]
+	}
+	if len(m.sent) >= m.rateLimit {
+		m.suppressed[key]++
+		return false
+	}
+	m.sent = append(m.sent, e.Time)
+	m.lastSent[key] = e.Time
+	e.Suppressed = m.suppressed[key]
+	delete(m.suppressed, key)
+	return true
+}
+
+func (m *Manager) run() {
+	for e := range m.queue {
+		m.deliver(e)
+	}
+}
+
+func (m *Manager) deliver(e Event) {
+	for _, t := range m.targets {
+		if e.Severity < t.minSeverity {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+		if err := t.notifier.Notify(ctx, e); err != nil {
+			log.Printf("alert %s via %s error: %s", e.Type, t.notifier.Name(), err)
+		}
+		cancel()
+	}
+}
+
+var manager *Manager
+
+// Init 创建全局的 Manager，之后通过 Send 发送告警
+func Init(c conf.Alerts) error {
+	m, err := New(c)
+	if err != nil {
+		return err
+	}
+	manager = m
+	return nil
+}
+
+// Send 发送到全局的 Manager，未调用 Init 时不发送
+func Send(e Event) {
+	if manager != nil {
+		manager.Send(e)
+	}
+}
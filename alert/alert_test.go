@@ -0,0 +1,141 @@
+package alert
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"liquidator/conf"
+	"liquidator/log"
+)
+
+type request struct {
+	path string
+	body map[string]interface{}
+}
+
+// standIn 本地 HTTP 服务，代替 webhook、Telegram 和 Slack，收到的请求放入 channel
+func standIn(t *testing.T) (*httptest.Server, chan request) {
+	requests := make(chan request, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		body := make(map[string]interface{})
+		if err := json.Unmarshal(data, &body); err != nil {
+			t.Errorf("%s: %s", data, err)
+		}
+		requests <- request{path: r.URL.Path, body: body}
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+func receive(t *testing.T, requests chan request) request {
+	t.Helper()
+	select {
+	case r := <-requests:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("no request received")
+		return request{}
+	}
+}
+
+func expectNone(t *testing.T, requests chan request) {
+	t.Helper()
+	select {
+	case r := <-requests:
+		t.Fatalf("unexpected request %s %v", r.path, r.body)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func init() {
+	if err := log.Init(".", "", "", "DEBUG"); err != nil {
+		panic(err)
+	}
+}
+
+func TestNotifiers(t *testing.T) {
+	server, requests := standIn(t)
+	m, err := New(conf.Alerts{
+		Webhooks: []conf.AlertWebhook{{Url: server.URL + "/hook"}},
+		Telegram: []conf.AlertTelegram{{ApiUrl: server.URL, Token: "123:abc", ChatId: "42", MinSeverity: "critical"}},
+		Slack:    []conf.AlertWebhook{{Url: server.URL + "/slack", MinSeverity: "warning"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.Send(Event{Type: RPCDown, Key: "bsc", Title: "[bsc] all rpc endpoints unavailable", Fields: map[string]string{"chain": "bsc"}})
+	got := make(map[string]request)
+	for i := 0; i < 3; i++ {
+		r := receive(t, requests)
+		got[r.path] = r
+	}
+	hook := got["/hook"].body
+	if hook["Type"] != RPCDown || hook["Severity"] != float64(Critical) || hook["Key"] != "bsc" {
+		t.Errorf("webhook body = %v", hook)
+	}
+	telegram := got["/bot123:abc/sendMessage"].body
+	if telegram["chat_id"] != "42" || !strings.HasPrefix(telegram["text"].(string), "[CRITICAL] [bsc] all rpc endpoints unavailable") {
+		t.Errorf("telegram body = %v", telegram)
+	}
+	if slack := got["/slack"].body; !strings.Contains(slack["text"].(string), "chain: bsc") {
+		t.Errorf("slack body = %v", slack)
+	}
+
+	// tx_stuck 默认为 warning，Telegram 只接收 critical
+	m.Send(Event{Type: TxStuck, Key: "0x1", Title: "stuck"})
+	paths := []string{receive(t, requests).path, receive(t, requests).path}
+	expectNone(t, requests)
+	if !(paths[0] == "/hook" && paths[1] == "/slack" || paths[0] == "/slack" && paths[1] == "/hook") {
+		t.Errorf("paths = %v", paths)
+	}
+}
+
+func TestDedupAndRateLimit(t *testing.T) {
+	server, requests := standIn(t)
+	m, err := New(conf.Alerts{
+		Dedup:     time.Minute,
+		RateLimit: 2,
+		Severity:  map[string]string{"low_balance": "critical"},
+		Webhooks:  []conf.AlertWebhook{{Url: server.URL}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	m.Send(Event{Type: LowBalance, Key: "bsc:native", Title: "low", Time: now})
+	if r := receive(t, requests); r.body["Severity"] != float64(Critical) {
+		t.Errorf("severity override not applied: %v", r.body)
+	}
+	// 去重窗口内的同一事件不发送
+	m.Send(Event{Type: LowBalance, Key: "bsc:native", Title: "low", Time: now.Add(time.Second)})
+	m.Send(Event{Type: LowBalance, Key: "bsc:native", Title: "low", Time: now.Add(2 * time.Second)})
+	expectNone(t, requests)
+
+	// 每分钟最多 2 条
+	m.Send(Event{Type: TxStuck, Key: "0x1", Title: "stuck", Time: now.Add(3 * time.Second)})
+	receive(t, requests)
+	m.Send(Event{Type: TxStuck, Key: "0x2", Title: "stuck", Time: now.Add(4 * time.Second)})
+	expectNone(t, requests)
+
+	// 窗口过后再次发送，并带上被抑制的数量
+	m.Send(Event{Type: LowBalance, Key: "bsc:native", Title: "low", Time: now.Add(2 * time.Minute)})
+	if r := receive(t, requests); r.body["Suppressed"] != float64(2) {
+		t.Errorf("suppressed = %v", r.body["Suppressed"])
+	}
+}
+
+func TestInvalidSeverity(t *testing.T) {
+	if _, err := New(conf.Alerts{Severity: map[string]string{"rpc_down": "urgent"}}); err == nil {
+		t.Error("invalid severity accepted")
+	}
+	if _, err := New(conf.Alerts{Slack: []conf.AlertWebhook{{Url: "http://localhost", MinSeverity: "loud"}}}); err == nil {
+		t.Error("invalid min severity accepted")
+	}
+}
@@ -0,0 +1,104 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+const telegramAPI = "https://api.telegram.org"
+
+// postJSON 以 JSON 发送 body，非 2xx 状态码返回错误
+func postJSON(ctx context.Context, url string, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// Webhook 把整个 Event 以 JSON POST 到 URL
+type Webhook struct {
+	URL string
+}
+
+func NewWebhook(url string) *Webhook {
+	return &Webhook{URL: url}
+}
+
+func (w *Webhook) Name() string {
+	return "webhook"
+}
+
+func (w *Webhook) Notify(ctx context.Context, e Event) error {
+	return postJSON(ctx, w.URL, e)
+}
+
+// Telegram 通过 bot API 的 sendMessage 发送到 ChatID
+type Telegram struct {
+	APIURL string
+	Token  string
+	ChatID string
+}
+
+// NewTelegram apiURL 为空时使用 https://api.telegram.org
+func NewTelegram(apiURL, token, chatID string) *Telegram {
+	if apiURL == "" {
+		apiURL = telegramAPI
+	}
+	return &Telegram{APIURL: strings.TrimRight(apiURL, "/"), Token: token, ChatID: chatID}
+}
+
+func (t *Telegram) Name() string {
+	return "telegram"
+}
+
+func (t *Telegram) Notify(ctx context.Context, e Event) error {
+	err := postJSON(ctx, t.APIURL+"/bot"+t.Token+"/sendMessage", map[string]interface{}{
+		"chat_id":                  t.ChatID,
+		"text":                     e.Text(),
+		"disable_web_page_preview": true,
+	})
+	// 请求失败时错误信息中带有 URL，去掉其中的 bot token 再记录日志
+	if err != nil && t.Token != "" {
+		err = errors.New(strings.ReplaceAll(err.Error(), t.Token, "<token>"))
+	}
+	return err
+}
+
+// Slack Slack 兼容的 incoming webhook，只发送 text 字段
+type Slack struct {
+	URL string
+}
+
+func NewSlack(url string) *Slack {
+	return &Slack{URL: url}
+}
+
+func (s *Slack) Name() string {
+	return "slack"
+}
+
+func (s *Slack) Notify(ctx context.Context, e Event) error {
+	return postJSON(ctx, s.URL, map[string]string{"text": e.Text()})
+}
@@ -0,0 +1,411 @@
+package backtest
+
+import (
+	"errors"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/shopspring/decimal"
+
+	"liquidator/contract"
+	"liquidator/executor"
+)
+
+// 清算机会的结果
+const (
+	// OutcomeWon 按设定的延迟能抢在其他清算人之前完成清算
+	OutcomeWon = "won"
+	// OutcomeMissed 清算计划给不出可执行的方案，或账户在交易上链前恢复
+	OutcomeMissed = "missed"
+	// OutcomeCaptured 其他清算人在我们的交易上链前完成了清算
+	OutcomeCaptured = "captured"
+)
+
+// Options 回测参数
+type Options struct {
+	// Liquidator 我们的钱包地址，该地址发起的历史清算直接算作成功
+	Liquidator string
+	// Latency 发现机会到交易上链需要的区块数，默认 1
+	Latency uint64
+	// GasCost 每次清算的 gas 成本，单位与收益相同（美元）
+	GasCost decimal.Decimal
+}
+
+// Opportunity 一个清算机会：从账户出现 shortfall 开始，到被清算或恢复为止
+type Opportunity struct {
+	Borrower    string          `json:"borrower"`
+	OpenedAt    uint64          `json:"openedAt"`
+	ClosedAt    uint64          `json:"closedAt"`
+	Shortfall   decimal.Decimal `json:"shortfall"`
+	Market      string          `json:"market,omitempty"`
+	Collateral  string          `json:"collateral,omitempty"`
+	RepayAmount *big.Int        `json:"repayAmount,omitempty"`
+	Profit      decimal.Decimal `json:"profit"`
+	Outcome     string          `json:"outcome"`
+	Reason      string          `json:"reason,omitempty"`
+	Liquidator  string          `json:"liquidator,omitempty"`
+	Tx          string          `json:"tx,omitempty"`
+}
+
+// Report 回测结果，PnL 为成功机会的收益之和，Forgone 为被抢先的机会按我们的方案能得到的收益
+type Report struct {
+	From          uint64          `json:"from"`
+	To            uint64          `json:"to"`
+	Opportunities []*Opportunity  `json:"opportunities"`
+	Won           int             `json:"won"`
+	Missed        int             `json:"missed"`
+	Captured      int             `json:"captured"`
+	PnL           decimal.Decimal `json:"pnl"`
+	Forgone       decimal.Decimal `json:"forgone"`
+	// Competitors 其他清算人在回测区间内的清算次数
+	Competitors map[string]int `json:"competitors"`
+}
+
+type marketState struct {
+	symbol           string
+	listed           bool
+	collateralFactor *big.Int
+	price            *big.Int
+	exchangeRate     *big.Int
+	borrowIndex      *big.Int
+}
+
+type position struct {
+	pTokens       *big.Int
+	principal     *big.Int
+	interestIndex *big.Int
+}
+
+type engine struct {
+	opts     Options
+	params   contract.Params
+	markets  map[string]*marketState
+	accounts map[string]map[string]*position
+	names    map[string]string
+	open     map[string]*Opportunity
+	report   *Report
+}
+
+var errNoPrice = errors.New("price or exchange rate unavailable")
+
+func key(address string) string {
+	return strings.ToLower(address)
+}
+
+func orZero(x *big.Int) *big.Int {
+	if x == nil {
+		return new(big.Int)
+	}
+	return x
+}
+
+// Run 按区块和日志顺序回放记录，每个区块结束时用本地状态计算账户流动性，
+// 对出现 shortfall 的账户调用 executor.PlanFromSnapshot 生成清算方案
+func Run(records []Record, opts Options) *Report {
+	if opts.Latency == 0 {
+		opts.Latency = 1
+	}
+	e := &engine{
+		opts:     opts,
+		markets:  make(map[string]*marketState),
+		accounts: make(map[string]map[string]*position),
+		names:    make(map[string]string),
+		open:     make(map[string]*Opportunity),
+		report:   &Report{Competitors: make(map[string]int)},
+	}
+	if len(records) == 0 {
+		return e.report
+	}
+	e.report.From, e.report.To = records[0].Block, records[len(records)-1].Block
+	current := records[0].Block
+	for _, record := range records {
+		if record.Block != current {
+			e.evaluate(current)
+			current = record.Block
+		}
+		e.apply(record)
+	}
+	e.evaluate(current)
+
+	borrowers := make([]string, 0, len(e.open))
+	for borrower := range e.open {
+		borrowers = append(borrowers, borrower)
+	}
+	sort.Strings(borrowers)
+	for _, borrower := range borrowers {
+		opp := e.open[borrower]
+		delete(e.open, borrower)
+		opp.ClosedAt = e.report.To
+		if opp.Reason == "" && e.report.To < opp.OpenedAt+opts.Latency {
+			opp.Reason = "range ended before inclusion"
+		}
+		e.finish(opp, e.plannedOutcome(opp))
+	}
+	return e.report
+}
+
+func (e *engine) market(address string) *marketState {
+	return e.markets[key(address)]
+}
+
+func (e *engine) position(borrower, market string) *position {
+	b := key(borrower)
+	e.names[b] = borrower
+	if e.accounts[b] == nil {
+		e.accounts[b] = make(map[string]*position)
+	}
+	pos := e.accounts[b][key(market)]
+	if pos == nil {
+		pos = &position{pTokens: new(big.Int), principal: new(big.Int), interestIndex: new(big.Int)}
+		e.accounts[b][key(market)] = pos
+	}
+	return pos
+}
+
+func (e *engine) apply(r Record) {
+	if r.Type == RecordParams {
+		e.params.CloseFactor = decimal.NewFromBigInt(r.CloseFactor, -18)
+		e.params.LiquidationIncentive = decimal.NewFromBigInt(r.Incentive, -18)
+		return
+	}
+	if r.Type == RecordMarket {
+		e.markets[key(r.Market)] = &marketState{
+			symbol:           r.Symbol,
+			listed:           r.Listed,
+			collateralFactor: r.CollateralFactor,
+			price:            r.Price,
+			exchangeRate:     r.ExchangeRate,
+			borrowIndex:      r.BorrowIndex,
+		}
+		return
+	}
+	m := e.market(r.Market)
+	if m == nil {
+		return
+	}
+	switch r.Type {
+	case RecordPosition:
+		pos := e.position(r.Borrower, r.Market)
+		pos.pTokens, pos.principal, pos.interestIndex = orZero(r.PTokens), orZero(r.Borrow), m.borrowIndex
+	case RecordPrice:
+		m.price = r.Price
+		if r.ExchangeRate != nil {
+			m.exchangeRate = r.ExchangeRate
+		}
+	case RecordAccrueInterest:
+		m.borrowIndex = r.BorrowIndex
+	case RecordBorrow, RecordRepayBorrow:
+		pos := e.position(r.Borrower, r.Market)
+		pos.principal, pos.interestIndex = orZero(r.AccountBorrows), m.borrowIndex
+	case RecordLiquidateBorrow:
+		// 借款的减少由同一笔交易的 RepayBorrow 事件更新，这里只扣减抵押物
+		if e.market(r.Collateral) != nil {
+			pos := e.position(r.Borrower, r.Collateral)
+			pos.pTokens = new(big.Int).Sub(pos.pTokens, orZero(r.SeizeTokens))
+			if pos.pTokens.Sign() < 0 {
+				pos.pTokens.SetInt64(0)
+			}
+		}
+		e.liquidated(r)
+	}
+}
+
+// borrowBalance 与 borrowBalanceStored 相同：principal * borrowIndex / interestIndex
+func (e *engine) borrowBalance(pos *position, m *marketState) *big.Int {
+	if pos.principal == nil || pos.principal.Sign() == 0 {
+		return new(big.Int)
+	}
+	if pos.interestIndex == nil || pos.interestIndex.Sign() == 0 || m.borrowIndex == nil {
+		return pos.principal
+	}
+	balance := new(big.Int).Mul(pos.principal, m.borrowIndex)
+	return balance.Div(balance, pos.interestIndex)
+}
+
+// value 底层资产数量按预言机价格折算为美元
+func value(amount, price *big.Int) decimal.Decimal {
+	if amount == nil || price == nil {
+		return decimal.Zero
+	}
+	return decimal.NewFromBigInt(amount, 0).Mul(decimal.NewFromBigInt(price, -36))
+}
+
+// shortfall 与 getAccountLiquidity 相同，只计算账户持有的市场
+func (e *engine) shortfall(borrower string) decimal.Decimal {
+	collateral, borrowed := decimal.Zero, decimal.Zero
+	for market, pos := range e.accounts[borrower] {
+		m := e.markets[market]
+		if pos.pTokens.Sign() > 0 && m.listed && m.price != nil && m.exchangeRate != nil && m.collateralFactor != nil {
+			underlying := decimal.NewFromBigInt(pos.pTokens, 0).Mul(decimal.NewFromBigInt(m.exchangeRate, -18))
+			collateral = collateral.Add(underlying.Mul(decimal.NewFromBigInt(m.price, -36)).Mul(decimal.NewFromBigInt(m.collateralFactor, -18)))
+		}
+		borrowed = borrowed.Add(value(e.borrowBalance(pos, m), m.price))
+	}
+	return borrowed.Sub(collateral)
+}
+
+func (e *engine) evaluate(block uint64) {
+	borrowers := make([]string, 0, len(e.accounts))
+	for borrower := range e.accounts {
+		borrowers = append(borrowers, borrower)
+	}
+	sort.Strings(borrowers)
+	for _, borrower := range borrowers {
+		shortfall := e.shortfall(borrower)
+		opp := e.open[borrower]
+		switch {
+		case shortfall.IsPositive() && opp == nil:
+			e.open[borrower] = e.plan(borrower, block, shortfall)
+		case !shortfall.IsPositive() && opp != nil:
+			delete(e.open, borrower)
+			opp.ClosedAt = block
+			if opp.Reason == "" && block < opp.OpenedAt+e.opts.Latency {
+				opp.Reason = "recovered before inclusion"
+			}
+			e.finish(opp, e.plannedOutcome(opp))
+		}
+	}
+}
+
+// seize 与 liquidateCalculateSeizeTokens 相同：repay * incentive * priceBorrowed / (priceCollateral * exchangeRate)
+func (e *engine) seize(borrowed *marketState) executor.SeizeFunc {
+	incentive := e.params.LiquidationIncentive.Shift(18).BigInt()
+	return func(collaterals []string, repayAmount *big.Int) ([]*big.Int, error) {
+		result := make([]*big.Int, len(collaterals))
+		for i, collateral := range collaterals {
+			m := e.market(collateral)
+			if m == nil || borrowed.price == nil || m.price == nil || m.price.Sign() == 0 || m.exchangeRate == nil || m.exchangeRate.Sign() == 0 {
+				return nil, errNoPrice
+			}
+			numerator := new(big.Int).Mul(repayAmount, incentive)
+			numerator.Mul(numerator, borrowed.price)
+			denominator := new(big.Int).Mul(m.price, m.exchangeRate)
+			result[i] = numerator.Div(numerator, denominator)
+		}
+		return result, nil
+	}
+}
+
+// plan 对账户的每个借款市场生成清算方案，选出偿还价值最大的一个
+func (e *engine) plan(borrower string, block uint64, shortfall decimal.Decimal) *Opportunity {
+	opp := &Opportunity{Borrower: e.names[borrower], OpenedAt: block, Shortfall: shortfall}
+	if e.params.CloseFactor.IsZero() {
+		opp.Reason = "missing protocol params"
+		return opp
+	}
+	markets := make([]string, 0)
+	collaterals := make([]contract.CollateralSnapshot, 0)
+	for market := range e.accounts[borrower] {
+		markets = append(markets, market)
+	}
+	sort.Strings(markets)
+	for _, market := range markets {
+		pos, m := e.accounts[borrower][market], e.markets[market]
+		if pos.pTokens.Sign() > 0 && m.collateralFactor != nil {
+			collaterals = append(collaterals, contract.CollateralSnapshot{
+				Market:           market,
+				Listed:           m.listed,
+				CollateralFactor: decimal.NewFromBigInt(m.collateralFactor, -18),
+				Balance:          pos.pTokens,
+			})
+		}
+	}
+
+	best := decimal.Zero
+	opp.Reason = "no borrow"
+	for _, market := range markets {
+		m := e.markets[market]
+		balance := e.borrowBalance(e.accounts[borrower][market], m)
+		if balance.Sign() == 0 {
+			continue
+		}
+		if !m.listed {
+			opp.Reason = "borrow market not listed"
+			continue
+		}
+		snapshot := &contract.BorrowerSnapshot{
+			Block:         new(big.Int).SetUint64(block),
+			Borrower:      opp.Borrower,
+			Market:        market,
+			BorrowBalance: balance,
+			MarketListed:  m.listed,
+			Collaterals:   collaterals,
+		}
+		repayAmount, collateral, err := executor.PlanFromSnapshot(snapshot, e.params, e.seize(m))
+		if err != nil {
+			opp.Reason = err.Error()
+			continue
+		}
+		if collateral == "" || repayAmount.Sign() == 0 {
+			opp.Reason = "no collateral covers seize amount"
+			continue
+		}
+		repayValue := value(repayAmount, m.price)
+		if repayValue.GreaterThan(best) {
+			best = repayValue
+			opp.Market, opp.Collateral, opp.RepayAmount, opp.Reason = market, collateral, repayAmount, ""
+		}
+	}
+	if opp.Reason == "" {
+		opp.Profit = e.profit(best)
+	}
+	return opp
+}
+
+func (e *engine) profit(repayValue decimal.Decimal) decimal.Decimal {
+	return repayValue.Mul(e.params.LiquidationIncentive.Sub(decimal.NewFromInt(1))).Sub(e.opts.GasCost)
+}
+
+// liquidated 处理链上的清算事件：我们发起的算成功，其他清算人在延迟内完成的算被抢先
+func (e *engine) liquidated(r Record) {
+	borrower := key(r.Borrower)
+	ours := e.opts.Liquidator != "" && strings.EqualFold(r.Liquidator, e.opts.Liquidator)
+	if !ours {
+		e.report.Competitors[r.Liquidator]++
+	}
+	opp := e.open[borrower]
+	delete(e.open, borrower)
+	if opp == nil {
+		// 账户在同一个区块内变为可清算并被清算，区块结束时的检查看不到
+		opp = &Opportunity{Borrower: r.Borrower, OpenedAt: r.Block, Reason: "liquidated before detection"}
+	}
+	opp.ClosedAt, opp.Liquidator, opp.Tx = r.Block, r.Liquidator, r.Tx
+	switch {
+	case ours:
+		if m := e.market(r.Market); m != nil {
+			opp.Market, opp.Collateral, opp.RepayAmount = r.Market, r.Collateral, r.Amount
+			opp.Profit = e.profit(value(r.Amount, m.price))
+		}
+		opp.Reason = ""
+		e.finish(opp, OutcomeWon)
+	case r.Block < opp.OpenedAt+e.opts.Latency:
+		e.finish(opp, OutcomeCaptured)
+	default:
+		e.finish(opp, e.plannedOutcome(opp))
+	}
+}
+
+func (e *engine) plannedOutcome(opp *Opportunity) string {
+	if opp.Reason == "" {
+		return OutcomeWon
+	}
+	return OutcomeMissed
+}
+
+func (e *engine) finish(opp *Opportunity, outcome string) {
+	opp.Outcome = outcome
+	e.report.Opportunities = append(e.report.Opportunities, opp)
+	switch outcome {
+	case OutcomeWon:
+		e.report.Won++
+		e.report.PnL = e.report.PnL.Add(opp.Profit)
+	case OutcomeMissed:
+		e.report.Missed++
+	case OutcomeCaptured:
+		e.report.Captured++
+		if opp.Reason == "" {
+			e.report.Forgone = e.report.Forgone.Add(opp.Profit)
+		}
+	}
+}
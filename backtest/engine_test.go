@@ -0,0 +1,90 @@
+package backtest
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	pUSDC      = "0x00000000000000000000000000000000000a0001"
+	pETH       = "0x00000000000000000000000000000000000a0002"
+	borrower   = "0x00000000000000000000000000000000000b0001"
+	competitor = "0x00000000000000000000000000000000000c0001"
+)
+
+func mantissa(s string) *big.Int {
+	return decimal.RequireFromString(s).Shift(18).BigInt()
+}
+
+// records 借款人抵押 1 pETH 借 1400 USDC，区块 11 ETH 跌到 1700，区块 13 被竞争者清算
+func records() []Record {
+	return []Record{
+		{Block: 9, Type: RecordParams, CloseFactor: mantissa("0.5"), Incentive: mantissa("1.08")},
+		{Block: 9, Type: RecordMarket, Market: pUSDC, Symbol: "pUSDC", Listed: true, CollateralFactor: mantissa("0.8"),
+			Price: mantissa("1"), ExchangeRate: mantissa("1"), BorrowIndex: mantissa("1")},
+		{Block: 9, Type: RecordMarket, Market: pETH, Symbol: "pETH", Listed: true, CollateralFactor: mantissa("0.75"),
+			Price: mantissa("2000"), ExchangeRate: mantissa("1"), BorrowIndex: mantissa("1")},
+		{Block: 9, Index: 1, Type: RecordPosition, Market: pETH, Borrower: borrower, PTokens: mantissa("1"), Borrow: new(big.Int)},
+		{Block: 9, Index: 1, Type: RecordPosition, Market: pUSDC, Borrower: borrower, PTokens: new(big.Int), Borrow: mantissa("1400")},
+		{Block: 10, Index: 3, Type: RecordAccrueInterest, Market: pUSDC, BorrowIndex: mantissa("1")},
+		{Block: 11, Index: endOfBlock, Type: RecordPrice, Market: pETH, Price: mantissa("1700"), ExchangeRate: mantissa("1")},
+		{Block: 13, Index: 4, Type: RecordRepayBorrow, Market: pUSDC, Borrower: borrower, Amount: mantissa("700"), AccountBorrows: mantissa("700")},
+		{Block: 13, Index: 5, Type: RecordLiquidateBorrow, Market: pUSDC, Borrower: borrower, Liquidator: competitor, Collateral: pETH,
+			Amount: mantissa("700"), SeizeTokens: new(big.Int).Div(mantissa("756"), big.NewInt(1700))},
+	}
+}
+
+func TestRunWon(t *testing.T) {
+	report := Run(records(), Options{Latency: 1, GasCost: decimal.NewFromInt(6)})
+	if report.Won != 1 || report.Captured != 0 || report.Missed != 0 {
+		t.Fatalf("report = %+v", report)
+	}
+	opp := report.Opportunities[0]
+	if opp.OpenedAt != 11 || opp.ClosedAt != 13 || opp.Collateral != pETH || opp.RepayAmount.Cmp(mantissa("700")) != 0 {
+		t.Fatalf("opportunity = %+v", opp)
+	}
+	// 700 * 0.08 - 6
+	if !report.PnL.Equal(decimal.NewFromInt(50)) {
+		t.Errorf("pnl = %s, want 50", report.PnL)
+	}
+	if report.Competitors[competitor] != 1 {
+		t.Errorf("competitors = %v", report.Competitors)
+	}
+}
+
+func TestRunCaptured(t *testing.T) {
+	report := Run(records(), Options{Latency: 3})
+	if report.Captured != 1 || report.Won != 0 {
+		t.Fatalf("report = %+v", report)
+	}
+	if !report.PnL.IsZero() || !report.Forgone.Equal(decimal.NewFromInt(56)) {
+		t.Errorf("pnl = %s forgone = %s, want 0 and 56", report.PnL, report.Forgone)
+	}
+}
+
+func TestRunOwnLiquidation(t *testing.T) {
+	report := Run(records(), Options{Liquidator: competitor, Latency: 3})
+	if report.Won != 1 || len(report.Competitors) != 0 {
+		t.Fatalf("report = %+v", report)
+	}
+}
+
+func TestDumpRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteDump(&buf, records()); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadDump(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != len(records()) || read[6].Index != endOfBlock || read[6].Price.Cmp(mantissa("1700")) != 0 {
+		t.Fatalf("read = %+v", read)
+	}
+	if report := Run(read, Options{Latency: 1}); report.Won != 1 {
+		t.Errorf("report from dump = %+v", report)
+	}
+}
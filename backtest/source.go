@@ -0,0 +1,171 @@
+package backtest
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"liquidator/contract"
+	"liquidator/log"
+)
+
+// FromChain 从归档节点读取 [from, to] 内的回放数据：
+// 起始状态取 from-1 区块，事件用 Filter* 读取，价格在每个有事件的区块结束时读取，
+// priceStep 大于 0 时另外每隔 priceStep 个区块读取一次价格，用于发现没有事件的区块上的价格变化
+func FromChain(ctx context.Context, p *contract.Protocol, from, to, priceStep uint64) ([]Record, error) {
+	if from == 0 || to < from {
+		return nil, fmt.Errorf("invalid block range %d-%d", from, to)
+	}
+	start := from - 1
+	markets := p.Markets()
+	addresses := make([]string, 0, len(markets))
+	symbols := make(map[string]string, len(markets))
+	for _, m := range markets {
+		addresses = append(addresses, m.Address)
+		symbols[strings.ToLower(m.Address)] = m.Symbol
+	}
+
+	records := make([]Record, 0)
+	closeFactor, incentive, err := p.ParamsAt(ctx, start)
+	if err != nil {
+		return nil, err
+	}
+	records = append(records, Record{Block: start, Type: RecordParams, CloseFactor: closeFactor, Incentive: incentive})
+
+	borrowers := make(map[string]bool)
+	blocks := make(map[uint64]map[string]bool)
+	touch := func(block uint64, borrower string) {
+		if blocks[block] == nil {
+			blocks[block] = make(map[string]bool)
+		}
+		if borrower != "" {
+			blocks[block][borrower] = true
+			borrowers[borrower] = true
+		}
+	}
+	for _, market := range addresses {
+		events, err := p.FilterMarketEvents(ctx, market, from, to)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range events.AccrueInterest {
+			records = append(records, Record{Block: e.Raw.BlockNumber, Index: e.Raw.Index, Type: RecordAccrueInterest, Tx: e.Raw.TxHash.String(),
+				Market: market, BorrowIndex: e.BorrowIndex})
+			touch(e.Raw.BlockNumber, "")
+		}
+		for _, e := range events.Borrow {
+			records = append(records, Record{Block: e.Raw.BlockNumber, Index: e.Raw.Index, Type: RecordBorrow, Tx: e.Raw.TxHash.String(),
+				Market: market, Borrower: e.Borrower.String(), Amount: e.BorrowAmount, AccountBorrows: e.AccountBorrows})
+			touch(e.Raw.BlockNumber, e.Borrower.String())
+		}
+		for _, e := range events.RepayBorrow {
+			records = append(records, Record{Block: e.Raw.BlockNumber, Index: e.Raw.Index, Type: RecordRepayBorrow, Tx: e.Raw.TxHash.String(),
+				Market: market, Borrower: e.Borrower.String(), Amount: e.RepayAmount, AccountBorrows: e.AccountBorrows})
+			touch(e.Raw.BlockNumber, e.Borrower.String())
+		}
+		for _, e := range events.LiquidateBorrow {
+			records = append(records, Record{Block: e.Raw.BlockNumber, Index: e.Raw.Index, Type: RecordLiquidateBorrow, Tx: e.Raw.TxHash.String(),
+				Market: market, Borrower: e.Borrower.String(), Liquidator: e.Liquidator.String(), Collateral: e.PTokenCollateral.String(),
+				Amount: e.RepayAmount, SeizeTokens: e.SeizeTokens})
+			touch(e.Raw.BlockNumber, e.Borrower.String())
+		}
+	}
+
+	states, err := p.MarketStatesAt(ctx, start, addresses)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range states {
+		records = append(records, Record{Block: start, Type: RecordMarket, Market: s.Market, Symbol: symbols[strings.ToLower(s.Market)],
+			Listed: s.Listed, CollateralFactor: s.CollateralFactor, Price: s.Price, ExchangeRate: s.ExchangeRate, BorrowIndex: s.BorrowIndex})
+	}
+
+	accounts := make([]string, 0, len(borrowers))
+	for borrower := range borrowers {
+		accounts = append(accounts, borrower)
+	}
+	sort.Strings(accounts)
+	positions, err := p.PositionsAt(ctx, start, accounts, addresses)
+	if err != nil {
+		return nil, err
+	}
+	held := make(map[string]bool)
+	for _, position := range positions {
+		records = append(records, Record{Block: start, Index: 1, Type: RecordPosition, Market: position.Market, Borrower: position.Borrower,
+			PTokens: position.PTokens, Borrow: position.Borrow})
+		held[position.Borrower+"-"+position.Market] = true
+	}
+
+	sampled := make(map[uint64]bool)
+	if priceStep > 0 {
+		for block := from; block <= to; block += priceStep {
+			touch(block, "")
+			sampled[block] = true
+		}
+	}
+
+	// 只记录变化的价格和兑换率；仓位在借款人有事件的区块结束时重新读取，
+	// 事件里没有的存款和赎回由此得到更新，采样区块上读取所有借款人的仓位
+	last := make(map[string]contract.MarketState, len(states))
+	for _, s := range states {
+		last[s.Market] = s
+	}
+	sorted := make([]uint64, 0, len(blocks))
+	for block := range blocks {
+		sorted = append(sorted, block)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	for _, block := range sorted {
+		states, err := p.MarketStatesAt(ctx, block, addresses)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range states {
+			prev := last[s.Market]
+			if prev.Price != nil && prev.Price.Cmp(s.Price) == 0 && prev.ExchangeRate.Cmp(s.ExchangeRate) == 0 {
+				continue
+			}
+			records = append(records, Record{Block: block, Index: endOfBlock, Type: RecordPrice, Market: s.Market, Price: s.Price, ExchangeRate: s.ExchangeRate})
+			last[s.Market] = s
+		}
+
+		refresh := accounts
+		if !sampled[block] {
+			refresh = make([]string, 0, len(blocks[block]))
+			for borrower := range blocks[block] {
+				refresh = append(refresh, borrower)
+			}
+			sort.Strings(refresh)
+		}
+		if len(refresh) == 0 {
+			continue
+		}
+		positions, err := p.PositionsAt(ctx, block, refresh, addresses)
+		if err != nil {
+			return nil, err
+		}
+		current := make(map[string]bool, len(positions))
+		for _, position := range positions {
+			records = append(records, Record{Block: block, Index: endOfBlock, Type: RecordPosition, Market: position.Market, Borrower: position.Borrower,
+				PTokens: position.PTokens, Borrow: position.Borrow})
+			current[position.Borrower+"-"+position.Market] = true
+		}
+		// 清空后的仓位不会出现在 PositionsAt 的结果里
+		for _, borrower := range refresh {
+			for _, market := range addresses {
+				k := borrower + "-" + market
+				if held[k] && !current[k] {
+					records = append(records, Record{Block: block, Index: endOfBlock, Type: RecordPosition, Market: market, Borrower: borrower,
+						PTokens: new(big.Int), Borrow: new(big.Int)})
+				}
+				held[k] = current[k]
+			}
+		}
+	}
+
+	sortRecords(records)
+	log.Printf("[%s] backtest: recorded %d records, %d borrowers, %d price samples in %d-%d", p.Name, len(records), len(accounts), len(sorted), from, to)
+	return records, nil
+}
@@ -0,0 +1,665 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/shopspring/decimal"
+
+	"liquidator/backtest"
+	"liquidator/competitor"
+	"liquidator/conf"
+	"liquidator/contract"
+	"liquidator/executor"
+	"liquidator/handler"
+	"liquidator/risk"
+)
+
+const usage = `usage: liquidator [--config <path>] <command> [flags]
+
+--config defaults to $LIQUIDATOR_CONFIG, then ./conf/config.yaml; any setting can be
+overridden with LIQUIDATOR_<KEY> environment variables, e.g. LIQUIDATOR_WALLET
+
+every command except run accepts --protocol <name>, defaulting to the first
+configured protocol (approve and balances default to all protocols)
+
+commands:
+  run [--dry-run]           run the liquidation daemon (default), --dry-run simulates
+                            transactions with eth_call instead of sending them
+  scan [--json]             find underwater accounts once
+  inspect <borrower>        show per-market position and liquidation plan
+  health <borrower>         show health factor and price move to liquidation
+  scenario --shock "ETH=-20%" [--borrower <addr>]
+                            find borrowers liquidatable under price shocks
+  liquidate <borrower> --repay-market <pToken> --collateral <pToken> --amount <wei> [--dry-run]
+  approve [--market <pToken>]
+  redeem --market <pToken> [--amount <pTokens>] [--protocol <name>]
+  balances                  show wallet balances per market
+  backtest --from <block> --to <block> [--record <file>] [--latency <blocks>] [--gas-cost <usd>]
+  backtest --dump <file>    replay historical events through the planner without sending transactions
+  competitors [--file <file>] [--from <block> --to <block>]
+                            report which liquidators beat us, by how much and how fast`
+
+// chainCommands 执行前需要连接所有链的子命令。backtest 和 competitors 只在读取链上数据时才初始化链，
+// 与 help 一样可以离线运行
+var chainCommands = map[string]func([]string) error{
+	"run":       runCmd,
+	"scan":      scanCmd,
+	"inspect":   inspectCmd,
+	"health":    healthCmd,
+	"scenario":  scenarioCmd,
+	"liquidate": liquidateCmd,
+	"approve":   approveCmd,
+	"redeem":    redeemCmd,
+	"balances":  balancesCmd,
+}
+
+func runCommand(args []string) error {
+	if len(args) == 0 {
+		if err := contract.Init(); err != nil {
+			return err
+		}
+		runDaemon()
+		return nil
+	}
+
+	cmd, args := args[0], args[1:]
+	if run, ok := chainCommands[cmd]; ok {
+		if err := contract.Init(); err != nil {
+			return err
+		}
+		return run(args)
+	}
+	switch cmd {
+	case "backtest":
+		return backtestCmd(args)
+	case "competitors":
+		return competitorsCmd(args)
+	case "help", "-h", "--help":
+		fmt.Println(usage)
+		return nil
+	}
+	return fmt.Errorf("unknown command %q\n%s", cmd, usage)
+}
+
+func runCmd(args []string) error {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", conf.Config.DryRun.Enabled, "simulate liquidations and approvals instead of sending transactions")
+	fs.Parse(args)
+	for _, chain := range contract.Chains() {
+		chain.SetDryRun(*dryRun)
+	}
+	runDaemon()
+	return nil
+}
+
+// splitPositional 允许位置参数写在 flag 之前，例如 inspect <borrower> --json
+func splitPositional(args []string) (string, []string) {
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		return args[0], args[1:]
+	}
+	return "", args
+}
+
+func protocolFlag(fs *flag.FlagSet) *string {
+	return fs.String("protocol", "", "protocol name from config")
+}
+
+// selectProtocols name 为空时返回所有链上的所有协议
+func selectProtocols(name string) ([]*contract.Protocol, error) {
+	if name != "" {
+		p, err := contract.GetProtocol(name)
+		if err != nil {
+			return nil, err
+		}
+		return []*contract.Protocol{p}, nil
+	}
+	return contract.Protocols(), nil
+}
+
+func scanCmd(args []string) error {
+	fs := flag.NewFlagSet("scan", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print result as json")
+	fs.Parse(args)
+
+	handler.Init()
+	accounts, err := handler.ScanOnce()
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(accounts)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PROTOCOL\tBORROWER\tMARKET\tSHORTFALL")
+	for _, account := range accounts {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", account.Protocol, account.Borrower, account.Market, account.Shortfall)
+	}
+	return w.Flush()
+}
+
+func inspectCmd(args []string) error {
+	borrower, args := splitPositional(args)
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+	protocol := protocolFlag(fs)
+	asJSON := fs.Bool("json", false, "print result as json")
+	fs.Parse(args)
+	if borrower == "" {
+		borrower = fs.Arg(0)
+	}
+	if borrower == "" {
+		return errors.New("inspect: borrower required")
+	}
+	p, err := contract.GetProtocol(*protocol)
+	if err != nil {
+		return err
+	}
+
+	type position struct {
+		Market           string
+		Symbol           string
+		Supply           *big.Int
+		Borrow           *big.Int
+		CollateralFactor string
+		Plan             *executor.Plan `json:",omitempty"`
+		PlanError        string         `json:",omitempty"`
+	}
+	positions := make([]position, 0)
+	for _, market := range p.Markets() {
+		supply, borrow, _, err := p.Chain.GetAccountSnapshot(market.Address, borrower)
+		if err != nil {
+			return err
+		}
+		if supply.Sign() == 0 && borrow.Sign() == 0 {
+			continue
+		}
+		pos := position{
+			Market:           market.Address,
+			Symbol:           market.Symbol,
+			Supply:           supply,
+			Borrow:           borrow,
+			CollateralFactor: market.CollateralFactor.String(),
+		}
+		if borrow.Sign() > 0 {
+			plan, err := executor.PlanFor(p.Name, borrower, market.Address)
+			if err != nil {
+				pos.PlanError = err.Error()
+			} else {
+				pos.Plan = &plan
+			}
+		}
+		positions = append(positions, pos)
+	}
+	shortfall, err := p.GetShortfall(borrower)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return printJSON(map[string]interface{}{
+			"protocol":  p.Name,
+			"borrower":  borrower,
+			"shortfall": shortfall,
+			"positions": positions,
+		})
+	}
+
+	fmt.Printf("protocol:  %s\nborrower:  %s\nshortfall: %s\n\n", p.Name, borrower, shortfall)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "MARKET\tSYMBOL\tSUPPLY\tBORROW\tCF\tPLAN")
+	for _, pos := range positions {
+		plan := pos.PlanError
+		if pos.Plan != nil {
+			plan = fmt.Sprintf("repay %s seize %s", pos.Plan.RepayAmount, pos.Plan.Collateral)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", pos.Market, pos.Symbol, pos.Supply, pos.Borrow, pos.CollateralFactor, plan)
+	}
+	return w.Flush()
+}
+
+func healthCmd(args []string) error {
+	borrower, args := splitPositional(args)
+	fs := flag.NewFlagSet("health", flag.ExitOnError)
+	protocol := protocolFlag(fs)
+	asJSON := fs.Bool("json", false, "print result as json")
+	fs.Parse(args)
+	if borrower == "" {
+		borrower = fs.Arg(0)
+	}
+	if borrower == "" {
+		return errors.New("health: borrower required")
+	}
+	p, err := contract.GetProtocol(*protocol)
+	if err != nil {
+		return err
+	}
+
+	h, err := risk.Evaluate(p, borrower)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(h)
+	}
+	fmt.Printf("protocol:         %s\n", h.Protocol)
+	fmt.Printf("borrower:         %s\n", h.Borrower)
+	fmt.Printf("collateral value: %s\n", h.CollateralValue.Shift(-18).StringFixed(4))
+	fmt.Printf("borrow value:     %s\n", h.BorrowValue.Shift(-18).StringFixed(4))
+	fmt.Printf("liquidity:        %s\n", h.Liquidity)
+	fmt.Printf("shortfall:        %s\n", h.Shortfall)
+	if !h.HasDebt {
+		fmt.Println("health factor:    no debt")
+		return nil
+	}
+	fmt.Printf("health factor:    %s\n", h.HealthFactor.StringFixed(4))
+	fmt.Printf("price move:       -%s%%\n", h.PriceMove.Shift(2).StringFixed(2))
+	return nil
+}
+
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+func scenarioCmd(args []string) error {
+	var shockSpecs, borrowers stringList
+	fs := flag.NewFlagSet("scenario", flag.ExitOnError)
+	fs.Var(&shockSpecs, "shock", "price shock such as ETH=-20%, repeatable")
+	fs.Var(&borrowers, "borrower", "only evaluate these borrowers, repeatable")
+	protocol := protocolFlag(fs)
+	asJSON := fs.Bool("json", false, "print result as json")
+	fs.Parse(args)
+	p, err := contract.GetProtocol(*protocol)
+	if err != nil {
+		return err
+	}
+
+	shocks, err := risk.ParseShocks(shockSpecs.String())
+	if err != nil {
+		return err
+	}
+	if len(shocks) == 0 {
+		return errors.New("scenario: at least one --shock required")
+	}
+	if len(borrowers) == 0 {
+		handler.Init()
+		if borrowers, err = handler.DiscoverBorrowers(p.Name); err != nil {
+			return err
+		}
+	}
+
+	s, err := risk.RunScenario(p, borrowers, shocks)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(s)
+	}
+
+	fmt.Printf("protocol: %s\nscenario: %s\nliquidatable: %d of %d borrowers\n", p.Name, shocks, len(s.Liquidatable), len(borrowers))
+	if len(s.Skipped) > 0 {
+		fmt.Printf("skipped:  %d borrowers could not be loaded\n", len(s.Skipped))
+	}
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "BORROWER\tHEALTH\tBORROW VALUE\tSHORTFALL\tCURRENT SHORTFALL")
+	for _, h := range s.Liquidatable {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", h.Borrower, h.HealthFactor.StringFixed(4), h.BorrowValue.Shift(-18).StringFixed(2),
+			decimal.NewFromBigInt(h.Shortfall, -18).StringFixed(2), decimal.NewFromBigInt(h.CurrentShortfall, -18).StringFixed(2))
+	}
+	fmt.Fprintln(w, "\nMARKET\tSYMBOL\tREPAYABLE\tINVENTORY\tNEEDED")
+	for _, m := range s.Markets {
+		if m.Error != "" {
+			fmt.Fprintf(w, "%s\t%s\t%s\t?\t? (%s)\n", m.Market, m.Symbol, m.Repayable, m.Error)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", m.Market, m.Symbol, m.Repayable, m.Inventory, m.Needed)
+	}
+	return w.Flush()
+}
+
+func liquidateCmd(args []string) error {
+	borrower, args := splitPositional(args)
+	fs := flag.NewFlagSet("liquidate", flag.ExitOnError)
+	repayMarket := fs.String("repay-market", "", "pToken of the borrowed market")
+	collateral := fs.String("collateral", "", "pToken to seize")
+	amount := fs.String("amount", "", "repay amount in underlying wei")
+	dryRun := fs.Bool("dry-run", false, "only run the safety checks")
+	protocol := protocolFlag(fs)
+	fs.Parse(args)
+	if borrower == "" {
+		borrower = fs.Arg(0)
+	}
+	if borrower == "" || *repayMarket == "" || *collateral == "" || *amount == "" {
+		return errors.New("liquidate: borrower, --repay-market, --collateral and --amount are required")
+	}
+	repayAmount, ok := new(big.Int).SetString(*amount, 10)
+	if !ok {
+		return fmt.Errorf("liquidate: invalid amount %q", *amount)
+	}
+
+	p, err := contract.GetProtocol(*protocol)
+	if err != nil {
+		return err
+	}
+	plan := executor.Plan{
+		Protocol:    p.Name,
+		Borrower:    borrower,
+		RepayMarket: *repayMarket,
+		Collateral:  *collateral,
+		RepayAmount: repayAmount,
+	}
+	if *dryRun {
+		if err := executor.Check(plan); err != nil {
+			return err
+		}
+		fmt.Println("dry run: plan passed all checks")
+		return nil
+	}
+	tx, err := executor.Execute(plan)
+	if err != nil {
+		return err
+	}
+	fmt.Println(tx)
+	return nil
+}
+
+func approveCmd(args []string) error {
+	fs := flag.NewFlagSet("approve", flag.ExitOnError)
+	market := fs.String("market", "", "pToken to approve, all markets when empty")
+	protocol := protocolFlag(fs)
+	fs.Parse(args)
+
+	ps, err := selectProtocols(*protocol)
+	if err != nil {
+		return err
+	}
+	if *market != "" {
+		if ps, err = marketProtocol(ps, *market); err != nil {
+			return err
+		}
+	}
+	for _, p := range ps {
+		markets := []string{*market}
+		if *market == "" {
+			markets = p.GetAllMarkets()
+		}
+		for _, m := range markets {
+			tx, err := p.Chain.Approve(m)
+			if err != nil {
+				fmt.Printf("%s\terror: %s\n", m, err)
+				continue
+			}
+			fmt.Printf("%s\t%s\n", m, tx)
+		}
+	}
+	return nil
+}
+
+// marketProtocol 从 ps 中找出市场列表包含 market 的协议，没有或有多个时返回错误
+func marketProtocol(ps []*contract.Protocol, market string) ([]*contract.Protocol, error) {
+	var owners []*contract.Protocol
+	var names []string
+	for _, p := range ps {
+		if _, ok := p.GetMarket(market); ok {
+			owners = append(owners, p)
+			names = append(names, p.Name)
+		}
+	}
+	switch len(owners) {
+	case 0:
+		return nil, fmt.Errorf("approve: market %s not found, check --market and --protocol", market)
+	case 1:
+		return owners, nil
+	}
+	return nil, fmt.Errorf("approve: market %s found in protocols %s, use --protocol", market, strings.Join(names, ", "))
+}
+
+func redeemCmd(args []string) error {
+	fs := flag.NewFlagSet("redeem", flag.ExitOnError)
+	market := fs.String("market", "", "pToken to redeem")
+	amount := fs.String("amount", "", "pToken amount, whole balance when empty")
+	protocol := protocolFlag(fs)
+	fs.Parse(args)
+	if *market == "" {
+		return errors.New("redeem: --market is required")
+	}
+	p, err := contract.GetProtocol(*protocol)
+	if err != nil {
+		return err
+	}
+
+	redeemTokens, err := p.Chain.GetWalletAssetBalance(*market)
+	if err != nil {
+		return err
+	}
+	if *amount != "" {
+		var ok bool
+		if redeemTokens, ok = new(big.Int).SetString(*amount, 10); !ok {
+			return fmt.Errorf("redeem: invalid amount %q", *amount)
+		}
+	}
+	if redeemTokens.Sign() <= 0 {
+		return errors.New("redeem: nothing to redeem")
+	}
+	tx, err := p.Chain.Redeem(*market, redeemTokens)
+	if err != nil {
+		return err
+	}
+	fmt.Println(tx)
+	return nil
+}
+
+func balancesCmd(args []string) error {
+	fs := flag.NewFlagSet("balances", flag.ExitOnError)
+	protocol := protocolFlag(fs)
+	asJSON := fs.Bool("json", false, "print result as json")
+	fs.Parse(args)
+	ps, err := selectProtocols(*protocol)
+	if err != nil {
+		return err
+	}
+
+	type balance struct {
+		Chain      string
+		Wallet     string
+		Market     string
+		Symbol     string
+		Underlying *big.Int
+		PToken     *big.Int
+	}
+	balances := make([]balance, 0)
+	for _, p := range ps {
+		for _, market := range p.Markets() {
+			// 原生币市场没有 underlying
+			var underlying *big.Int
+			if market.Underlying != "" {
+				if underlying, err = p.Chain.GetWalletUnderlyingBalance(market.Address); err != nil {
+					return err
+				}
+			}
+			pToken, err := p.Chain.GetWalletAssetBalance(market.Address)
+			if err != nil {
+				return err
+			}
+			balances = append(balances, balance{
+				Chain:      p.Chain.Name,
+				Wallet:     p.Chain.WalletAddress(),
+				Market:     market.Address,
+				Symbol:     market.Symbol,
+				Underlying: underlying,
+				PToken:     pToken,
+			})
+		}
+	}
+	if *asJSON {
+		return printJSON(balances)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CHAIN\tWALLET\tMARKET\tSYMBOL\tUNDERLYING\tPTOKEN")
+	for _, b := range balances {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", b.Chain, b.Wallet, b.Market, b.Symbol, b.Underlying, b.PToken)
+	}
+	return w.Flush()
+}
+
+func backtestCmd(args []string) error {
+	fs := flag.NewFlagSet("backtest", flag.ExitOnError)
+	from := fs.Uint64("from", 0, "first block to replay")
+	to := fs.Uint64("to", 0, "last block to replay")
+	dump := fs.String("dump", "", "replay records from this file instead of the archive node")
+	record := fs.String("record", "", "write the records read from the archive node to this file")
+	priceStep := fs.Uint64("price-step", 0, "also sample prices and positions every n blocks")
+	latency := fs.Uint64("latency", 1, "blocks between detecting an opportunity and inclusion")
+	gasCost := fs.String("gas-cost", "0", "gas cost per liquidation in usd")
+	protocol := protocolFlag(fs)
+	asJSON := fs.Bool("json", false, "print result as json")
+	fs.Parse(args)
+
+	cost, err := decimal.NewFromString(*gasCost)
+	if err != nil {
+		return fmt.Errorf("backtest: invalid --gas-cost: %w", err)
+	}
+	opts := backtest.Options{Latency: *latency, GasCost: cost}
+
+	var records []backtest.Record
+	if *dump != "" {
+		f, err := os.Open(*dump)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if records, err = backtest.ReadDump(f); err != nil {
+			return fmt.Errorf("backtest: read %s: %w", *dump, err)
+		}
+	} else {
+		if *from == 0 || *to < *from {
+			return errors.New("backtest: --from and --to required without --dump")
+		}
+		if err := contract.Init(); err != nil {
+			return err
+		}
+		p, err := contract.GetProtocol(*protocol)
+		if err != nil {
+			return err
+		}
+		opts.Liquidator = p.Chain.WalletAddress()
+		if records, err = backtest.FromChain(context.Background(), p, *from, *to, *priceStep); err != nil {
+			return err
+		}
+		if *record != "" {
+			f, err := os.Create(*record)
+			if err != nil {
+				return err
+			}
+			if err := backtest.WriteDump(f, records); err != nil {
+				f.Close()
+				return err
+			}
+			if err := f.Close(); err != nil {
+				return err
+			}
+		}
+	}
+
+	report := backtest.Run(records, opts)
+	if *asJSON {
+		return printJSON(report)
+	}
+
+	fmt.Printf("blocks:   %d-%d\nwon:      %d\nmissed:   %d\ncaptured: %d\npnl:      %s\nforgone:  %s\n",
+		report.From, report.To, report.Won, report.Missed, report.Captured, report.PnL.StringFixed(2), report.Forgone.StringFixed(2))
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "BORROWER\tOPENED\tCLOSED\tOUTCOME\tPROFIT\tLIQUIDATOR\tREASON")
+	for _, o := range report.Opportunities {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\t%s\t%s\n", o.Borrower, o.OpenedAt, o.ClosedAt, o.Outcome, o.Profit.StringFixed(2), o.Liquidator, o.Reason)
+	}
+	fmt.Fprintln(w, "\nCOMPETITOR\tLIQUIDATIONS")
+	competitors := make([]string, 0, len(report.Competitors))
+	for liquidator := range report.Competitors {
+		competitors = append(competitors, liquidator)
+	}
+	sort.Strings(competitors)
+	for _, liquidator := range competitors {
+		fmt.Fprintf(w, "%s\t%d\n", liquidator, report.Competitors[liquidator])
+	}
+	return w.Flush()
+}
+
+func competitorsCmd(args []string) error {
+	fs := flag.NewFlagSet("competitors", flag.ExitOnError)
+	file := fs.String("file", conf.Config.Competitors.Store, "competitor records written by the daemon")
+	from := fs.Uint64("from", 0, "read liquidations from this block on chain instead of the file")
+	to := fs.Uint64("to", 0, "last block to read, defaults to the head block")
+	protocol := protocolFlag(fs)
+	asJSON := fs.Bool("json", false, "print result as json")
+	fs.Parse(args)
+
+	var records []competitor.Record
+	if *from > 0 {
+		if err := contract.Init(); err != nil {
+			return err
+		}
+		p, err := contract.GetProtocol(*protocol)
+		if err != nil {
+			return err
+		}
+		ctx := context.Background()
+		end := *to
+		if end == 0 {
+			if end, err = p.Chain.GetBlockNumber(ctx); err != nil {
+				return err
+			}
+		}
+		if records, err = competitor.Backfill(ctx, p, *from, end); err != nil {
+			return err
+		}
+	} else {
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if records, err = competitor.Load(f); err != nil {
+			return fmt.Errorf("competitors: read %s: %w", *file, err)
+		}
+	}
+
+	report := competitor.BuildReport(records)
+	if *asJSON {
+		return printJSON(report)
+	}
+	fmt.Printf("liquidations: %d\nours:         %d (profit %s)\nbeat us:      %d (profit %s)\n",
+		report.Total, report.Ours, report.OurProfit.StringFixed(2), report.BeatUs, report.LostProfit.StringFixed(2))
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "LIQUIDATOR\tCOUNT\tBEAT US\tPROFIT\tGAS PRICE\tTIP\tPREMIUM\tLEAD\tTX INDEX")
+	for _, s := range report.Competitors {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\t%s\t%s\t%s\t%.1f\n", s.Liquidator, s.Liquidations, s.BeatUs, s.Profit.StringFixed(2),
+			s.AvgGasPrice.StringFixed(2), s.AvgPriorityFee.StringFixed(2), s.FeePremium.StringFixed(2), s.AvgLead, s.AvgTxIndex)
+	}
+	return w.Flush()
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -0,0 +1,121 @@
+package competitor
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Stats 一个清算人在报告区间内的统计
+type Stats struct {
+	Liquidator   string
+	Liquidations int
+	// BeatUs 其中我们也排队了同一个借款人的次数
+	BeatUs     int
+	RepayValue decimal.Decimal
+	Profit     decimal.Decimal
+	// AvgGasPrice、AvgPriorityFee 平均 gas 单价和 priority fee，单位 gwei
+	AvgGasPrice    decimal.Decimal
+	AvgPriorityFee decimal.Decimal
+	// FeePremium 比我们同一机会的 gas 单价平均高出多少（gwei），只统计有我们交易的清算
+	FeePremium decimal.Decimal
+	// AvgLead 从我们发现账户资不抵债到其清算上链的平均时间，只统计我们发现过的清算
+	AvgLead time.Duration
+	// AvgTxIndex 清算交易在区块中的平均位置
+	AvgTxIndex float64
+}
+
+// Report 按清算人汇总的竞争报告，Competitors 按抢先我们的次数、清算次数降序排列
+type Report struct {
+	Total       int
+	Ours        int
+	BeatUs      int
+	OurProfit   decimal.Decimal
+	LostProfit  decimal.Decimal
+	Competitors []Stats
+}
+
+var gwei = decimal.New(1, 9)
+
+// BuildReport 汇总记录，我们自己的清算只计入 Ours 和 OurProfit
+func BuildReport(records []Record) Report {
+	report := Report{}
+	type acc struct {
+		stats                       Stats
+		gasPrice, priorityFee, prem decimal.Decimal
+		gasN, priorityN, premN, tx  int
+		lead                        time.Duration
+		leadN                       int
+	}
+	byLiquidator := make(map[string]*acc)
+	for _, r := range records {
+		report.Total++
+		if r.Ours {
+			report.Ours++
+			report.OurProfit = report.OurProfit.Add(r.Profit)
+			continue
+		}
+		key := strings.ToLower(r.Liquidator)
+		a := byLiquidator[key]
+		if a == nil {
+			a = &acc{stats: Stats{Liquidator: r.Liquidator}}
+			byLiquidator[key] = a
+		}
+		a.stats.Liquidations++
+		a.stats.RepayValue = a.stats.RepayValue.Add(r.RepayValue)
+		a.stats.Profit = a.stats.Profit.Add(r.Profit)
+		a.tx += int(r.TxIndex)
+		if r.Queued {
+			a.stats.BeatUs++
+			report.BeatUs++
+			report.LostProfit = report.LostProfit.Add(r.Profit)
+		}
+		if r.GasPrice != nil {
+			a.gasPrice = a.gasPrice.Add(decimal.NewFromBigInt(r.GasPrice, 0))
+			a.gasN++
+			if r.OurGasPrice != nil {
+				a.prem = a.prem.Add(decimal.NewFromBigInt(r.GasPrice, 0).Sub(decimal.NewFromBigInt(r.OurGasPrice, 0)))
+				a.premN++
+			}
+		}
+		if r.PriorityFee != nil {
+			a.priorityFee = a.priorityFee.Add(decimal.NewFromBigInt(r.PriorityFee, 0))
+			a.priorityN++
+		}
+		if !r.DetectedAt.IsZero() {
+			a.lead += r.Lead
+			a.leadN++
+		}
+	}
+
+	for _, a := range byLiquidator {
+		s := a.stats
+		s.AvgTxIndex = float64(a.tx) / float64(s.Liquidations)
+		if a.gasN > 0 {
+			s.AvgGasPrice = a.gasPrice.Div(decimal.NewFromInt(int64(a.gasN))).Div(gwei)
+		}
+		if a.priorityN > 0 {
+			s.AvgPriorityFee = a.priorityFee.Div(decimal.NewFromInt(int64(a.priorityN))).Div(gwei)
+		}
+		if a.premN > 0 {
+			s.FeePremium = a.prem.Div(decimal.NewFromInt(int64(a.premN))).Div(gwei)
+		}
+		if a.leadN > 0 {
+			s.AvgLead = a.lead / time.Duration(a.leadN)
+		}
+		report.Competitors = append(report.Competitors, s)
+	}
+	sort.Slice(report.Competitors, func(i, j int) bool {
+		a, b := report.Competitors[i], report.Competitors[j]
+		if a.BeatUs != b.BeatUs {
+			return a.BeatUs > b.BeatUs
+		}
+		if a.Liquidations != b.Liquidations {
+			return a.Liquidations > b.Liquidations
+		}
+		return a.Liquidator < b.Liquidator
+	})
+	return report
+}
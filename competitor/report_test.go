@@ -0,0 +1,69 @@
+package competitor
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"liquidator/contract"
+)
+
+func record(liquidator string, gasPrice, ourGasPrice int64, queued bool, lead time.Duration, profit int64) Record {
+	r := Record{
+		Liquidation: contract.Liquidation{
+			Liquidator:  liquidator,
+			GasPrice:    big.NewInt(gasPrice * 1e9),
+			PriorityFee: big.NewInt(1e9),
+			TxIndex:     2,
+		},
+		Queued: queued,
+		Profit: decimal.NewFromInt(profit),
+	}
+	if ourGasPrice > 0 {
+		r.OurGasPrice = big.NewInt(ourGasPrice * 1e9)
+	}
+	if lead > 0 {
+		r.DetectedAt = time.Unix(1000, 0)
+		r.Lead = lead
+	}
+	return r
+}
+
+func TestBuildReport(t *testing.T) {
+	ours := record("0xours", 30, 0, false, 0, 40)
+	ours.Ours = true
+	report := BuildReport([]Record{
+		ours,
+		record("0xfast", 50, 30, true, 2*time.Second, 100),
+		record("0xFAST", 70, 30, true, 4*time.Second, 60),
+		record("0xslow", 20, 0, false, 0, 10),
+		record("0xslow", 20, 0, false, 0, 10),
+		record("0xslow", 20, 0, false, 0, 10),
+	})
+	if report.Total != 6 || report.Ours != 1 || report.BeatUs != 2 {
+		t.Fatalf("report = %+v", report)
+	}
+	if !report.OurProfit.Equal(decimal.NewFromInt(40)) || !report.LostProfit.Equal(decimal.NewFromInt(160)) {
+		t.Errorf("our profit = %s, lost = %s", report.OurProfit, report.LostProfit)
+	}
+	if len(report.Competitors) != 2 {
+		t.Fatalf("competitors = %+v", report.Competitors)
+	}
+	// 抢先我们的排在前面，大小写不同的地址合并
+	fast := report.Competitors[0]
+	if fast.Liquidator != "0xfast" || fast.Liquidations != 2 || fast.BeatUs != 2 {
+		t.Fatalf("fast = %+v", fast)
+	}
+	if !fast.AvgGasPrice.Equal(decimal.NewFromInt(60)) || !fast.FeePremium.Equal(decimal.NewFromInt(30)) || !fast.AvgPriorityFee.Equal(decimal.NewFromInt(1)) {
+		t.Errorf("fast fees = %s %s %s", fast.AvgGasPrice, fast.FeePremium, fast.AvgPriorityFee)
+	}
+	if fast.AvgLead != 3*time.Second || fast.AvgTxIndex != 2 {
+		t.Errorf("fast lead = %s tx index = %v", fast.AvgLead, fast.AvgTxIndex)
+	}
+	slow := report.Competitors[1]
+	if slow.Liquidations != 3 || slow.BeatUs != 0 || !slow.FeePremium.IsZero() || slow.AvgLead != 0 {
+		t.Errorf("slow = %+v", slow)
+	}
+}
@@ -0,0 +1,141 @@
+// Package competitor 记录我们市场上的每一次链上清算，与我们自己的清算机会对比，
+// 统计哪些清算人抢在我们前面、收益和手续费高出多少、从我们发现机会到他们上链用了多久
+package competitor
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"liquidator/contract"
+	"liquidator/log"
+)
+
+// recentSize 内存中保留的记录数量
+const recentSize = 5000
+
+// Record 一次链上清算及当时我们这边的状态
+type Record struct {
+	contract.Liquidation
+	Chain string
+	// Ours 清算人是我们的钱包
+	Ours bool
+	// Queued 清算发生时我们在队列、执行中或推迟队列里有同一个借款人，补齐的历史记录没有该信息
+	Queued bool
+	// DetectedAt 我们第一次发现该账户资不抵债的时间，没有发现时为零值
+	DetectedAt time.Time `json:",omitempty"`
+	// Lead 清算所在区块时间 - DetectedAt
+	Lead time.Duration `json:",omitempty"`
+	// OurTx、OurGasPrice 我们对同一个借款人的清算交易（dryRun 为模拟 ID）及 gas 单价
+	OurTx       string   `json:",omitempty"`
+	OurGasPrice *big.Int `json:",omitempty"`
+	// RepayValue、SeizeValue 按记录时的预言机价格折算的美元价值，Profit 为两者之差，不含 gas
+	RepayValue decimal.Decimal
+	SeizeValue decimal.Decimal
+	Profit     decimal.Decimal
+}
+
+func (r Record) key() string {
+	return logKey(r.Tx, r.LogIndex)
+}
+
+func logKey(tx string, index uint) string {
+	return fmt.Sprintf("%s:%d", tx, index)
+}
+
+func known(tx string, index uint) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return seen[logKey(tx, index)]
+}
+
+var (
+	mu      sync.Mutex
+	file    *os.File
+	records []Record
+	seen    = make(map[string]bool)
+)
+
+// Init 打开记录文件，path 为空时只保存在内存中
+func Init(path string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	if file != nil {
+		file.Close()
+		file = nil
+	}
+	if path == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	file = f
+	return nil
+}
+
+// add 保存一条记录，同一个日志只保存一次；返回是否为新记录
+func add(r Record) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	if seen[r.key()] {
+		return false
+	}
+	seen[r.key()] = true
+	records = append(records, r)
+	if len(records) > recentSize {
+		for _, old := range records[:len(records)-recentSize] {
+			delete(seen, old.key())
+		}
+		records = records[len(records)-recentSize:]
+	}
+	if file == nil {
+		return true
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		log.Printf("competitor record %s marshal error: %s", r.Tx, err)
+		return true
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		log.Printf("competitor record %s write error: %s", r.Tx, err)
+	}
+	return true
+}
+
+// Records 返回内存中最近的记录，按处理先后排列
+func Records() []Record {
+	mu.Lock()
+	defer mu.Unlock()
+	return append([]Record(nil), records...)
+}
+
+// Load 读取记录文件
+func Load(r io.Reader) ([]Record, error) {
+	result := make([]Record, 0)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, err
+		}
+		result = append(result, record)
+	}
+	return result, scanner.Err()
+}
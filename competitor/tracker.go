@@ -0,0 +1,206 @@
+package competitor
+
+import (
+	"context"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"liquidator/contract"
+	"liquidator/executor"
+	"liquidator/handler"
+	"liquidator/log"
+	"liquidator/utils"
+)
+
+// defaultPollInterval 链没有配置 blockTime 时的轮询间隔
+const defaultPollInterval = 15 * time.Second
+
+// Start 为每个协议启动一个 goroutine：先补齐最近 backfill 个区块的清算，
+// 再订阅 LiquidateBorrow，节点不支持订阅或订阅断开时改为按出块间隔轮询
+func Start(backfill uint64) {
+	for _, p := range contract.Protocols() {
+		p := p
+		utils.Go(p.Name, func() { track(p, backfill) })
+	}
+}
+
+func track(p *contract.Protocol, backfill uint64) {
+	ctx := context.Background()
+	head, err := p.Chain.GetBlockNumber(ctx)
+	for err != nil {
+		log.Printf("[%s] competitor: get block number error: %s", p.Name, err)
+		time.Sleep(pollInterval(p))
+		head, err = p.Chain.GetBlockNumber(ctx)
+	}
+	if backfill > 0 && head > backfill {
+		if _, err := Backfill(ctx, p, head-backfill+1, head); err != nil {
+			log.Printf("[%s] competitor: backfill error: %s", p.Name, err)
+		}
+	}
+	last := head
+
+	events := make(chan *contract.PtokenLiquidateBorrow, 100)
+	sub, err := p.WatchLiquidateBorrow(ctx, events)
+	if err != nil {
+		log.Printf("[%s] competitor: watch LiquidateBorrow unavailable, polling instead: %s", p.Name, err)
+		poll(ctx, p, last)
+		return
+	}
+	for {
+		select {
+		case e := <-events:
+			if e.Raw.Removed {
+				continue
+			}
+			handle(ctx, p, e, true)
+			// 同一区块可能还有没收到的事件，轮询时从该区块重新读取
+			if e.Raw.BlockNumber > last+1 {
+				last = e.Raw.BlockNumber - 1
+			}
+		case err := <-sub.Err():
+			log.Printf("[%s] competitor: subscription error, polling instead: %s", p.Name, err)
+			// 订阅断开期间的事件由轮询补齐，重复的日志会被忽略
+			poll(ctx, p, last)
+			return
+		}
+	}
+}
+
+func pollInterval(p *contract.Protocol) time.Duration {
+	if p.Chain.BlockTime > 0 {
+		return p.Chain.BlockTime
+	}
+	return defaultPollInterval
+}
+
+// poll 每个出块间隔读取一次 last 之后的清算事件
+func poll(ctx context.Context, p *contract.Protocol, last uint64) {
+	ticker := time.NewTicker(pollInterval(p))
+	defer ticker.Stop()
+	for range ticker.C {
+		head, err := p.Chain.GetBlockNumber(ctx)
+		if err != nil {
+			log.Printf("[%s] competitor: get block number error: %s", p.Name, err)
+			continue
+		}
+		if head <= last {
+			continue
+		}
+		events, err := p.FilterLiquidateBorrow(ctx, last+1, head)
+		if err != nil {
+			log.Printf("[%s] competitor: filter LiquidateBorrow %d-%d error: %s", p.Name, last+1, head, err)
+			continue
+		}
+		for _, e := range events {
+			handle(ctx, p, e, true)
+		}
+		last = head
+	}
+}
+
+// Backfill 读取 [from, to] 内的历史清算并保存，历史记录不与我们当时的队列对比
+func Backfill(ctx context.Context, p *contract.Protocol, from, to uint64) ([]Record, error) {
+	events, err := p.FilterLiquidateBorrow(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Record, 0, len(events))
+	for _, e := range events {
+		if r, ok := handle(ctx, p, e, false); ok {
+			result = append(result, r)
+		}
+	}
+	log.Printf("[%s] competitor: backfilled %d liquidations in %d-%d", p.Name, len(result), from, to)
+	return result, nil
+}
+
+// handle 补全一次清算的手续费和价值，live 时与我们当前的队列和已发现的账户对比；
+// 已经记录过的日志返回 false
+func handle(ctx context.Context, p *contract.Protocol, e *contract.PtokenLiquidateBorrow, live bool) (Record, bool) {
+	if known(e.Raw.TxHash.String(), e.Raw.Index) {
+		return Record{}, false
+	}
+	l, err := p.LiquidationDetails(ctx, e)
+	if err != nil {
+		log.Printf("[%s] competitor: liquidation %s details error: %s", p.Name, e.Raw.TxHash, err)
+	}
+	r := Record{Liquidation: *l, Chain: p.Chain.Name}
+	r.Ours = strings.EqualFold(r.Liquidator, p.Chain.WalletAddress())
+	if live {
+		compare(ctx, p, &r)
+	}
+	if err := value(ctx, p, &r); err != nil {
+		log.Printf("[%s] competitor: liquidation %s value error: %s", p.Name, r.Tx, err)
+	}
+	if !add(r) {
+		return r, false
+	}
+	log.Printf("[%s] liquidation by %s: borrower=%s repay=%s %s seize=%s %s block=%d tx#%d gasPrice=%s tip=%s profit=%s ours=%t queued=%t lead=%s",
+		p.Name, r.Liquidator, r.Borrower, r.RepayAmount, r.Market, r.SeizeTokens, r.Collateral, r.Block, r.TxIndex,
+		r.GasPrice, r.PriorityFee, r.Profit.StringFixed(2), r.Ours, r.Queued, r.Lead)
+	return r, true
+}
+
+// compare 检查清算发生时我们是否也有同一个借款人的清算机会
+func compare(ctx context.Context, p *contract.Protocol, r *Record) {
+	same := func(protocol, borrower string) bool {
+		return protocol == p.Name && strings.EqualFold(borrower, r.Borrower)
+	}
+	for _, token := range handler.Queued() {
+		if same(token.Protocol, token.Account.Id) {
+			r.Queued = true
+		}
+	}
+	for _, d := range executor.DeferredLiquidations() {
+		if same(d.Token.Protocol, d.Token.Account.Id) {
+			r.Queued = true
+		}
+	}
+	// 只统计事件所在区块出块时仍在等待结果的清算，之前已结束的清算不算我们也在排队
+	at := r.BlockTime
+	if at.IsZero() {
+		at = time.Now()
+	}
+	for _, l := range executor.Liquidations() {
+		if !same(l.Plan.Protocol, l.Plan.Borrower) || !l.PendingAt(at) {
+			continue
+		}
+		r.Queued = true
+		r.OurTx = l.Tx
+		if l.Tx == "" {
+			continue
+		}
+		if p.Chain.DryRun() {
+			if sim, ok := p.Chain.Simulation(l.Tx); ok {
+				r.OurGasPrice = sim.GasPrice
+			}
+		} else if price, err := p.Chain.TxGasPrice(ctx, l.Tx); err == nil {
+			r.OurGasPrice = price
+		}
+	}
+	for _, account := range handler.UnderwaterAccounts() {
+		if same(account.Protocol, account.Borrower) && !account.Since.IsZero() {
+			r.DetectedAt = account.Since
+			if !r.BlockTime.IsZero() {
+				r.Lead = r.BlockTime.Sub(account.Since)
+			}
+		}
+	}
+}
+
+// value 按清算所在区块的预言机价格和兑换率折算偿还和扣押的价值，回填的历史记录同样按当时的状态计算
+func value(ctx context.Context, p *contract.Protocol, r *Record) error {
+	states, err := p.MarketStatesAt(ctx, r.Block, []string{r.Market, r.Collateral})
+	if err != nil {
+		return err
+	}
+	repay, collateral := states[0], states[1]
+	seized := new(big.Int).Div(new(big.Int).Mul(r.SeizeTokens, collateral.ExchangeRate), big.NewInt(1e18))
+	r.RepayValue = decimal.NewFromBigInt(r.RepayAmount, 0).Mul(decimal.NewFromBigInt(repay.Price, -36))
+	r.SeizeValue = decimal.NewFromBigInt(seized, 0).Mul(decimal.NewFromBigInt(collateral.Price, -36))
+	r.Profit = r.SeizeValue.Sub(r.RepayValue)
+	return nil
+}
@@ -1,19 +1,27 @@
 package conf
 
 import (
-	"liquidator/log"
-
-	"github.com/fsnotify/fsnotify"
-	"github.com/spf13/viper"
+	"time"
 )
 
 type ConfigStruct struct {
-	Chainid     int64
-	Subgraph    string
-	Infura      string
-	Comptroller string
-	Wallet      string
-	Log         Log
+	Chainid        int64
+	Subgraph       string
+	Subgraphs      []SubgraphSource
+	SubgraphClient SubgraphClient
+	Infura         string
+	Comptroller    string
+	Protocols      []Protocol
+	Chains         []Chain
+	Wallet         string
+	Log            Log
+	Admin          Admin
+	Watchlist      Watchlist
+	Scenario       Scenario
+	DryRun         DryRun
+	Competitors    Competitors
+	Alerts         Alerts
+	Strategy       Strategies
 }
 
 type Log struct {
@@ -21,29 +29,170 @@ type Log struct {
 	FileName string
 	Prefix   string
 	Level    string
+	// Format 日志格式，text（默认）或 json
+	Format string
+	// Stdout 同时输出到标准输出
+	Stdout bool
+	// MaxSize 单个文件超过该大小（MB）时切分，0 只按日期切分
+	MaxSize int64
+	// MaxFiles 保留的切分文件数量，0 不删除
+	MaxFiles int
+	Compress bool
+	// BufferSize 日志队列长度，Overflow 为队列满时的处理方式：block、drop 或 dropOldest
+	BufferSize int
+	Overflow   string
+}
+
+type Admin struct {
+	Listen string
+	Token  string
+}
+
+type Watchlist struct {
+	Margin   float64
+	Interval string
+}
+
+type Scenario struct {
+	Interval string
+	Shocks   []string
+}
+
+// DryRun 打开后不发送交易，清算和授权用 eth_call 模拟，结果写入 Store 指定的机会记录文件
+type DryRun struct {
+	Enabled bool
+	Store   string
+}
+
+// Competitors 记录链上每一次清算并与我们的机会对比，Backfill 为启动时补齐的区块数
+type Competitors struct {
+	Enabled  bool
+	Store    string
+	Backfill uint64
+}
+
+// Alerts 告警渠道和规则。Dedup 内同一事件只发送一次，RateLimit 为每分钟最多发送的告警数；
+// Severity 按事件类型覆盖默认级别，各渠道只接收不低于 MinSeverity 的告警
+type Alerts struct {
+	Dedup     time.Duration
+	RateLimit int
+	Severity  map[string]string
+	// BalanceInterval 检查钱包原生币余额的 cron，低于 MinGasBalance 时告警
+	BalanceInterval string
+	MinGasBalance   float64
+	// LargeAccount 钱包余额不足以清算、且偿还价值不低于该美元金额的账户单独告警
+	LargeAccount float64
+	// StuckAfter 清算交易提交后超过该时间仍未上链时告警
+	StuckAfter time.Duration
+	Webhooks   []AlertWebhook
+	Telegram   []AlertTelegram
+	Slack      []AlertWebhook
+}
+
+type AlertWebhook struct {
+	Url         string
+	MinSeverity string
+}
+
+type AlertTelegram struct {
+	ApiUrl      string
+	Token       string
+	ChatId      string
+	MinSeverity string
+}
+
+type Chain struct {
+	Name      string
+	Chainid   int64
+	Rpcs      []string
+	Wallet    string
+	FeePolicy string
+	BlockTime time.Duration
+	Multicall string
+	Protocols []Protocol
+}
+
+type Protocol struct {
+	Name        string
+	Comptroller string
+	Subgraphs   []SubgraphSource
+}
+
+type SubgraphSource struct {
+	Url      string
+	Priority int
+}
+
+type SubgraphClient struct {
+	Timeout        time.Duration
+	Retries        int
+	Backoff        time.Duration
+	MaxBlockLag    uint64
+	HealthInterval string
+	CrossCheck     CrossCheck
+}
+
+type CrossCheck struct {
+	Interval string
+	Sample   int
 }
 
 var Config ConfigStruct
 
-func Init() {
-	viper := viper.New()
-	viper.SetConfigName("config")
-	viper.SetConfigType("yaml")
-	viper.AddConfigPath("./conf/")
-	err := viper.ReadInConfig()
-	if err != nil {
-		panic(err)
+// ChainConfigs 未配置 chains 时使用顶层的 chainid、infura、wallet 和 protocols
+func (c ConfigStruct) ChainConfigs() []Chain {
+	if len(c.Chains) == 0 {
+		return []Chain{{
+			Name:      "default",
+			Chainid:   c.Chainid,
+			Rpcs:      []string{c.Infura},
+			Wallet:    c.Wallet,
+			Protocols: c.ProtocolConfigs(),
+		}}
 	}
+	result := make([]Chain, 0, len(c.Chains))
+	for _, chain := range c.Chains {
+		if chain.Wallet == "" {
+			chain.Wallet = c.Wallet
+		}
+		protocols := make([]Protocol, 0, len(chain.Protocols))
+		for _, p := range chain.Protocols {
+			if len(p.Subgraphs) == 0 {
+				p.Subgraphs = c.SubgraphSources()
+			}
+			protocols = append(protocols, p)
+		}
+		chain.Protocols = protocols
+		result = append(result, chain)
+	}
+	return result
+}
 
-	viper.Unmarshal(&Config)
-	viper.WatchConfig()
-	viper.OnConfigChange(func(e fsnotify.Event) {
-		err = viper.ReadInConfig()
-		if err == nil {
-			viper.Unmarshal(&Config)
-			log.Printf("config: %+v", Config)
-		} else {
-			log.Printf("ReadInConfig error: %s", err)
+// ProtocolConfigs 未配置 protocols 时使用顶层的 comptroller 和 subgraph
+func (c ConfigStruct) ProtocolConfigs() []Protocol {
+	if len(c.Protocols) > 0 {
+		result := make([]Protocol, 0, len(c.Protocols))
+		for _, p := range c.Protocols {
+			if len(p.Subgraphs) == 0 {
+				p.Subgraphs = c.SubgraphSources()
+			}
+			result = append(result, p)
 		}
-	})
+		return result
+	}
+	if c.Comptroller == "" {
+		return nil
+	}
+	return []Protocol{{Name: "default", Comptroller: c.Comptroller, Subgraphs: c.SubgraphSources()}}
+}
+
+// SubgraphSources 兼容旧的单个 subgraph 配置
+func (c ConfigStruct) SubgraphSources() []SubgraphSource {
+	if len(c.Subgraphs) > 0 {
+		return c.Subgraphs
+	}
+	if c.Subgraph != "" {
+		return []SubgraphSource{{Url: c.Subgraph}}
+	}
+	return nil
 }
@@ -0,0 +1,175 @@
+package conf
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testKey = "4c0883a69102937d6231471b5dbb6204fe5129617082792ae468d01a3f362318"
+
+const testConfig = `
+chains:
+  - name: bsc
+    chainid: 56
+    rpcs:
+      - https://bsc-dataseed.binance.org
+    protocols:
+      - name: publics
+        comptroller: 0x9d6D5Ab86563a5d62039037059D7874F4DC9f88b
+        subgraphs:
+          - url: http://127.0.0.1:8000/subgraphs/name/publics
+log:
+  level: info
+scenario:
+  shocks:
+    - "ETH=-20%"
+`
+
+func writeConfig(t *testing.T, content string) string {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestLoadEnvOverrides(t *testing.T) {
+	file := writeConfig(t, testConfig)
+	t.Setenv("LIQUIDATOR_WALLET", "0x"+testKey)
+	t.Setenv("LIQUIDATOR_LOG_LEVEL", "warn")
+	t.Setenv("LIQUIDATOR_ADMIN_TOKEN", "secret")
+	t.Setenv("LIQUIDATOR_CHAINS_BSC_RPCS", "https://a.example,https://b.example")
+
+	c, err := Load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Wallet != "0x"+testKey || c.Log.Level != "warn" || c.Admin.Token != "secret" {
+		t.Errorf("wallet/log/admin overrides not applied: %q %q %q", c.Wallet, c.Log.Level, c.Admin.Token)
+	}
+	chains := c.ChainConfigs()
+	if chains[0].Wallet != c.Wallet || !reflect.DeepEqual(chains[0].Rpcs, []string{"https://a.example", "https://b.example"}) {
+		t.Errorf("chain = %+v", chains[0])
+	}
+	if err := Validate(c); err != nil {
+		t.Errorf("validate: %s", err)
+	}
+
+	t.Setenv("LIQUIDATOR_CHAINS_BSC_WALLET", "not-a-key")
+	c, err = Load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Validate(c); err == nil || !strings.Contains(err.Error(), "chains[0].wallet: invalid private key") || strings.Contains(err.Error(), "not-a-key") {
+		t.Errorf("validate = %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	c := ConfigStruct{
+		Chains: []Chain{{
+			Name:      "bsc",
+			Rpcs:      []string{"bsc-dataseed.binance.org", "ftp://node"},
+			FeePolicy: "eip1559",
+			Protocols: []Protocol{{Name: "publics", Comptroller: "0x1234"}},
+		}},
+		Log:       Log{Format: "xml"},
+		Watchlist: Watchlist{Interval: "every minute"},
+		Alerts:    Alerts{Telegram: []AlertTelegram{{Token: "t"}}},
+	}
+	err := Validate(c)
+	var v *ValidationError
+	if !errors.As(err, &v) {
+		t.Fatalf("err = %v", err)
+	}
+	want := []string{
+		"chains[0].chainid",
+		"chains[0].rpcs[0]: invalid url",
+		"chains[0].rpcs[1]: url scheme",
+		"chains[0].wallet: missing private key",
+		"chains[0].feePolicy",
+		"chains[0].protocols[0].comptroller: invalid address",
+		"chains[0].protocols[0].subgraphs: no subgraph",
+		"log.format",
+		"watchlist.interval",
+		"alerts.telegram[0]: token and chatId",
+	}
+	for _, w := range want {
+		found := false
+		for _, p := range v.Problems {
+			found = found || strings.HasPrefix(p, w)
+		}
+		if !found {
+			t.Errorf("missing problem %q in %v", w, v.Problems)
+		}
+	}
+	if len(v.Problems) != len(want) {
+		t.Errorf("problems = %v", v.Problems)
+	}
+}
+
+func TestReload(t *testing.T) {
+	t.Setenv("LIQUIDATOR_WALLET", testKey)
+	file := writeConfig(t, testConfig)
+	c, err := Load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, Config = file, c
+
+	changed := strings.Replace(testConfig, "level: info", "level: debug", 1)
+	changed = strings.Replace(changed, "ETH=-20%", "ETH=-30%", 1)
+	changed = strings.Replace(changed, "chainid: 56", "chainid: 97", 1)
+	if err := ioutil.WriteFile(file, []byte(changed), 0644); err != nil {
+		t.Fatal(err)
+	}
+	applied, restart, err := Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(applied, []string{"log.level", "scenario.shocks"}) || !reflect.DeepEqual(restart, []string{"chains"}) {
+		t.Errorf("applied = %v, restart = %v", applied, restart)
+	}
+	got := Get()
+	if got.Log.Level != "debug" || got.Scenario.Shocks[0] != "ETH=-30%" || got.Chains[0].Chainid != 56 {
+		t.Errorf("config = %+v", got)
+	}
+
+	// 校验失败时不修改当前配置
+	invalid := strings.Replace(changed, "level: debug", "level: loud", 1)
+	if err := ioutil.WriteFile(file, []byte(invalid), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := Reload(); err == nil {
+		t.Error("invalid config accepted")
+	}
+	if Get().Log.Level != "debug" {
+		t.Errorf("log level = %s after rejected reload", Get().Log.Level)
+	}
+}
+
+func TestStrategyFor(t *testing.T) {
+	disabled, flash := false, true
+	s := Strategies{
+		Default: Strategy{MinProfit: 10, MaxGasPrice: 5},
+		Markets: map[string]Strategy{
+			"pbnb":  {MaxRepay: 1000, Collaterals: []string{"pUSDT", "0xAbC"}, FlashFunding: &flash},
+			"0xdef": {Enabled: &disabled, MinProfit: 50},
+		},
+	}
+	bnb := s.For("0x111", "pBNB")
+	if bnb.MinProfit != 10 || bnb.MaxRepay != 1000 || bnb.MaxGasPrice != 5 || !bnb.IsEnabled() || !bnb.AllowFlashFunding() {
+		t.Errorf("pBNB strategy = %+v", bnb)
+	}
+	if bnb.CollateralRank("0x222", "PUSDT") != 0 || bnb.CollateralRank("0xabc", "") != 1 || bnb.CollateralRank("0x333", "pETH") != 2 {
+		t.Errorf("collateral rank wrong for %v", bnb.Collaterals)
+	}
+	// 地址优先于 symbol
+	if def := s.For("0xDEF", "pBNB"); def.IsEnabled() || def.MinProfit != 50 || def.MaxRepay != 0 || def.AllowFlashFunding() {
+		t.Errorf("0xdef strategy = %+v", def)
+	}
+}
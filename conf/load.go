@@ -0,0 +1,96 @@
+package conf
+
+import (
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// EnvPrefix 环境变量覆盖配置的前缀，键中的 . 换成 _，如 LIQUIDATOR_WALLET、LIQUIDATOR_LOG_LEVEL
+const EnvPrefix = "LIQUIDATOR"
+
+// ConfigEnv 未指定 --config 时读取的配置文件路径环境变量
+const ConfigEnv = EnvPrefix + "_CONFIG"
+
+// DefaultPath 未指定配置文件时使用的路径
+const DefaultPath = "./conf/config.yaml"
+
+func newViper(path string) *viper.Viper {
+	v := viper.New()
+	v.SetConfigFile(path)
+	v.SetConfigType("yaml")
+	v.SetEnvPrefix(EnvPrefix)
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.AutomaticEnv()
+	bindEnvs(v, reflect.TypeOf(ConfigStruct{}), "")
+	return v
+}
+
+// Path 依次使用 path、LIQUIDATOR_CONFIG 和默认路径
+func Path(path string) string {
+	if path != "" {
+		return path
+	}
+	if env := os.Getenv(ConfigEnv); env != "" {
+		return env
+	}
+	return DefaultPath
+}
+
+// Load 读取配置文件并应用环境变量覆盖，不做校验
+func Load(path string) (ConfigStruct, error) {
+	return load(newViper(Path(path)))
+}
+
+func load(v *viper.Viper) (ConfigStruct, error) {
+	var c ConfigStruct
+	if err := v.ReadInConfig(); err != nil {
+		return c, err
+	}
+	if err := v.Unmarshal(&c); err != nil {
+		return c, err
+	}
+	applyChainEnvs(&c)
+	return c, nil
+}
+
+// bindEnvs 为所有不在列表中的配置项绑定环境变量，配置文件中没有的键也能通过环境变量设置。
+// chains、protocols 等列表中的项不能逐项覆盖，链的钱包和节点见 applyChainEnvs
+func bindEnvs(v *viper.Viper, t reflect.Type, prefix string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := prefix + strings.ToLower(field.Name)
+		switch field.Type.Kind() {
+		case reflect.Struct:
+			bindEnvs(v, field.Type, key+".")
+		case reflect.Map:
+		case reflect.Slice:
+			if field.Type.Elem().Kind() == reflect.String {
+				v.BindEnv(key)
+			}
+		default:
+			v.BindEnv(key)
+		}
+	}
+}
+
+// chainEnv LIQUIDATOR_CHAINS_<链名>_<KEY>，链名转为大写，- 换成 _
+func chainEnv(chain, key string) string {
+	name := strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(chain))
+	return EnvPrefix + "_CHAINS_" + name + "_" + key
+}
+
+// applyChainEnvs 按链名覆盖 chains[] 的钱包私钥和 RPC 节点（逗号分隔），私钥不必写进配置文件
+func applyChainEnvs(c *ConfigStruct) {
+	for i := range c.Chains {
+		chain := &c.Chains[i]
+		if wallet := os.Getenv(chainEnv(chain.Name, "WALLET")); wallet != "" {
+			chain.Wallet = wallet
+		}
+		if rpcs := os.Getenv(chainEnv(chain.Name, "RPCS")); rpcs != "" {
+			chain.Rpcs = strings.Split(rpcs, ",")
+		}
+	}
+}
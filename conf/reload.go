@@ -0,0 +1,137 @@
+package conf
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/fsnotify/fsnotify"
+
+	"liquidator/log"
+)
+
+var (
+	mu   sync.RWMutex
+	path string
+)
+
+// reloadable 运行中修改后立即生效的配置项，strategy 下的所有配置项也可以热加载，其他配置项修改后需要重启
+var reloadable = map[string]bool{
+	"log.level":                        true,
+	"log.format":                       true,
+	"scenario.shocks":                  true,
+	"subgraphClient.crossCheck.sample": true,
+	"alerts.minGasBalance":             true,
+	"alerts.largeAccount":              true,
+	"alerts.stuckAfter":                true,
+}
+
+// Init 读取并校验配置文件，之后监听文件变化热加载
+func Init(configPath string) error {
+	path = Path(configPath)
+	v := newViper(path)
+	c, err := load(v)
+	if err != nil {
+		return err
+	}
+	if err := Validate(c); err != nil {
+		return err
+	}
+	Config = c
+
+	v.WatchConfig()
+	v.OnConfigChange(func(e fsnotify.Event) {
+		if _, _, err := Reload(); err != nil {
+			log.Warn("config reload rejected, keep current config: %s", err)
+		}
+	})
+	return nil
+}
+
+// Get 返回当前配置的副本，运行中读取可热加载的配置项时使用
+func Get() ConfigStruct {
+	mu.RLock()
+	defer mu.RUnlock()
+	return Config
+}
+
+// Reload 重新读取配置文件并校验，只应用可以运行中修改的配置项。
+// 返回已应用的和需要重启才能生效的配置项，校验失败时不做任何修改
+func Reload() (applied, restart []string, err error) {
+	next, err := Load(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := Validate(next); err != nil {
+		return nil, nil, err
+	}
+
+	mu.Lock()
+	merged := Config
+	for _, field := range Diff(Config, next) {
+		if !reloadable[field] && !strings.HasPrefix(field, "strategy.") {
+			restart = append(restart, field)
+			continue
+		}
+		setField(&merged, &next, field)
+		applied = append(applied, field)
+	}
+	Config = merged
+	mu.Unlock()
+
+	if len(applied) > 0 {
+		log.SetLevel(merged.Log.Level)
+		log.SetFormat(merged.Log.Format)
+		log.With("applied", strings.Join(applied, ",")).Printf("config reloaded")
+	}
+	if len(restart) > 0 {
+		log.With("restart", strings.Join(restart, ",")).Warn("config changes need restart to take effect")
+	}
+	return applied, restart, nil
+}
+
+// Diff 返回 a、b 中不同的配置项，按 config.yaml 中的键名表示，如 log.level。
+// 列表和 map 整体比较
+func Diff(a, b ConfigStruct) []string {
+	result := make([]string, 0)
+	diff(reflect.ValueOf(a), reflect.ValueOf(b), "", &result)
+	return result
+}
+
+func diff(a, b reflect.Value, prefix string, result *[]string) {
+	t := a.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key := prefix + lowerFirst(t.Field(i).Name)
+		fa, fb := a.Field(i), b.Field(i)
+		if fa.Kind() == reflect.Struct {
+			diff(fa, fb, key+".", result)
+			continue
+		}
+		if !reflect.DeepEqual(fa.Interface(), fb.Interface()) {
+			*result = append(*result, key)
+		}
+	}
+}
+
+// setField 把 src 中 key 对应的配置项复制到 dst
+func setField(dst, src *ConfigStruct, key string) {
+	d, s := reflect.ValueOf(dst).Elem(), reflect.ValueOf(src).Elem()
+	for _, name := range strings.Split(key, ".") {
+		d = d.FieldByName(upperFirst(name))
+		s = s.FieldByName(upperFirst(name))
+	}
+	d.Set(s)
+}
+
+func lowerFirst(s string) string {
+	r := []rune(s)
+	r[0] = unicode.ToLower(r[0])
+	return string(r)
+}
+
+func upperFirst(s string) string {
+	r := []rune(s)
+	r[0] = unicode.ToUpper(r[0])
+	return string(r)
+}
@@ -0,0 +1,88 @@
+package conf
+
+import (
+	"strings"
+)
+
+// Strategy 一个市场的清算策略，零值表示不限制
+type Strategy struct {
+	// Enabled 为 false 时既不偿还该市场的借款，也不扣押该市场的抵押物
+	Enabled *bool
+	// MinProfit 预计收益（扣押价值 - 偿还价值 - gas 成本，美元）低于该值时放弃
+	MinProfit float64
+	// MaxRepay 单次清算最多偿还的美元价值
+	MaxRepay float64
+	// Collaterals 偿还该市场时优先扣押的抵押物，按 symbol 或 pToken 地址从高到低排列，未列出的排在后面
+	Collaterals []string
+	// MaxGasPrice 当前 gas 单价（gwei）高于该值时放弃
+	MaxGasPrice float64
+	// FlashFunding 为 true 时偿还数量不受钱包余额限制，按 close factor 计算；未配置或为 false 时不超过钱包余额
+	FlashFunding *bool
+}
+
+// Strategies 所有市场的默认策略及按 symbol 或 pToken 地址覆盖的市场策略
+type Strategies struct {
+	Default Strategy
+	Markets map[string]Strategy
+}
+
+// IsEnabled 未配置时为 true
+func (s Strategy) IsEnabled() bool {
+	return s.Enabled == nil || *s.Enabled
+}
+
+// AllowFlashFunding 未配置时为 false
+func (s Strategy) AllowFlashFunding() bool {
+	return s.FlashFunding != nil && *s.FlashFunding
+}
+
+// CollateralRank 抵押物在 Collaterals 中的位置，未列出时为 len(Collaterals)
+func (s Strategy) CollateralRank(market, symbol string) int {
+	for i, c := range s.Collaterals {
+		if strings.EqualFold(c, market) || (symbol != "" && strings.EqualFold(c, symbol)) {
+			return i
+		}
+	}
+	return len(s.Collaterals)
+}
+
+// For 市场的策略：先按 pToken 地址、再按 symbol 查找，配置了的字段覆盖 Default
+func (s Strategies) For(market, symbol string) Strategy {
+	result := s.Default
+	override, ok := s.lookup(market)
+	if !ok && symbol != "" {
+		override, ok = s.lookup(symbol)
+	}
+	if !ok {
+		return result
+	}
+	if override.Enabled != nil {
+		result.Enabled = override.Enabled
+	}
+	if override.MinProfit != 0 {
+		result.MinProfit = override.MinProfit
+	}
+	if override.MaxRepay != 0 {
+		result.MaxRepay = override.MaxRepay
+	}
+	if len(override.Collaterals) > 0 {
+		result.Collaterals = override.Collaterals
+	}
+	if override.MaxGasPrice != 0 {
+		result.MaxGasPrice = override.MaxGasPrice
+	}
+	if override.FlashFunding != nil {
+		result.FlashFunding = override.FlashFunding
+	}
+	return result
+}
+
+// lookup viper 读出的 map 键都是小写
+func (s Strategies) lookup(key string) (Strategy, bool) {
+	for k, v := range s.Markets {
+		if strings.EqualFold(k, key) {
+			return v, true
+		}
+	}
+	return Strategy{}, false
+}
@@ -0,0 +1,190 @@
+package conf
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/robfig/cron"
+)
+
+// ValidationError 配置中所有不合法的项
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid config:\n  " + strings.Join(e.Problems, "\n  ")
+}
+
+type validator struct {
+	problems []string
+}
+
+func (v *validator) add(format string, args ...interface{}) {
+	v.problems = append(v.problems, fmt.Sprintf(format, args...))
+}
+
+func (v *validator) url(field, raw string, schemes ...string) {
+	if raw == "" {
+		v.add("%s: missing url", field)
+		return
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		v.add("%s: invalid url", field)
+		return
+	}
+	for _, scheme := range schemes {
+		if u.Scheme == scheme {
+			return
+		}
+	}
+	v.add("%s: url scheme must be one of %s", field, strings.Join(schemes, ", "))
+}
+
+func (v *validator) address(field, value string, required bool) {
+	if value == "" {
+		if required {
+			v.add("%s: missing address", field)
+		}
+		return
+	}
+	if !common.IsHexAddress(value) {
+		v.add("%s: invalid address %q", field, value)
+	}
+}
+
+func (v *validator) cron(field, spec string) {
+	if spec == "" {
+		return
+	}
+	if _, err := cron.Parse(spec); err != nil {
+		v.add("%s: invalid cron spec %q: %s", field, spec, err)
+	}
+}
+
+func (v *validator) oneOf(field, value string, allowed ...string) {
+	for _, a := range allowed {
+		if strings.EqualFold(value, a) {
+			return
+		}
+	}
+	v.add("%s: %q must be one of %s", field, value, strings.Join(allowed[1:], ", "))
+}
+
+func (v *validator) strategy(field string, s Strategy) {
+	if s.MinProfit < 0 || s.MaxRepay < 0 || s.MaxGasPrice < 0 {
+		v.add("%s: minProfit, maxRepay and maxGasPrice must not be negative", field)
+	}
+	for i, c := range s.Collaterals {
+		if c == "" {
+			v.add("%s.collaterals[%d]: empty", field, i)
+		}
+	}
+}
+
+// Validate 检查地址格式、URL、私钥和各项取值，返回 *ValidationError 列出所有问题。
+// 链 ID 是否与节点一致需要连接节点，在 contract 初始化链时检查
+func Validate(c ConfigStruct) error {
+	v := &validator{}
+	chains := c.ChainConfigs()
+	if len(chains) == 0 {
+		v.add("chains: no chain configured")
+	}
+	chainNames := make(map[string]bool)
+	protocolNames := make(map[string]bool)
+	for i, chain := range chains {
+		field := fmt.Sprintf("chains[%d]", i)
+		if chain.Name == "" {
+			v.add("%s.name: missing", field)
+		} else if chainNames[chain.Name] {
+			v.add("%s.name: duplicate chain %q", field, chain.Name)
+		}
+		chainNames[chain.Name] = true
+		if chain.Chainid <= 0 {
+			v.add("%s.chainid: must be positive", field)
+		}
+		if len(chain.Rpcs) == 0 {
+			v.add("%s.rpcs: no rpc endpoint", field)
+		}
+		for j, rpc := range chain.Rpcs {
+			v.url(fmt.Sprintf("%s.rpcs[%d]", field, j), rpc, "http", "https", "ws", "wss")
+		}
+		if chain.Wallet == "" {
+			v.add("%s.wallet: missing private key (set %s or %s)", field, EnvPrefix+"_WALLET", chainEnv(chain.Name, "WALLET"))
+		} else if _, err := crypto.HexToECDSA(strings.TrimPrefix(chain.Wallet, "0x")); err != nil {
+			// 不输出私钥本身
+			v.add("%s.wallet: invalid private key", field)
+		}
+		v.oneOf(field+".feePolicy", chain.FeePolicy, "", "legacy", "1559")
+		v.address(field+".multicall", chain.Multicall, false)
+		if len(chain.Protocols) == 0 {
+			v.add("%s.protocols: no protocol configured", field)
+		}
+		for j, p := range chain.Protocols {
+			pfield := fmt.Sprintf("%s.protocols[%d]", field, j)
+			if p.Name == "" {
+				v.add("%s.name: missing", pfield)
+			} else if protocolNames[p.Name] {
+				v.add("%s.name: duplicate protocol %q", pfield, p.Name)
+			}
+			protocolNames[p.Name] = true
+			v.address(pfield+".comptroller", p.Comptroller, true)
+			if len(p.Subgraphs) == 0 {
+				v.add("%s.subgraphs: no subgraph configured", pfield)
+			}
+			for k, s := range p.Subgraphs {
+				v.url(fmt.Sprintf("%s.subgraphs[%d]", pfield, k), s.Url, "http", "https")
+			}
+		}
+	}
+
+	v.oneOf("log.level", c.Log.Level, "", "debug", "info", "warn", "error")
+	v.oneOf("log.format", c.Log.Format, "", "text", "json")
+	v.oneOf("log.overflow", c.Log.Overflow, "", "block", "drop", "dropOldest")
+	if c.Log.MaxSize < 0 || c.Log.MaxFiles < 0 || c.Log.BufferSize < 0 {
+		v.add("log: maxSize, maxFiles and bufferSize must not be negative")
+	}
+
+	v.cron("subgraphClient.healthInterval", c.SubgraphClient.HealthInterval)
+	v.cron("subgraphClient.crossCheck.interval", c.SubgraphClient.CrossCheck.Interval)
+	v.cron("watchlist.interval", c.Watchlist.Interval)
+	v.cron("scenario.interval", c.Scenario.Interval)
+	v.cron("alerts.balanceInterval", c.Alerts.BalanceInterval)
+
+	severities := []string{"", "info", "warning", "warn", "critical"}
+	for event, severity := range c.Alerts.Severity {
+		v.oneOf("alerts.severity."+event, severity, severities...)
+	}
+	for i, w := range c.Alerts.Webhooks {
+		v.url(fmt.Sprintf("alerts.webhooks[%d]", i), w.Url, "http", "https")
+		v.oneOf(fmt.Sprintf("alerts.webhooks[%d].minSeverity", i), w.MinSeverity, severities...)
+	}
+	for i, s := range c.Alerts.Slack {
+		v.url(fmt.Sprintf("alerts.slack[%d]", i), s.Url, "http", "https")
+		v.oneOf(fmt.Sprintf("alerts.slack[%d].minSeverity", i), s.MinSeverity, severities...)
+	}
+	for i, t := range c.Alerts.Telegram {
+		field := fmt.Sprintf("alerts.telegram[%d]", i)
+		if t.Token == "" || t.ChatId == "" {
+			v.add("%s: token and chatId are required", field)
+		}
+		if t.ApiUrl != "" {
+			v.url(field+".apiUrl", t.ApiUrl, "http", "https")
+		}
+		v.oneOf(field+".minSeverity", t.MinSeverity, severities...)
+	}
+
+	v.strategy("strategy.default", c.Strategy.Default)
+	for market, s := range c.Strategy.Markets {
+		v.strategy("strategy.markets."+market, s)
+	}
+
+	if len(v.problems) > 0 {
+		return &ValidationError{Problems: v.problems}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package contract
+
+import (
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// bindingCache 按地址缓存 Ptoken、Erc20 绑定，避免每次调用都重新创建
+type bindingCache struct {
+	mu      sync.Mutex
+	ptokens map[common.Address]*Ptoken
+	erc20s  map[common.Address]*Erc20
+}
+
+func newBindingCache() *bindingCache {
+	return &bindingCache{
+		ptokens: make(map[common.Address]*Ptoken),
+		erc20s:  make(map[common.Address]*Erc20),
+	}
+}
+
+func (c *Chain) ptoken(address common.Address) (*Ptoken, error) {
+	c.bindings.mu.Lock()
+	defer c.bindings.mu.Unlock()
+	if instance, ok := c.bindings.ptokens[address]; ok {
+		return instance, nil
+	}
+	instance, err := NewPtoken(address, c.client)
+	if err != nil {
+		return nil, err
+	}
+	c.bindings.ptokens[address] = instance
+	return instance, nil
+}
+
+func (c *Chain) erc20(address common.Address) (*Erc20, error) {
+	c.bindings.mu.Lock()
+	defer c.bindings.mu.Unlock()
+	if instance, ok := c.bindings.erc20s[address]; ok {
+		return instance, nil
+	}
+	instance, err := NewErc20(address, c.client)
+	if err != nil {
+		return nil, err
+	}
+	c.bindings.erc20s[address] = instance
+	return instance, nil
+}
@@ -3,78 +3,212 @@ package contract
 import (
 	"context"
 	"crypto/ecdsa"
+	"errors"
+	"fmt"
 	"math/big"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
-	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/shopspring/decimal"
 
 	"liquidator/conf"
+	"liquidator/decoder"
 	"liquidator/log"
 )
 
-var (
-	client              *ethclient.Client
-	comptrollerInstance *Comptroller
-	closeFactor         decimal.Decimal
-	auth                *bind.TransactOpts
-	walletAddress       common.Address
+const (
+	FeePolicyLegacy = "legacy"
+	FeePolicy1559   = "1559"
 )
 
-func Init() {
-	c, err := ethclient.Dial(conf.Config.Infura)
+// Backend 合约调用、交易发送、交易和回执查询所需的节点接口，生产环境为 rpcPool，测试中可以注入模拟链
+type Backend interface {
+	bind.ContractBackend
+	TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error)
+	TransactionByHash(ctx context.Context, txHash common.Hash) (*types.Transaction, bool, error)
+	BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error)
+}
+
+// Chain 一条链的 RPC 节点、签名钱包和手续费策略，链之间互不影响
+type Chain struct {
+	Name      string
+	ID        *big.Int
+	BlockTime time.Duration
+	FeePolicy string
+
+	client        Backend
+	auth          *bind.TransactOpts
+	walletAddress common.Address
+	txMu          sync.Mutex
+	protocols     []*Protocol
+	bindings      *bindingCache
+	multicall     *multicall
+	dryRun        bool
+	simulations   simulations
+}
+
+var chains []*Chain
+
+// ErrChainIDMismatch 节点返回的链 ID 与配置不一致，继续使用会把交易签到错误的链上
+var ErrChainIDMismatch = errors.New("chain id mismatch")
+
+// Init 初始化所有链，节点暂时不可用的链跳过，链 ID 与节点不一致时返回错误
+func Init() error {
+	chains = nil
+	for _, c := range conf.Config.ChainConfigs() {
+		chain, err := NewChain(c)
+		if errors.Is(err, ErrChainIDMismatch) {
+			return fmt.Errorf("[%s] %w", c.Name, err)
+		}
+		if err != nil {
+			log.Printf("[%s] init chain error: %s", c.Name, err)
+			continue
+		}
+		chains = append(chains, chain)
+	}
+	return nil
+}
+
+func NewChain(c conf.Chain) (*Chain, error) {
+	client, err := dialPool(c.Name, c.Rpcs)
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+	if err := client.verifyChainID(context.Background(), big.NewInt(c.Chainid)); err != nil {
+		return nil, err
 	}
-	client = c
+	return NewChainWithBackend(c, client)
+}
 
-	comptrollerInstance = newComptroller()
-	closeFactor = getCloseFactor()
+// NewChainWithBackend 使用给定的节点接口初始化链，不读取 c.Rpcs
+func NewChainWithBackend(c conf.Chain, client Backend) (*Chain, error) {
+	chain := &Chain{
+		Name:      c.Name,
+		ID:        big.NewInt(c.Chainid),
+		BlockTime: c.BlockTime,
+		FeePolicy: c.FeePolicy,
+		client:    client,
+		bindings:  newBindingCache(),
+		dryRun:    conf.Config.DryRun.Enabled,
+	}
+	if chain.FeePolicy == "" {
+		chain.FeePolicy = FeePolicyLegacy
+	}
+	multicallAddress := c.Multicall
+	if multicallAddress == "" {
+		multicallAddress = DefaultMulticallAddress
+	}
+	chain.multicall = newMulticall(common.HexToAddress(multicallAddress), client)
 
-	privateKey, err := crypto.HexToECDSA(conf.Config.Wallet)
+	privateKey, err := crypto.HexToECDSA(strings.TrimPrefix(c.Wallet, "0x"))
 	if err != nil {
-		log.Print(err)
+		return nil, fmt.Errorf("invalid wallet key: %w", err)
 	}
-	auth, err = bind.NewKeyedTransactorWithChainID(privateKey, big.NewInt(conf.Config.Chainid))
+	chain.auth, err = bind.NewKeyedTransactorWithChainID(privateKey, chain.ID)
 	if err != nil {
-		log.Print(err)
+		return nil, err
 	}
-
 	publicKey := privateKey.Public()
 	publicKeyECDSA, ok := publicKey.(*ecdsa.PublicKey)
 	if !ok {
-		log.Print("cannot assert type: publicKey is not of type *ecdsa.PublicKey")
+		return nil, errors.New("cannot assert type: publicKey is not of type *ecdsa.PublicKey")
+	}
+	chain.walletAddress = crypto.PubkeyToAddress(*publicKeyECDSA)
+
+	for _, p := range c.Protocols {
+		protocol, err := chain.NewProtocol(p.Name, p.Comptroller)
+		if err != nil {
+			log.Printf("[%s] init protocol %s error: %s", c.Name, p.Name, err)
+			continue
+		}
+		chain.protocols = append(chain.protocols, protocol)
 	}
-	walletAddress = crypto.PubkeyToAddress(*publicKeyECDSA)
+	return chain, nil
 }
 
-func getNonce() uint64 {
-	nonce, err := client.PendingNonceAt(context.Background(), walletAddress)
-	if err != nil {
-		log.Printf("get nonce error: %s", err)
-		return 0
+// SetChains 替换已初始化的链，用于测试中注入模拟链
+func SetChains(cs ...*Chain) {
+	chains = cs
+}
+
+func Chains() []*Chain {
+	return chains
+}
+
+func GetChain(name string) (*Chain, error) {
+	for _, c := range chains {
+		if name == "" || strings.EqualFold(c.Name, name) {
+			return c, nil
+		}
 	}
-	return nonce
+	return nil, fmt.Errorf("unknown chain %q", name)
 }
 
-func getGasPrice() *big.Int {
-	gasPrice, err := client.SuggestGasPrice(context.Background())
+func (c *Chain) Protocols() []*Protocol {
+	return c.protocols
+}
+
+func (c *Chain) getNonce() (uint64, error) {
+	nonce, err := c.client.PendingNonceAt(context.Background(), c.walletAddress)
+	return nonce, callError("PendingNonceAt", err)
+}
+
+func (c *Chain) GetBlockNumber(ctx context.Context) (uint64, error) {
+	if client, ok := c.client.(interface {
+		BlockNumber(ctx context.Context) (uint64, error)
+	}); ok {
+		return client.BlockNumber(ctx)
+	}
+	head, err := c.client.HeaderByNumber(ctx, nil)
 	if err != nil {
-		log.Printf("get gas price error: %s", err)
-		return common.Big0
+		return 0, err
 	}
-	return gasPrice
+	return head.Number.Uint64(), nil
 }
 
-func newComptroller() *Comptroller {
-	comptrollerAddress := common.HexToAddress(conf.Config.Comptroller)
-	instance, err := NewComptroller(comptrollerAddress, client)
+// GasPrice 节点建议的 legacy gas 单价（wei）
+func (c *Chain) GasPrice() (*big.Int, error) {
+	gasPrice, err := c.client.SuggestGasPrice(context.Background())
+	return gasPrice, callError("SuggestGasPrice", err)
+}
+
+// prepareAuth 按链的手续费策略设置 legacy gasPrice 或 EIP-1559 的 tip/feeCap，调用方需持有 txMu
+func (c *Chain) prepareAuth() error {
+	nonce, err := c.getNonce()
+	if err != nil {
+		return err
+	}
+	c.auth.Nonce = new(big.Int).SetUint64(nonce)
+	c.auth.Value = big.NewInt(0)      // in wei
+	c.auth.GasLimit = uint64(3000000) // in units
+	c.auth.GasPrice = nil
+	c.auth.GasTipCap = nil
+	c.auth.GasFeeCap = nil
+
+	if c.FeePolicy != FeePolicy1559 {
+		c.auth.GasPrice, err = c.GasPrice()
+		return err
+	}
+	ctx := context.Background()
+	tip, err := c.client.SuggestGasTipCap(ctx)
 	if err != nil {
-		log.Print(err)
+		return callError("SuggestGasTipCap", err)
 	}
-	return instance
+	head, err := c.client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return callError("HeaderByNumber", err)
+	}
+	if head.BaseFee == nil {
+		return &CallError{Method: "HeaderByNumber", Kind: ErrDecode, Err: errors.New("missing base fee")}
+	}
+	c.auth.GasTipCap = tip
+	c.auth.GasFeeCap = new(big.Int).Add(tip, new(big.Int).Mul(head.BaseFee, big.NewInt(2)))
+	return nil
 }
 
 func exponentToDecimal(decimals int) decimal.Decimal {
@@ -86,137 +220,168 @@ func exponentToDecimal(decimals int) decimal.Decimal {
 	return result
 }
 
-func getCloseFactor() decimal.Decimal {
-	closeFactorMantissa, err := comptrollerInstance.CloseFactorMantissa(nil)
+func (c *Chain) GetAssetBalance(asset, account string) (*big.Int, error) {
+	pTokenInstance, err := c.ptoken(common.HexToAddress(asset))
 	if err != nil {
-		log.Print(err)
+		return nil, err
 	}
-	return decimal.NewFromBigInt(closeFactorMantissa, 0).Div(exponentToDecimal(18))
+	balance, err := pTokenInstance.BalanceOf(nil, common.HexToAddress(account))
+	return balance, callError("BalanceOf", err)
 }
 
-func IsHighRisk(address string) bool {
-	account := common.HexToAddress(address)
-	_, _, shortfall, _ := comptrollerInstance.GetAccountLiquidity(nil, account)
-	if shortfall.Cmp(common.Big0) > 0 {
-		return true
-	} else {
-		return false
-	}
+// GetWalletNativeBalance 钱包的原生币余额，用于支付 gas
+func (c *Chain) GetWalletNativeBalance() (*big.Int, error) {
+	balance, err := c.client.BalanceAt(context.Background(), c.walletAddress, nil)
+	return balance, callError("BalanceAt", err)
 }
 
-func GetLiquidateRepayAmount(pToken string, borrower string) *big.Int {
-	pTokenInstance, err := NewPtoken(common.HexToAddress(pToken), client)
+func (c *Chain) GetWalletUnderlyingBalance(pToken string) (*big.Int, error) {
+	pTokenInstance, err := c.ptoken(common.HexToAddress(pToken))
 	if err != nil {
-		log.Printf("NewPToken error: %s", err)
-		return common.Big0
+		return nil, err
 	}
-	borrowBalance, err := pTokenInstance.BorrowBalanceStored(nil, common.HexToAddress(borrower))
+	underlying, err := pTokenInstance.Underlying(nil)
 	if err != nil {
-		log.Printf("Get BorrowBalanceStored error: %s", err)
-		return common.Big0
+		return nil, callError("Underlying", err)
 	}
-	return borrowBalance.Mul(borrowBalance, closeFactor.BigInt())
-}
-
-func LiquidateCalculateSeizeTokens(pTokenBorrowed, pTokenCollateral string, actualRepayAmount *big.Int) *big.Int {
-	borrowed := common.HexToAddress(pTokenBorrowed)
-	collateral := common.HexToAddress(pTokenCollateral)
-	_, amount, err := comptrollerInstance.LiquidateCalculateSeizeTokens(nil, borrowed, collateral, actualRepayAmount)
+	erc20Instance, err := c.erc20(underlying)
 	if err != nil {
-		log.Printf("Get LiquidateCalculateSeizeTokens error: %s", err)
-		return common.Big0
+		return nil, err
 	}
-	return amount
+	balance, err := erc20Instance.BalanceOf(nil, c.walletAddress)
+	return balance, callError("BalanceOf", err)
 }
 
-func GetCollaterals(borrower string) []string {
-	assets, err := comptrollerInstance.GetAssetsIn(nil, common.HexToAddress(borrower))
+// LiquidateBorrow 调用 ERC20 市场（CErc20）的 liquidateBorrow(borrower, repayAmount, collateral)，不附带原生币，
+// 原生币市场的偿还需要 payable 的 liquidateBorrow，由 executor 在计划和检查阶段拒绝
+func (c *Chain) LiquidateBorrow(asset, borrower, collateral string, repayAmount *big.Int) (string, error) {
+	pTokenInstance, err := c.ptoken(common.HexToAddress(asset))
 	if err != nil {
-		log.Printf("GetAssetsIn error: %s", err)
-		return nil
+		log.Printf("NewPToken error: %s", err)
+		return "", err
 	}
-	result := make([]string, 0)
-	for _, asset := range assets {
-		result = append(result, asset.String())
+	if c.dryRun {
+		return c.simulateLiquidateBorrow(asset, borrower, collateral, repayAmount)
 	}
-	return result
-}
 
-func GetAssetBalance(asset, account string) *big.Int {
-	pTokenInstance, err := NewPtoken(common.HexToAddress(asset), client)
-	if err != nil {
-		log.Printf("NewPToken error: %s", err)
-		return common.Big0
+	c.txMu.Lock()
+	defer c.txMu.Unlock()
+	if err := c.prepareAuth(); err != nil {
+		log.Printf("[%s] prepare auth error: %s", c.Name, err)
+		return "", err
 	}
-	balance, _ := pTokenInstance.BalanceOf(nil, common.HexToAddress(account))
-	return balance
-}
 
-func GetWalletUnderlyingBalance(pToken string) *big.Int {
-	pTokenInstance, err := NewPtoken(common.HexToAddress(pToken), client)
+	tx, err := pTokenInstance.LiquidateBorrow(c.auth, common.HexToAddress(borrower), repayAmount, common.HexToAddress(collateral))
 	if err != nil {
-		log.Printf("NewPToken error: %s", err)
-		return common.Big0
+		log.Printf("[%s] LiquidateBorrow error: %s", c.Name, err)
+		return "", callError("LiquidateBorrow", err)
 	}
-	underlying, _ := pTokenInstance.Underlying(nil)
-	erc20Instance, _ := NewErc20(underlying, client)
-	balance, _ := erc20Instance.BalanceOf(nil, walletAddress)
-	return balance
+
+	return tx.Hash().String(), nil
 }
 
-func LiquidateBorrow(asset, borrower, collateral string, repayAmount *big.Int) (string, error) {
-	pTokenInstance, err := NewPtoken(common.HexToAddress(asset), client)
+func (c *Chain) Approve(pToken string) (string, error) {
+	pTokenAddress := common.HexToAddress(pToken)
+	pTokenInstance, err := c.ptoken(pTokenAddress)
 	if err != nil {
 		log.Printf("NewPToken error: %s", err)
 		return "", err
 	}
+	erc20Address, err := pTokenInstance.Underlying(nil)
+	if err != nil {
+		log.Printf("[%s] Get underlying error: %s", c.Name, err)
+		return "", callError("Underlying", err)
+	}
+	erc20Instance, err := c.erc20(erc20Address)
+	if err != nil {
+		log.Printf("NewErc20 error: %s", err)
+		return "", err
+	}
 
-	auth.Nonce = big.NewInt(int64(getNonce()))
-	auth.Value = big.NewInt(0)      // in wei
-	auth.GasLimit = uint64(3000000) // in units
-	auth.GasPrice = getGasPrice()
-
-	tx, err := pTokenInstance.LiquidateBorrow(auth, common.HexToAddress(borrower), repayAmount, common.HexToAddress(collateral))
+	totalSupply, err := erc20Instance.TotalSupply(nil)
 	if err != nil {
-		log.Printf("LiquidateBorrow error: %s", err)
+		log.Printf("[%s] Get totalSupply error: %s", c.Name, err)
+		return "", callError("TotalSupply", err)
+	}
+	if c.dryRun {
+		return c.simulateApprove(erc20Address, pTokenAddress, totalSupply)
+	}
+
+	c.txMu.Lock()
+	defer c.txMu.Unlock()
+	if err := c.prepareAuth(); err != nil {
+		log.Printf("[%s] prepare auth error: %s", c.Name, err)
 		return "", err
 	}
 
+	tx, err := erc20Instance.Approve(c.auth, pTokenAddress, totalSupply)
+	if err != nil {
+		log.Printf("[%s] Approve error: %s", c.Name, err)
+		return "", callError("Approve", err)
+	}
 	return tx.Hash().String(), nil
 }
 
-func Approve(pToken string) (string, error) {
-	pTokenAddress := common.HexToAddress(pToken)
-	pTokenInstance, err := NewPtoken(pTokenAddress, client)
+func (c *Chain) WalletAddress() string {
+	return c.walletAddress.String()
+}
+
+func (c *Chain) GetSymbol(pToken string) (string, error) {
+	pTokenInstance, err := c.ptoken(common.HexToAddress(pToken))
 	if err != nil {
-		log.Printf("NewPToken error: %s", err)
 		return "", err
 	}
-	erc20Address, err := pTokenInstance.Underlying(nil)
+	symbol, err := pTokenInstance.Symbol(nil)
+	return symbol, callError("Symbol", err)
+}
+
+// GetAccountSnapshot 返回 pToken 余额、借款余额和兑换率
+func (c *Chain) GetAccountSnapshot(pToken, account string) (*big.Int, *big.Int, *big.Int, error) {
+	pTokenInstance, err := c.ptoken(common.HexToAddress(pToken))
 	if err != nil {
-		log.Printf("Get underlying error: %s", err)
-		return "", err
+		return nil, nil, nil, err
 	}
-	erc20Instance, err := NewErc20(erc20Address, client)
+	code, balance, borrowBalance, exchangeRate, err := pTokenInstance.GetAccountSnapshot(nil, common.HexToAddress(account), false)
 	if err != nil {
-		log.Printf("NewErc20 error: %s", err)
-		return "", err
+		return nil, nil, nil, callError("GetAccountSnapshot", err)
 	}
+	if err := decoder.Code(decoder.Token, "GetAccountSnapshot", code); err != nil {
+		return nil, nil, nil, err
+	}
+	return balance, borrowBalance, exchangeRate, nil
+}
 
-	auth.Nonce = big.NewInt(int64(getNonce()))
-	auth.Value = big.NewInt(0)      // in wei
-	auth.GasLimit = uint64(3000000) // in units
-	auth.GasPrice = getGasPrice()
-
-	totalSupply, err := erc20Instance.TotalSupply(nil)
+// GetComptroller 返回 pToken 绑定的 comptroller 地址
+func (c *Chain) GetComptroller(pToken string) (string, error) {
+	pTokenInstance, err := c.ptoken(common.HexToAddress(pToken))
 	if err != nil {
-		log.Printf("Get totalSupply error: %s", err)
 		return "", err
 	}
-	tx, err := erc20Instance.Approve(auth, pTokenAddress, totalSupply)
+	comptroller, err := pTokenInstance.Comptroller(nil)
 	if err != nil {
-		log.Printf("Approve error: %s", err)
+		return "", callError("Comptroller", err)
+	}
+	return comptroller.String(), nil
+}
+
+func (c *Chain) GetWalletAssetBalance(pToken string) (*big.Int, error) {
+	return c.GetAssetBalance(pToken, c.walletAddress.String())
+}
+
+func (c *Chain) Redeem(pToken string, redeemTokens *big.Int) (string, error) {
+	instance := bind.NewBoundContract(common.HexToAddress(pToken), redeemABI, c.client, c.client, c.client)
+
+	c.txMu.Lock()
+	defer c.txMu.Unlock()
+	if err := c.prepareAuth(); err != nil {
+		log.Printf("[%s] prepare auth error: %s", c.Name, err)
 		return "", err
 	}
+
+	tx, err := instance.Transact(c.auth, "redeem", redeemTokens)
+	if err != nil {
+		log.Printf("[%s] Redeem error: %s", c.Name, err)
+		return "", callError("Redeem", err)
+	}
 	return tx.Hash().String(), nil
 }
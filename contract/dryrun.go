@@ -0,0 +1,207 @@
+package contract
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+
+	"liquidator/decoder"
+	"liquidator/log"
+)
+
+// simulationRetention 每条链保留的模拟结果数量
+const simulationRetention = 1000
+
+// ErrAllowanceMissing dryRun 模式下 Approve 只是模拟，钱包对 pToken 的授权不足时清算必然失败，不再模拟
+var ErrAllowanceMissing = errors.New("allowance missing")
+
+var erc20ABI abi.ABI
+
+func init() {
+	parsed, err := abi.JSON(strings.NewReader(Erc20ABI))
+	if err != nil {
+		panic(err)
+	}
+	erc20ABI = parsed
+}
+
+// Simulation dryRun 模式下用 eth_call 在最新区块上模拟的一笔交易，不签名也不发送
+type Simulation struct {
+	ID       string
+	Method   string
+	From     string
+	To       string
+	Block    uint64
+	Gas      uint64
+	GasPrice *big.Int
+	Success  bool
+	Error    string `json:",omitempty"`
+	// AllowanceMissing 钱包对 pToken 的授权不足，没有执行 eth_call，不是清算本身的失败
+	AllowanceMissing bool `json:",omitempty"`
+	Time             time.Time
+}
+
+// simulations 按 ID 保存最近的模拟结果，供执行方读取
+type simulations struct {
+	mu    sync.Mutex
+	seq   uint64
+	byID  map[string]*Simulation
+	order []string
+}
+
+func (s *simulations) add(chain string, sim *Simulation) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.byID == nil {
+		s.byID = make(map[string]*Simulation)
+	}
+	s.seq++
+	sim.ID = fmt.Sprintf("dryrun-%s-%d", chain, s.seq)
+	s.byID[sim.ID] = sim
+	s.order = append(s.order, sim.ID)
+	if len(s.order) > simulationRetention {
+		delete(s.byID, s.order[0])
+		s.order = s.order[1:]
+	}
+	return sim.ID
+}
+
+// DryRun 是否只模拟交易
+func (c *Chain) DryRun() bool {
+	return c.dryRun
+}
+
+// SetDryRun 打开后 LiquidateBorrow 和 Approve 只用 eth_call 模拟，返回的 tx 为模拟结果的 ID
+func (c *Chain) SetDryRun(enabled bool) {
+	c.dryRun = enabled
+}
+
+// Simulation 按 LiquidateBorrow 或 Approve 返回的 ID 读取模拟结果
+func (c *Chain) Simulation(id string) (*Simulation, bool) {
+	c.simulations.mu.Lock()
+	defer c.simulations.mu.Unlock()
+	sim, ok := c.simulations.byID[id]
+	return sim, ok
+}
+
+// simulate 以钱包地址 eth_call 交易数据，check 检查返回值中的错误码；
+// 合约 revert 或返回错误码记录为模拟失败，只有 RPC 不可用时返回错误
+func (c *Chain) simulate(method string, to common.Address, data []byte, check func(out []byte) error) (string, error) {
+	ctx := context.Background()
+	block, err := c.GetBlockNumber(ctx)
+	if err != nil {
+		return "", callError("BlockNumber", err)
+	}
+	msg := ethereum.CallMsg{From: c.walletAddress, To: &to, Data: data}
+	sim := &Simulation{Method: method, From: c.walletAddress.String(), To: to.String(), Block: block, Time: time.Now()}
+
+	out, err := c.client.CallContract(ctx, msg, nil)
+	if err = callError(method, err); errors.Is(err, ErrRPCUnavailable) {
+		return "", err
+	}
+	if err == nil && check != nil {
+		err = check(out)
+	}
+	if err != nil {
+		sim.Error = err.Error()
+	} else {
+		sim.Success = true
+		if gas, err := c.client.EstimateGas(ctx, msg); err == nil {
+			sim.Gas = gas
+		} else {
+			log.Printf("[%s] dry run %s estimate gas error: %s", c.Name, method, err)
+		}
+	}
+	if gasPrice, err := c.GasPrice(); err == nil {
+		sim.GasPrice = gasPrice
+	}
+
+	id := c.simulations.add(c.Name, sim)
+	log.Printf("[%s] dry run %s %s to %s at block %d: success=%t gas=%d error=%s", c.Name, id, method, sim.To, block, sim.Success, sim.Gas, sim.Error)
+	return id, nil
+}
+
+// allowanceShortage 钱包对 pToken 的底层资产授权不足 amount 时返回原因，为空表示足够；
+// 原生币市场没有 underlying，不需要授权
+func (c *Chain) allowanceShortage(pToken common.Address, amount *big.Int) (string, error) {
+	instance, err := c.ptoken(pToken)
+	if err != nil {
+		return "", err
+	}
+	underlying, err := instance.Underlying(nil)
+	if err = callError("Underlying", err); errors.Is(err, ErrRPCUnavailable) {
+		return "", err
+	} else if err != nil {
+		return "", nil
+	}
+	erc20Instance, err := c.erc20(underlying)
+	if err != nil {
+		return "", err
+	}
+	allowance, err := erc20Instance.Allowance(nil, c.walletAddress, pToken)
+	if err != nil {
+		return "", callError("Allowance", err)
+	}
+	if allowance.Cmp(amount) < 0 {
+		return fmt.Sprintf("%s: have %s, need %s", ErrAllowanceMissing, allowance, amount), nil
+	}
+	return "", nil
+}
+
+func (c *Chain) simulateLiquidateBorrow(asset, borrower, collateral string, repayAmount *big.Int) (string, error) {
+	data, err := ptokenABI.Pack("liquidateBorrow", common.HexToAddress(borrower), repayAmount, common.HexToAddress(collateral))
+	if err != nil {
+		return "", err
+	}
+	shortage, err := c.allowanceShortage(common.HexToAddress(asset), repayAmount)
+	if err != nil {
+		return "", err
+	}
+	if shortage != "" {
+		block, err := c.GetBlockNumber(context.Background())
+		if err != nil {
+			return "", callError("BlockNumber", err)
+		}
+		sim := &Simulation{Method: "LiquidateBorrow", From: c.walletAddress.String(), To: asset, Block: block,
+			Error: shortage, AllowanceMissing: true, Time: time.Now()}
+		id := c.simulations.add(c.Name, sim)
+		log.Printf("[%s] dry run %s LiquidateBorrow to %s at block %d skipped: %s", c.Name, id, asset, block, shortage)
+		return id, nil
+	}
+	return c.simulate("LiquidateBorrow", common.HexToAddress(asset), data, func(out []byte) error {
+		values, err := ptokenABI.Unpack("liquidateBorrow", out)
+		if err != nil {
+			return callError("LiquidateBorrow", err)
+		}
+		return decoder.Code(decoder.Token, "LiquidateBorrow", *abi.ConvertType(values[0], new(*big.Int)).(**big.Int))
+	})
+}
+
+func (c *Chain) simulateApprove(erc20Address, pToken common.Address, amount *big.Int) (string, error) {
+	data, err := erc20ABI.Pack("approve", pToken, amount)
+	if err != nil {
+		return "", err
+	}
+	return c.simulate("Approve", erc20Address, data, func(out []byte) error {
+		// 部分 ERC20 的 approve 没有返回值
+		if len(out) == 0 {
+			return nil
+		}
+		values, err := erc20ABI.Unpack("approve", out)
+		if err != nil {
+			return callError("Approve", err)
+		}
+		if ok, _ := values[0].(bool); !ok {
+			return errors.New("approve returned false")
+		}
+		return nil
+	})
+}
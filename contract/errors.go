@@ -0,0 +1,62 @@
+package contract
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// 合约调用失败的分类，通过 errors.Is 判断
+var (
+	ErrRPCUnavailable = errors.New("rpc unavailable")
+	ErrReverted       = errors.New("contract reverted")
+	ErrDecode         = errors.New("decode error")
+)
+
+// CallError 一次合约调用的错误，Kind 为上面的分类之一
+type CallError struct {
+	Method string
+	Kind   error
+	Err    error
+}
+
+func (e *CallError) Error() string {
+	return fmt.Sprintf("%s: %s: %s", e.Method, e.Kind, e.Err)
+}
+
+func (e *CallError) Unwrap() error {
+	return e.Err
+}
+
+func (e *CallError) Is(target error) bool {
+	return target == e.Kind
+}
+
+// callError 按错误内容分类，err 为 nil 时返回 nil
+func callError(method string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var callErr *CallError
+	if errors.As(err, &callErr) {
+		return err
+	}
+	return &CallError{Method: method, Kind: classify(err), Err: err}
+}
+
+func classify(err error) error {
+	if errors.Is(err, bind.ErrNoCode) || strings.HasPrefix(err.Error(), "abi: ") {
+		return ErrDecode
+	}
+	var rpcErr rpc.Error
+	if errors.As(err, &rpcErr) && (rpcErr.ErrorCode() == 3 || strings.Contains(rpcErr.Error(), "revert")) {
+		return ErrReverted
+	}
+	if strings.Contains(err.Error(), "execution reverted") {
+		return ErrReverted
+	}
+	return ErrRPCUnavailable
+}
@@ -0,0 +1,155 @@
+package contract
+
+import (
+	"context"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/shopspring/decimal"
+
+	"liquidator/log"
+)
+
+// 单次 eth_getLogs 查询的最大区块跨度，避免节点拒绝请求
+const maxLogRange = 5000
+
+var comptrollerABI abi.ABI
+
+func init() {
+	parsed, err := abi.JSON(strings.NewReader(ComptrollerABI))
+	if err != nil {
+		panic(err)
+	}
+	comptrollerABI = parsed
+}
+
+func eventID(name string) common.Hash {
+	return comptrollerABI.Events[name].ID
+}
+
+// PollEvents 拉取上次处理之后的 comptroller 事件，更新市场列表和协议参数。
+// 首次调用只记录当前区块，之前的状态由 SyncMarkets 读取。
+func (p *Protocol) PollEvents(ctx context.Context) error {
+	head, err := p.Chain.GetBlockNumber(ctx)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	from := p.lastBlock + 1
+	if p.lastBlock == 0 {
+		p.lastBlock = head
+	}
+	p.mu.Unlock()
+	if from == 1 {
+		return nil
+	}
+
+	for from <= head {
+		to := from + maxLogRange - 1
+		if to > head {
+			to = head
+		}
+		logs, err := p.Chain.client.FilterLogs(ctx, ethereum.FilterQuery{
+			FromBlock: new(big.Int).SetUint64(from),
+			ToBlock:   new(big.Int).SetUint64(to),
+			Addresses: []common.Address{p.address},
+		})
+		if err != nil {
+			return err
+		}
+		for _, l := range logs {
+			p.handleEvent(l)
+		}
+		p.mu.Lock()
+		p.lastBlock = to
+		p.mu.Unlock()
+		from = to + 1
+	}
+	return nil
+}
+
+func (p *Protocol) handleEvent(l types.Log) {
+	if len(l.Topics) == 0 {
+		return
+	}
+	var err error
+	switch l.Topics[0] {
+	case eventID("MarketListed"):
+		var e *ComptrollerMarketListed
+		if e, err = p.comptroller.ParseMarketListed(l); err == nil {
+			log.Printf("[%s] market listed: %s", p.Name, e.PToken)
+			err = p.loadMarket(e.PToken)
+		}
+	case eventID("NewCollateralFactor"):
+		var e *ComptrollerNewCollateralFactor
+		if e, err = p.comptroller.ParseNewCollateralFactor(l); err == nil {
+			log.Printf("[%s] %s collateral factor: %s -> %s", p.Name, e.PToken, e.OldCollateralFactorMantissa, e.NewCollateralFactorMantissa)
+			p.registry.update(e.PToken.String(), func(m *Market) {
+				m.CollateralFactor = decimal.NewFromBigInt(e.NewCollateralFactorMantissa, -18)
+			})
+		}
+	case eventID("NewCloseFactor"):
+		var e *ComptrollerNewCloseFactor
+		if e, err = p.comptroller.ParseNewCloseFactor(l); err == nil {
+			log.Printf("[%s] close factor: %s -> %s", p.Name, e.OldCloseFactorMantissa, e.NewCloseFactorMantissa)
+			p.updateParams("NewCloseFactor", func(params *Params) {
+				params.CloseFactor = decimal.NewFromBigInt(e.NewCloseFactorMantissa, -18)
+			})
+		}
+	case eventID("NewLiquidationIncentive"):
+		var e *ComptrollerNewLiquidationIncentive
+		if e, err = p.comptroller.ParseNewLiquidationIncentive(l); err == nil {
+			log.Printf("[%s] liquidation incentive: %s -> %s", p.Name, e.OldLiquidationIncentiveMantissa, e.NewLiquidationIncentiveMantissa)
+			p.updateParams("NewLiquidationIncentive", func(params *Params) {
+				params.LiquidationIncentive = decimal.NewFromBigInt(e.NewLiquidationIncentiveMantissa, -18)
+			})
+		}
+	case eventID("NewPriceOracle"):
+		var e *ComptrollerNewPriceOracle
+		if e, err = p.comptroller.ParseNewPriceOracle(l); err == nil {
+			log.Printf("[%s] price oracle: %s -> %s", p.Name, e.OldPriceOracle, e.NewPriceOracle)
+			p.updateParams("NewPriceOracle", func(params *Params) {
+				params.Oracle = e.NewPriceOracle.String()
+			})
+		}
+	case eventID("ActionPaused"):
+		var e *ComptrollerActionPaused
+		if e, err = p.comptroller.ParseActionPaused(l); err == nil {
+			log.Printf("[%s] action %s paused: %t", p.Name, e.Action, e.PauseState)
+			p.updateParams("ActionPaused", func(params *Params) {
+				switch e.Action {
+				case "Seize":
+					params.SeizePaused = e.PauseState
+				case "Transfer":
+					params.TransferPaused = e.PauseState
+				}
+			})
+			if !e.PauseState {
+				p.notifyUnpause(e.Action, "")
+			}
+		}
+	case eventID("ActionPaused0"):
+		var e *ComptrollerActionPaused0
+		if e, err = p.comptroller.ParseActionPaused0(l); err == nil {
+			log.Printf("[%s] %s action %s paused: %t", p.Name, e.PToken, e.Action, e.PauseState)
+			p.registry.update(e.PToken.String(), func(m *Market) {
+				switch e.Action {
+				case "Mint":
+					m.MintPaused = e.PauseState
+				case "Borrow":
+					m.BorrowPaused = e.PauseState
+				}
+			})
+			if !e.PauseState {
+				p.notifyUnpause(e.Action, e.PToken.String())
+			}
+		}
+	}
+	if err != nil {
+		log.Printf("[%s] handle event %s error: %s", p.Name, l.TxHash, err)
+	}
+}
@@ -0,0 +1,186 @@
+package contract
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+
+	"liquidator/decoder"
+)
+
+// 以下读取指定历史区块的状态，用于回测，需要归档节点
+
+// MarketEvents 一个市场在区块范围内与借款和清算相关的事件
+type MarketEvents struct {
+	AccrueInterest  []*PtokenAccrueInterest
+	Borrow          []*PtokenBorrow
+	RepayBorrow     []*PtokenRepayBorrow
+	LiquidateBorrow []*PtokenLiquidateBorrow
+}
+
+// FilterMarketEvents 按 maxLogRange 分段读取 pToken 在 [from, to] 内的事件
+func (p *Protocol) FilterMarketEvents(ctx context.Context, pToken string, from, to uint64) (*MarketEvents, error) {
+	instance, err := p.Chain.ptoken(common.HexToAddress(pToken))
+	if err != nil {
+		return nil, err
+	}
+	result := &MarketEvents{}
+	for start := from; start <= to; start += maxLogRange {
+		end := start + maxLogRange - 1
+		if end > to {
+			end = to
+		}
+		opts := &bind.FilterOpts{Start: start, End: &end, Context: ctx}
+
+		accrue, err := instance.FilterAccrueInterest(opts)
+		if err != nil {
+			return nil, callError("FilterAccrueInterest", err)
+		}
+		for accrue.Next() {
+			result.AccrueInterest = append(result.AccrueInterest, accrue.Event)
+		}
+		if err := accrue.Error(); err != nil {
+			return nil, callError("FilterAccrueInterest", err)
+		}
+
+		borrow, err := instance.FilterBorrow(opts)
+		if err != nil {
+			return nil, callError("FilterBorrow", err)
+		}
+		for borrow.Next() {
+			result.Borrow = append(result.Borrow, borrow.Event)
+		}
+		if err := borrow.Error(); err != nil {
+			return nil, callError("FilterBorrow", err)
+		}
+
+		repay, err := instance.FilterRepayBorrow(opts)
+		if err != nil {
+			return nil, callError("FilterRepayBorrow", err)
+		}
+		for repay.Next() {
+			result.RepayBorrow = append(result.RepayBorrow, repay.Event)
+		}
+		if err := repay.Error(); err != nil {
+			return nil, callError("FilterRepayBorrow", err)
+		}
+
+		liquidate, err := instance.FilterLiquidateBorrow(opts)
+		if err != nil {
+			return nil, callError("FilterLiquidateBorrow", err)
+		}
+		for liquidate.Next() {
+			result.LiquidateBorrow = append(result.LiquidateBorrow, liquidate.Event)
+		}
+		if err := liquidate.Error(); err != nil {
+			return nil, callError("FilterLiquidateBorrow", err)
+		}
+	}
+	return result, nil
+}
+
+// MarketState 市场在某个区块上的价格、兑换率、借款指数和抵押率
+type MarketState struct {
+	Market           string
+	Listed           bool
+	CollateralFactor *big.Int
+	Price            *big.Int
+	ExchangeRate     *big.Int
+	BorrowIndex      *big.Int
+}
+
+// MarketStatesAt 读取 block 上各市场的状态，预言机地址也按 block 读取
+func (p *Protocol) MarketStatesAt(ctx context.Context, block uint64, markets []string) ([]MarketState, error) {
+	at := new(big.Int).SetUint64(block)
+	oracle, err := p.comptroller.Oracle(&bind.CallOpts{Context: ctx, BlockNumber: at})
+	if err != nil {
+		return nil, callError("Oracle", err)
+	}
+	calls := make([]*Call, 0, len(markets)*4)
+	for _, market := range markets {
+		pToken := common.HexToAddress(market)
+		calls = append(calls,
+			newCall(p.address, &comptrollerABI, "markets", pToken),
+			newCall(oracle, &oracleABI, "getUnderlyingPrice", pToken),
+			newCall(pToken, &ptokenABI, "exchangeRateStored"),
+			newCall(pToken, &ptokenABI, "borrowIndex"))
+	}
+	if err := p.Chain.Multicall(ctx, at, calls); err != nil {
+		return nil, err
+	}
+	result := make([]MarketState, 0, len(markets))
+	for i, market := range markets {
+		info, price, exchangeRate, borrowIndex := calls[i*4], calls[i*4+1], calls[i*4+2], calls[i*4+3]
+		if err := firstErr(info, price, exchangeRate, borrowIndex); err != nil {
+			return nil, err
+		}
+		result = append(result, MarketState{
+			Market:           market,
+			Listed:           info.Out[0].(bool),
+			CollateralFactor: *abi.ConvertType(info.Out[1], new(*big.Int)).(**big.Int),
+			Price:            *abi.ConvertType(price.Out[0], new(*big.Int)).(**big.Int),
+			ExchangeRate:     *abi.ConvertType(exchangeRate.Out[0], new(*big.Int)).(**big.Int),
+			BorrowIndex:      *abi.ConvertType(borrowIndex.Out[0], new(*big.Int)).(**big.Int),
+		})
+	}
+	return result, nil
+}
+
+// AccountPosition 借款人在一个市场上的 pToken 余额和借款
+type AccountPosition struct {
+	Borrower string
+	Market   string
+	PTokens  *big.Int
+	Borrow   *big.Int
+}
+
+// PositionsAt 读取 block 上每个借款人在每个市场的仓位，只返回非空仓位
+func (p *Protocol) PositionsAt(ctx context.Context, block uint64, borrowers, markets []string) ([]AccountPosition, error) {
+	at := new(big.Int).SetUint64(block)
+	calls := make([]*Call, 0, len(borrowers)*len(markets))
+	for _, borrower := range borrowers {
+		for _, market := range markets {
+			calls = append(calls, newCall(common.HexToAddress(market), &ptokenABI, "getAccountSnapshot", common.HexToAddress(borrower), false))
+		}
+	}
+	if err := p.Chain.Multicall(ctx, at, calls); err != nil {
+		return nil, err
+	}
+	result := make([]AccountPosition, 0)
+	for i, call := range calls {
+		if call.Err != nil {
+			return nil, call.Err
+		}
+		code := *abi.ConvertType(call.Out[0], new(*big.Int)).(**big.Int)
+		if err := decoder.Code(decoder.Token, "GetAccountSnapshot", code); err != nil {
+			return nil, err
+		}
+		pTokens := *abi.ConvertType(call.Out[1], new(*big.Int)).(**big.Int)
+		borrow := *abi.ConvertType(call.Out[2], new(*big.Int)).(**big.Int)
+		if pTokens.Sign() == 0 && borrow.Sign() == 0 {
+			continue
+		}
+		result = append(result, AccountPosition{
+			Borrower: borrowers[i/len(markets)],
+			Market:   markets[i%len(markets)],
+			PTokens:  pTokens,
+			Borrow:   borrow,
+		})
+	}
+	return result, nil
+}
+
+// ParamsAt 读取 block 上的 close factor 和清算奖励
+func (p *Protocol) ParamsAt(ctx context.Context, block uint64) (closeFactor, incentive *big.Int, err error) {
+	opts := &bind.CallOpts{Context: ctx, BlockNumber: new(big.Int).SetUint64(block)}
+	if closeFactor, err = p.comptroller.CloseFactorMantissa(opts); err != nil {
+		return nil, nil, callError("CloseFactorMantissa", err)
+	}
+	if incentive, err = p.comptroller.LiquidationIncentiveMantissa(opts); err != nil {
+		return nil, nil, callError("LiquidationIncentiveMantissa", err)
+	}
+	return closeFactor, incentive, nil
+}
@@ -0,0 +1,162 @@
+package contract
+
+import (
+	"context"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
+)
+
+// Liquidation 链上的一次 LiquidateBorrow，包含交易实际支付的手续费和在区块中的位置
+type Liquidation struct {
+	Protocol    string
+	Market      string
+	Liquidator  string
+	Borrower    string
+	RepayAmount *big.Int
+	Collateral  string
+	SeizeTokens *big.Int
+	Block       uint64
+	BlockTime   time.Time
+	TxIndex     uint
+	LogIndex    uint
+	Tx          string
+	GasUsed     uint64
+	// GasPrice 实际支付的 gas 单价，EIP-1559 交易为 min(feeCap, baseFee + tip)
+	GasPrice *big.Int
+	// PriorityFee 高于 base fee 的部分，区块没有 base fee 时为 nil
+	PriorityFee *big.Int `json:",omitempty"`
+}
+
+// FilterLiquidateBorrow 按 maxLogRange 分段读取所有市场在 [from, to] 内的 LiquidateBorrow 事件
+func (p *Protocol) FilterLiquidateBorrow(ctx context.Context, from, to uint64) ([]*PtokenLiquidateBorrow, error) {
+	result := make([]*PtokenLiquidateBorrow, 0)
+	for _, market := range p.GetAllMarkets() {
+		instance, err := p.Chain.ptoken(common.HexToAddress(market))
+		if err != nil {
+			return nil, err
+		}
+		for start := from; start <= to; start += maxLogRange {
+			end := start + maxLogRange - 1
+			if end > to {
+				end = to
+			}
+			it, err := instance.FilterLiquidateBorrow(&bind.FilterOpts{Start: start, End: &end, Context: ctx})
+			if err != nil {
+				return nil, callError("FilterLiquidateBorrow", err)
+			}
+			for it.Next() {
+				result = append(result, it.Event)
+			}
+			if err := it.Error(); err != nil {
+				return nil, callError("FilterLiquidateBorrow", err)
+			}
+		}
+	}
+	return result, nil
+}
+
+// WatchLiquidateBorrow 订阅所有市场的 LiquidateBorrow 事件，任意一个订阅出错时全部取消，
+// 错误通过返回的 Subscription 的 Err 通知。HTTP 节点不支持订阅时直接返回错误
+func (p *Protocol) WatchLiquidateBorrow(ctx context.Context, sink chan<- *PtokenLiquidateBorrow) (event.Subscription, error) {
+	subs := make([]event.Subscription, 0)
+	unsubscribe := func() {
+		for _, sub := range subs {
+			sub.Unsubscribe()
+		}
+	}
+	for _, market := range p.GetAllMarkets() {
+		instance, err := p.Chain.ptoken(common.HexToAddress(market))
+		if err != nil {
+			unsubscribe()
+			return nil, err
+		}
+		sub, err := instance.WatchLiquidateBorrow(&bind.WatchOpts{Context: ctx}, sink)
+		if err != nil {
+			unsubscribe()
+			return nil, callError("WatchLiquidateBorrow", err)
+		}
+		subs = append(subs, sub)
+	}
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		defer unsubscribe()
+		errs := make(chan error, len(subs))
+		for _, sub := range subs {
+			go func(sub event.Subscription) {
+				if err, ok := <-sub.Err(); ok && err != nil {
+					errs <- err
+				}
+			}(sub)
+		}
+		select {
+		case <-quit:
+			return nil
+		case err := <-errs:
+			return callError("WatchLiquidateBorrow", err)
+		}
+	}), nil
+}
+
+// LiquidationDetails 读取清算交易的回执、交易和区块头，补全手续费、区块位置和时间；
+// 读取失败时仍返回事件中已有的字段
+func (p *Protocol) LiquidationDetails(ctx context.Context, e *PtokenLiquidateBorrow) (*Liquidation, error) {
+	l := &Liquidation{
+		Protocol:    p.Name,
+		Market:      e.Raw.Address.String(),
+		Liquidator:  e.Liquidator.String(),
+		Borrower:    e.Borrower.String(),
+		RepayAmount: e.RepayAmount,
+		Collateral:  e.PTokenCollateral.String(),
+		SeizeTokens: e.SeizeTokens,
+		Block:       e.Raw.BlockNumber,
+		TxIndex:     e.Raw.TxIndex,
+		LogIndex:    e.Raw.Index,
+		Tx:          e.Raw.TxHash.String(),
+	}
+	receipt, err := p.Chain.client.TransactionReceipt(ctx, e.Raw.TxHash)
+	if err != nil {
+		return l, callError("TransactionReceipt", err)
+	}
+	l.GasUsed = receipt.GasUsed
+	tx, _, err := p.Chain.client.TransactionByHash(ctx, e.Raw.TxHash)
+	if err != nil {
+		return l, callError("TransactionByHash", err)
+	}
+	header, err := p.Chain.client.HeaderByNumber(ctx, new(big.Int).SetUint64(e.Raw.BlockNumber))
+	if err != nil {
+		return l, callError("HeaderByNumber", err)
+	}
+	l.BlockTime = time.Unix(int64(header.Time), 0)
+	l.GasPrice, l.PriorityFee = effectiveFee(tx, header.BaseFee)
+	return l, nil
+}
+
+// effectiveFee 交易实际支付的 gas 单价和其中的 priority fee
+func effectiveFee(tx *types.Transaction, baseFee *big.Int) (*big.Int, *big.Int) {
+	if baseFee == nil {
+		return tx.GasPrice(), nil
+	}
+	price := new(big.Int).Add(baseFee, tx.GasTipCap())
+	if price.Cmp(tx.GasFeeCap()) > 0 {
+		price = new(big.Int).Set(tx.GasFeeCap())
+	}
+	return price, new(big.Int).Sub(price, baseFee)
+}
+
+// TxGasPrice 我们自己交易的 gas 单价，EIP-1559 交易按最新区块的 base fee 估算
+func (c *Chain) TxGasPrice(ctx context.Context, hash string) (*big.Int, error) {
+	tx, _, err := c.client.TransactionByHash(ctx, common.HexToHash(hash))
+	if err != nil {
+		return nil, callError("TransactionByHash", err)
+	}
+	header, err := c.client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, callError("HeaderByNumber", err)
+	}
+	price, _ := effectiveFee(tx, header.BaseFee)
+	return price, nil
+}
@@ -0,0 +1,147 @@
+package contract
+
+import (
+	"errors"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/shopspring/decimal"
+
+	"liquidator/log"
+)
+
+// Market 市场的链上信息，由 GetAllMarkets 初始化，之后随 comptroller 事件更新
+type Market struct {
+	Address          string
+	Symbol           string
+	Decimals         uint8
+	Underlying       string
+	CollateralFactor decimal.Decimal
+	IsListed         bool
+	MintPaused       bool
+	BorrowPaused     bool
+}
+
+// MarketRegistry 线程安全的市场列表，key 为小写的 pToken 地址
+type MarketRegistry struct {
+	mu      sync.RWMutex
+	markets map[string]*Market
+}
+
+func newMarketRegistry() *MarketRegistry {
+	return &MarketRegistry{markets: make(map[string]*Market)}
+}
+
+func marketKey(pToken string) string {
+	return strings.ToLower(pToken)
+}
+
+func (r *MarketRegistry) Get(pToken string) (Market, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	m, ok := r.markets[marketKey(pToken)]
+	if !ok {
+		return Market{}, false
+	}
+	return *m, true
+}
+
+// List 按符号排序返回所有市场的副本
+func (r *MarketRegistry) List() []Market {
+	r.mu.RLock()
+	result := make([]Market, 0, len(r.markets))
+	for _, m := range r.markets {
+		result = append(result, *m)
+	}
+	r.mu.RUnlock()
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Symbol < result[j].Symbol
+	})
+	return result
+}
+
+func (r *MarketRegistry) put(m Market) {
+	r.mu.Lock()
+	r.markets[marketKey(m.Address)] = &m
+	r.mu.Unlock()
+}
+
+func (r *MarketRegistry) update(pToken string, fn func(m *Market)) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, ok := r.markets[marketKey(pToken)]
+	if ok {
+		fn(m)
+	}
+	return ok
+}
+
+func (p *Protocol) Markets() []Market {
+	return p.registry.List()
+}
+
+func (p *Protocol) GetMarket(pToken string) (Market, bool) {
+	return p.registry.Get(pToken)
+}
+
+// GetWalletRepayBalance 钱包中可用于偿还该市场的余额：原生币市场没有 underlying，为钱包的原生币余额
+func (p *Protocol) GetWalletRepayBalance(pToken string) (*big.Int, error) {
+	if m, ok := p.registry.Get(pToken); ok && m.Underlying == "" {
+		return p.Chain.GetWalletNativeBalance()
+	}
+	return p.Chain.GetWalletUnderlyingBalance(pToken)
+}
+
+// SyncMarkets 从 GetAllMarkets 重新读取所有市场，读取失败时保留原有数据
+func (p *Protocol) SyncMarkets() error {
+	assets, err := p.comptroller.GetAllMarkets(nil)
+	if err != nil {
+		log.Printf("[%s] GetAllMarkets error: %s", p.Name, err)
+		return err
+	}
+	for _, asset := range assets {
+		if err := p.loadMarket(asset); err != nil {
+			log.Printf("[%s] load market %s error: %s", p.Name, asset, err)
+		}
+	}
+	log.Printf("[%s] markets: %d", p.Name, len(assets))
+	return nil
+}
+
+func (p *Protocol) loadMarket(address common.Address) error {
+	pToken, err := p.Chain.ptoken(address)
+	if err != nil {
+		return err
+	}
+	m := Market{Address: address.String()}
+	if m.Symbol, err = pToken.Symbol(nil); err != nil {
+		return err
+	}
+	if m.Decimals, err = pToken.Decimals(nil); err != nil {
+		return err
+	}
+	// pETH 等原生币市场没有 underlying
+	if underlying, err := pToken.Underlying(nil); err == nil {
+		m.Underlying = underlying.String()
+	}
+	info, err := p.comptroller.Markets(nil, address)
+	if err != nil {
+		return err
+	}
+	if !info.IsListed {
+		return errors.New("market not listed")
+	}
+	m.IsListed = true
+	m.CollateralFactor = decimal.NewFromBigInt(info.CollateralFactorMantissa, -18)
+	if m.MintPaused, err = p.comptroller.MintGuardianPaused(nil, address); err != nil {
+		return err
+	}
+	if m.BorrowPaused, err = p.comptroller.BorrowGuardianPaused(nil, address); err != nil {
+		return err
+	}
+	p.registry.put(m)
+	return nil
+}
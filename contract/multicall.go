@@ -0,0 +1,155 @@
+package contract
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Multicall3 在大多数 EVM 链上部署在同一个地址
+const DefaultMulticallAddress = "0xcA11bde05977b3631167028862bE2a173976CA11"
+
+const Multicall3ABI = "[{\"inputs\":[{\"components\":[{\"internalType\":\"address\",\"name\":\"target\",\"type\":\"address\"},{\"internalType\":\"bool\",\"name\":\"allowFailure\",\"type\":\"bool\"},{\"internalType\":\"bytes\",\"name\":\"callData\",\"type\":\"bytes\"}],\"internalType\":\"structMulticall3.Call3[]\",\"name\":\"calls\",\"type\":\"tuple[]\"}],\"name\":\"aggregate3\",\"outputs\":[{\"components\":[{\"internalType\":\"bool\",\"name\":\"success\",\"type\":\"bool\"},{\"internalType\":\"bytes\",\"name\":\"returnData\",\"type\":\"bytes\"}],\"internalType\":\"structMulticall3.Result[]\",\"name\":\"returnData\",\"type\":\"tuple[]\"}],\"stateMutability\":\"payable\",\"type\":\"function\"}]"
+
+// 单次 aggregate3 最多包含的调用数
+const maxMulticallSize = 100
+
+var (
+	multicallABI abi.ABI
+	ptokenABI    abi.ABI
+)
+
+func init() {
+	parsed, err := abi.JSON(strings.NewReader(Multicall3ABI))
+	if err != nil {
+		panic(err)
+	}
+	multicallABI = parsed
+	parsed, err = abi.JSON(strings.NewReader(PtokenABI))
+	if err != nil {
+		panic(err)
+	}
+	ptokenABI = parsed
+}
+
+type multicall3Call struct {
+	Target       common.Address
+	AllowFailure bool
+	CallData     []byte
+}
+
+type multicall3Result struct {
+	Success    bool
+	ReturnData []byte
+}
+
+type multicall struct {
+	address  common.Address
+	contract *bind.BoundContract
+}
+
+func newMulticall(address common.Address, backend bind.ContractBackend) *multicall {
+	return &multicall{
+		address:  address,
+		contract: bind.NewBoundContract(address, multicallABI, backend, backend, backend),
+	}
+}
+
+// Call 批量调用中的一个合约读取，执行后 Out 或 Err 被填充
+type Call struct {
+	Target common.Address
+	ABI    *abi.ABI
+	Method string
+	Args   []interface{}
+
+	Out []interface{}
+	Err error
+}
+
+func newCall(target common.Address, contractABI *abi.ABI, method string, args ...interface{}) *Call {
+	return &Call{Target: target, ABI: contractABI, Method: method, Args: args}
+}
+
+func (call *Call) unpack(data []byte) {
+	out, err := call.ABI.Unpack(call.Method, data)
+	if err != nil {
+		call.Err = &CallError{Method: call.Method, Kind: ErrDecode, Err: err}
+		return
+	}
+	call.Out = out
+}
+
+// Multicall 在同一个区块上批量执行 calls，单个调用失败只影响该调用的 Err。
+// 链上没有部署 Multicall3 时退化为逐个 eth_call，返回值相同
+func (c *Chain) Multicall(ctx context.Context, block *big.Int, calls []*Call) error {
+	for start := 0; start < len(calls); start += maxMulticallSize {
+		end := start + maxMulticallSize
+		if end > len(calls) {
+			end = len(calls)
+		}
+		err := c.aggregate(ctx, block, calls[start:end])
+		if errors.Is(err, ErrDecode) {
+			err = c.callEach(ctx, block, calls[start:end])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Chain) aggregate(ctx context.Context, block *big.Int, calls []*Call) error {
+	if c.multicall == nil {
+		return &CallError{Method: "aggregate3", Kind: ErrDecode, Err: errors.New("multicall not configured")}
+	}
+	packed := make([]multicall3Call, len(calls))
+	for i, call := range calls {
+		data, err := call.ABI.Pack(call.Method, call.Args...)
+		if err != nil {
+			return err
+		}
+		packed[i] = multicall3Call{Target: call.Target, AllowFailure: true, CallData: data}
+	}
+	var out []interface{}
+	opts := &bind.CallOpts{Context: ctx, BlockNumber: block}
+	if err := c.multicall.contract.Call(opts, &out, "aggregate3", packed); err != nil {
+		return callError("aggregate3", err)
+	}
+	results := *abi.ConvertType(out[0], new([]multicall3Result)).(*[]multicall3Result)
+	if len(results) != len(calls) {
+		return &CallError{Method: "aggregate3", Kind: ErrDecode, Err: errors.New("result count mismatch")}
+	}
+	for i, result := range results {
+		if !result.Success {
+			calls[i].Err = &CallError{Method: calls[i].Method, Kind: ErrReverted, Err: errors.New("reverted in multicall")}
+			continue
+		}
+		calls[i].unpack(result.ReturnData)
+	}
+	return nil
+}
+
+func (c *Chain) callEach(ctx context.Context, block *big.Int, calls []*Call) error {
+	for _, call := range calls {
+		data, err := call.ABI.Pack(call.Method, call.Args...)
+		if err != nil {
+			return err
+		}
+		result, err := c.client.CallContract(ctx, ethereum.CallMsg{To: &call.Target, Data: data}, block)
+		if err != nil {
+			call.Err = callError(call.Method, err)
+			if errors.Is(call.Err, ErrRPCUnavailable) {
+				return call.Err
+			}
+			continue
+		}
+		call.unpack(result)
+	}
+	return nil
+}
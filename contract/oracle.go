@@ -0,0 +1,41 @@
+package contract
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const PriceOracleABI = "[{\"constant\":true,\"inputs\":[{\"internalType\":\"contractPToken\",\"name\":\"pToken\",\"type\":\"address\"}],\"name\":\"getUnderlyingPrice\",\"outputs\":[{\"internalType\":\"uint256\",\"name\":\"\",\"type\":\"uint256\"}],\"payable\":false,\"stateMutability\":\"view\",\"type\":\"function\"}]"
+
+var oracleABI abi.ABI
+
+func init() {
+	parsed, err := abi.JSON(strings.NewReader(PriceOracleABI))
+	if err != nil {
+		panic(err)
+	}
+	oracleABI = parsed
+}
+
+func (p *Protocol) newOracle(address common.Address) *bind.BoundContract {
+	return bind.NewBoundContract(address, oracleABI, p.Chain.client, p.Chain.client, p.Chain.client)
+}
+
+// GetUnderlyingPrice 返回预言机价格，精度为 36 - underlying decimals
+func (p *Protocol) GetUnderlyingPrice(pToken string) (*big.Int, error) {
+	oracle := p.getOracle()
+	if oracle == nil {
+		return nil, &CallError{Method: "getUnderlyingPrice", Kind: ErrRPCUnavailable, Err: errors.New("oracle not loaded")}
+	}
+	var out []interface{}
+	err := oracle.Call(nil, &out, "getUnderlyingPrice", common.HexToAddress(pToken))
+	if err != nil {
+		return nil, callError("getUnderlyingPrice", err)
+	}
+	return *abi.ConvertType(out[0], new(*big.Int)).(**big.Int), nil
+}
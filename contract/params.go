@@ -0,0 +1,125 @@
+package contract
+
+import (
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/shopspring/decimal"
+
+	"liquidator/log"
+)
+
+// Params 协议参数快照，任何一个参数变化都会使 Version 加一
+type Params struct {
+	Version              uint64
+	CloseFactor          decimal.Decimal
+	LiquidationIncentive decimal.Decimal
+	Oracle               string
+	MaxAssets            *big.Int
+	SeizePaused          bool
+	TransferPaused       bool
+	UpdatedAt            time.Time
+}
+
+func (a Params) equal(b Params) bool {
+	return a.CloseFactor.Equal(b.CloseFactor) &&
+		a.LiquidationIncentive.Equal(b.LiquidationIncentive) &&
+		a.Oracle == b.Oracle &&
+		bigEqual(a.MaxAssets, b.MaxAssets) &&
+		a.SeizePaused == b.SeizePaused &&
+		a.TransferPaused == b.TransferPaused
+}
+
+func bigEqual(a, b *big.Int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Cmp(b) == 0
+}
+
+// paramStore 协议参数的版本化存储，由 comptroller 事件和定期读取共同更新
+type paramStore struct {
+	mu      sync.RWMutex
+	current Params
+	oracle  *bind.BoundContract
+}
+
+func (p *Protocol) Params() Params {
+	p.params.mu.RLock()
+	defer p.params.mu.RUnlock()
+	return p.params.current
+}
+
+func (p *Protocol) getOracle() *bind.BoundContract {
+	p.params.mu.RLock()
+	defer p.params.mu.RUnlock()
+	return p.params.oracle
+}
+
+// updateParams 在当前参数的副本上修改，有变化时生成新版本
+func (p *Protocol) updateParams(source string, fn func(params *Params)) {
+	s := &p.params
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	next := s.current
+	fn(&next)
+	if next.equal(s.current) {
+		return
+	}
+	if next.Oracle != s.current.Oracle {
+		s.oracle = p.newOracle(common.HexToAddress(next.Oracle))
+	}
+	next.Version = s.current.Version + 1
+	next.UpdatedAt = time.Now()
+	log.Printf("[%s] params v%d from %s: closeFactor=%s incentive=%s oracle=%s maxAssets=%s seizePaused=%t transferPaused=%t",
+		p.Name, next.Version, source, next.CloseFactor, next.LiquidationIncentive, next.Oracle, next.MaxAssets, next.SeizePaused, next.TransferPaused)
+	s.current = next
+}
+
+// RefreshParams 从链上重新读取所有协议参数，任意一项读取失败时不更新
+func (p *Protocol) RefreshParams() error {
+	closeFactor, err := p.comptroller.CloseFactorMantissa(nil)
+	if err != nil {
+		return err
+	}
+	incentive, err := p.comptroller.LiquidationIncentiveMantissa(nil)
+	if err != nil {
+		return err
+	}
+	oracle, err := p.comptroller.Oracle(nil)
+	if err != nil {
+		return err
+	}
+	maxAssets, err := p.comptroller.MaxAssets(nil)
+	if err != nil {
+		return err
+	}
+	seizePaused, err := p.comptroller.SeizeGuardianPaused(nil)
+	if err != nil {
+		return err
+	}
+	transferPaused, err := p.comptroller.TransferGuardianPaused(nil)
+	if err != nil {
+		return err
+	}
+	p.updateParams("refresh", func(params *Params) {
+		params.CloseFactor = decimal.NewFromBigInt(closeFactor, -18)
+		params.LiquidationIncentive = decimal.NewFromBigInt(incentive, -18)
+		params.Oracle = oracle.String()
+		params.MaxAssets = maxAssets
+		params.SeizePaused = seizePaused
+		params.TransferPaused = transferPaused
+	})
+	return nil
+}
+
+func (p *Protocol) GetCloseFactor() decimal.Decimal {
+	return p.Params().CloseFactor
+}
+
+func (p *Protocol) GetLiquidationIncentive() decimal.Decimal {
+	return p.Params().LiquidationIncentive
+}
@@ -0,0 +1,40 @@
+package contract
+
+import (
+	"sync"
+)
+
+var (
+	unpauseMu    sync.RWMutex
+	unpauseHooks []func(p *Protocol, action, market string)
+)
+
+// OnUnpause 注册 ActionPaused(false) 事件的回调，market 为空表示协议级别的 action
+func OnUnpause(fn func(p *Protocol, action, market string)) {
+	unpauseMu.Lock()
+	defer unpauseMu.Unlock()
+	unpauseHooks = append(unpauseHooks, fn)
+}
+
+func (p *Protocol) notifyUnpause(action, market string) {
+	unpauseMu.RLock()
+	hooks := unpauseHooks
+	unpauseMu.RUnlock()
+	for _, fn := range hooks {
+		fn(p, action, market)
+	}
+}
+
+// PausedAction 返回 pause guardian 暂停的、会阻止清算的协议级 action（Seize 或 Transfer），没有时返回空字符串。
+// liquidateBorrowAllowed 和 seizeAllowed 只检查 seize 暂停，部分分叉在 seize 时还会检查 transfer 暂停；
+// 市场的 mint/borrow 暂停不影响清算，已暂停借款或弃用的市场同样需要清算
+func (p *Protocol) PausedAction() string {
+	params := p.Params()
+	if params.SeizePaused {
+		return "Seize"
+	}
+	if params.TransferPaused {
+		return "Transfer"
+	}
+	return ""
+}
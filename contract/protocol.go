@@ -0,0 +1,171 @@
+package contract
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/shopspring/decimal"
+
+	"liquidator/decoder"
+	"liquidator/log"
+)
+
+// Protocol 一个 Compound 分叉的 comptroller，各自有独立的市场、close factor、清算奖励和预言机
+type Protocol struct {
+	Name        string
+	Chain       *Chain
+	address     common.Address
+	comptroller *Comptroller
+	registry    *MarketRegistry
+
+	params paramStore
+
+	mu        sync.Mutex
+	lastBlock uint64
+}
+
+func (c *Chain) NewProtocol(name, comptroller string) (*Protocol, error) {
+	address := common.HexToAddress(comptroller)
+	instance, err := NewComptroller(address, c.client)
+	if err != nil {
+		return nil, err
+	}
+	p := &Protocol{Name: name, Chain: c, address: address, comptroller: instance, registry: newMarketRegistry()}
+	if err := p.RefreshParams(); err != nil {
+		log.Printf("[%s] refresh params error: %s", name, err)
+	}
+	if err := p.SyncMarkets(); err != nil {
+		log.Printf("[%s] sync markets error: %s", name, err)
+	}
+	return p, nil
+}
+
+// Protocols 所有链上的协议
+func Protocols() []*Protocol {
+	result := make([]*Protocol, 0)
+	for _, c := range chains {
+		result = append(result, c.protocols...)
+	}
+	return result
+}
+
+// GetProtocol 按名称查找，name 为空时返回第一个
+func GetProtocol(name string) (*Protocol, error) {
+	for _, p := range Protocols() {
+		if name == "" || strings.EqualFold(p.Name, name) {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown protocol %q", name)
+}
+
+func (p *Protocol) Address() string {
+	return p.address.String()
+}
+
+// GetAccountLiquidity 返回链上计算的流动性和缺口
+func (p *Protocol) GetAccountLiquidity(address string) (*big.Int, *big.Int, error) {
+	account := common.HexToAddress(address)
+	code, liquidity, shortfall, err := p.comptroller.GetAccountLiquidity(nil, account)
+	if err != nil {
+		return nil, nil, callError("GetAccountLiquidity", err)
+	}
+	if err := decoder.Code(decoder.Comptroller, "GetAccountLiquidity", code); err != nil {
+		return nil, nil, err
+	}
+	return liquidity, shortfall, nil
+}
+
+// GetHypotheticalAccountLiquidity 假设赎回 redeemTokens 个 pToken 并借出 borrowAmount 后的流动性和缺口
+func (p *Protocol) GetHypotheticalAccountLiquidity(address, pToken string, redeemTokens, borrowAmount *big.Int) (*big.Int, *big.Int, error) {
+	account := common.HexToAddress(address)
+	code, liquidity, shortfall, err := p.comptroller.GetHypotheticalAccountLiquidity(nil, account, common.HexToAddress(pToken), redeemTokens, borrowAmount)
+	if err != nil {
+		return nil, nil, callError("GetHypotheticalAccountLiquidity", err)
+	}
+	if err := decoder.Code(decoder.Comptroller, "GetHypotheticalAccountLiquidity", code); err != nil {
+		return nil, nil, err
+	}
+	return liquidity, shortfall, nil
+}
+
+func (p *Protocol) IsHighRisk(address string) (bool, error) {
+	shortfall, err := p.GetShortfall(address)
+	if err != nil {
+		return false, err
+	}
+	return shortfall.Sign() > 0, nil
+}
+
+func (p *Protocol) GetShortfall(address string) (*big.Int, error) {
+	_, shortfall, err := p.GetAccountLiquidity(address)
+	return shortfall, err
+}
+
+// GetLiquidateRepayAmount 按给定的 close factor 计算最多可偿还的借款
+func (p *Protocol) GetLiquidateRepayAmount(pToken string, borrower string, closeFactor decimal.Decimal) (*big.Int, error) {
+	pTokenInstance, err := p.Chain.ptoken(common.HexToAddress(pToken))
+	if err != nil {
+		return nil, err
+	}
+	borrowBalance, err := pTokenInstance.BorrowBalanceStored(nil, common.HexToAddress(borrower))
+	if err != nil {
+		return nil, callError("BorrowBalanceStored", err)
+	}
+	return decimal.NewFromBigInt(borrowBalance, 0).Mul(closeFactor).BigInt(), nil
+}
+
+func (p *Protocol) LiquidateCalculateSeizeTokens(pTokenBorrowed, pTokenCollateral string, actualRepayAmount *big.Int) (*big.Int, error) {
+	borrowed := common.HexToAddress(pTokenBorrowed)
+	collateral := common.HexToAddress(pTokenCollateral)
+	code, amount, err := p.comptroller.LiquidateCalculateSeizeTokens(nil, borrowed, collateral, actualRepayAmount)
+	if err != nil {
+		return nil, callError("LiquidateCalculateSeizeTokens", err)
+	}
+	if err := decoder.Code(decoder.Comptroller, "LiquidateCalculateSeizeTokens", code); err != nil {
+		return nil, err
+	}
+	return amount, nil
+}
+
+func (p *Protocol) GetCollaterals(borrower string) ([]string, error) {
+	assets, err := p.comptroller.GetAssetsIn(nil, common.HexToAddress(borrower))
+	if err != nil {
+		return nil, callError("GetAssetsIn", err)
+	}
+	result := make([]string, 0)
+	for _, asset := range assets {
+		result = append(result, asset.String())
+	}
+	return result, nil
+}
+
+// GetAllMarkets 返回市场列表中所有 pToken 地址
+func (p *Protocol) GetAllMarkets() []string {
+	markets := p.registry.List()
+	result := make([]string, 0, len(markets))
+	for _, m := range markets {
+		result = append(result, m.Address)
+	}
+	return result
+}
+
+func (p *Protocol) GetCollateralFactor(pToken string) (decimal.Decimal, error) {
+	if m, ok := p.registry.Get(pToken); ok {
+		return m.CollateralFactor, nil
+	}
+	_, collateralFactor, err := p.GetMarketListing(pToken)
+	return collateralFactor, err
+}
+
+// GetMarketListing 直接从 comptroller 读取市场是否上架和抵押率，不使用缓存
+func (p *Protocol) GetMarketListing(pToken string) (bool, decimal.Decimal, error) {
+	market, err := p.comptroller.Markets(nil, common.HexToAddress(pToken))
+	if err != nil {
+		return false, decimal.Zero, callError("Markets", err)
+	}
+	return market.IsListed, decimal.NewFromBigInt(market.CollateralFactorMantissa, -18), nil
+}
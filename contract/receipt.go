@@ -0,0 +1,76 @@
+package contract
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"liquidator/decoder"
+)
+
+// 拉取回执的间隔
+const receiptInterval = 3 * time.Second
+
+var ErrTxFailed = errors.New("transaction failed")
+
+// WaitReceipt 等待交易上链，ctx 取消时返回
+func (c *Chain) WaitReceipt(ctx context.Context, tx string) (*types.Receipt, error) {
+	hash := common.HexToHash(tx)
+	ticker := time.NewTicker(receiptInterval)
+	defer ticker.Stop()
+	for {
+		receipt, err := c.client.TransactionReceipt(ctx, hash)
+		if err == nil {
+			return receipt, nil
+		}
+		if !errors.Is(err, ethereum.NotFound) {
+			return nil, callError("TransactionReceipt", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// ReceiptFailures 解码回执中所有 Failure 事件。comptroller 发出的用 comptroller 的枚举，
+// 其他地址都按 pToken 处理
+func (p *Protocol) ReceiptFailures(receipt *types.Receipt) []*decoder.Failure {
+	failureID := comptrollerABI.Events["Failure"].ID
+	result := make([]*decoder.Failure, 0)
+	for _, l := range receipt.Logs {
+		if len(l.Topics) == 0 || l.Topics[0] != failureID {
+			continue
+		}
+		if l.Address == p.address {
+			if e, err := p.comptroller.ParseFailure(*l); err == nil {
+				result = append(result, decoder.NewFailure(decoder.Comptroller, e.Error, e.Info, e.Detail))
+			}
+			continue
+		}
+		pToken, err := NewPtokenFilterer(l.Address, p.Chain.client)
+		if err != nil {
+			continue
+		}
+		if e, err := pToken.ParseFailure(*l); err == nil {
+			result = append(result, decoder.NewFailure(decoder.Token, e.Error, e.Info, e.Detail))
+		}
+	}
+	return result
+}
+
+// CheckReceipt 交易 revert 或者包含 Failure 事件时返回错误
+func (p *Protocol) CheckReceipt(receipt *types.Receipt) error {
+	if failures := p.ReceiptFailures(receipt); len(failures) > 0 {
+		return failures[0]
+	}
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		return ErrTxFailed
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+package contract
+
+import (
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+)
+
+// PToken ABI 中缺少 redeem，这里单独补充
+const PtokenRedeemABI = "[{\"constant\":false,\"inputs\":[{\"internalType\":\"uint256\",\"name\":\"redeemTokens\",\"type\":\"uint256\"}],\"name\":\"redeem\",\"outputs\":[{\"internalType\":\"uint256\",\"name\":\"\",\"type\":\"uint256\"}],\"payable\":false,\"stateMutability\":\"nonpayable\",\"type\":\"function\"}]"
+
+var redeemABI abi.ABI
+
+func init() {
+	parsed, err := abi.JSON(strings.NewReader(PtokenRedeemABI))
+	if err != nil {
+		panic(err)
+	}
+	redeemABI = parsed
+}
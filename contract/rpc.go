@@ -0,0 +1,228 @@
+package contract
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+
+	"liquidator/alert"
+	"liquidator/log"
+)
+
+// rpcPool 一条链的多个 RPC 节点，节点不可用时切换到下一个。
+// 合约 revert 等 JSON-RPC 错误直接返回，不切换节点。
+type rpcPool struct {
+	name    string
+	urls    []string
+	clients []*ethclient.Client
+	current uint32
+}
+
+func dialPool(name string, urls []string) (*rpcPool, error) {
+	p := &rpcPool{name: name}
+	for _, url := range urls {
+		c, err := ethclient.Dial(url)
+		if err != nil {
+			log.Printf("[%s] dial %s error: %s", name, url, err)
+			continue
+		}
+		p.urls = append(p.urls, url)
+		p.clients = append(p.clients, c)
+	}
+	if len(p.clients) == 0 {
+		return nil, errors.New("no rpc endpoint available")
+	}
+	return p, nil
+}
+
+// verifyChainID 检查每个节点的链 ID 与配置一致，暂时连不上的节点只记录日志
+func (p *rpcPool) verifyChainID(ctx context.Context, want *big.Int) error {
+	for i, c := range p.clients {
+		id, err := c.ChainID(ctx)
+		if err != nil {
+			log.Printf("[%s] rpc %s chain id error: %s", p.name, p.urls[i], err)
+			continue
+		}
+		if id.Cmp(want) != 0 {
+			return fmt.Errorf("%w: config %s, rpc #%d returns %s", ErrChainIDMismatch, want, i, id)
+		}
+	}
+	return nil
+}
+
+func isNodeError(err error) bool {
+	var rpcErr rpc.Error
+	if errors.As(err, &rpcErr) {
+		return false
+	}
+	return !errors.Is(err, ethereum.NotFound) && !errors.Is(err, context.Canceled)
+}
+
+func (p *rpcPool) try(fn func(c *ethclient.Client) error) error {
+	n := uint32(len(p.clients))
+	start := atomic.LoadUint32(&p.current)
+	var err error
+	for i := uint32(0); i < n; i++ {
+		idx := (start + i) % n
+		if err = fn(p.clients[idx]); err == nil || !isNodeError(err) {
+			if i > 0 {
+				atomic.StoreUint32(&p.current, idx)
+				log.Printf("[%s] switched rpc to %s", p.name, p.urls[idx])
+			}
+			return err
+		}
+		log.Printf("[%s] rpc %s error: %s", p.name, p.urls[idx], err)
+	}
+	alert.Send(alert.Event{
+		Type:    alert.RPCDown,
+		Key:     p.name,
+		Title:   fmt.Sprintf("[%s] all rpc endpoints unavailable", p.name),
+		Message: p.redact(err),
+		Fields:  map[string]string{"chain": p.name, "endpoints": fmt.Sprint(len(p.urls))},
+	})
+	return err
+}
+
+// redact 去掉错误信息中的节点 URL，URL 中通常带有 API key，不能发送到告警渠道
+func (p *rpcPool) redact(err error) string {
+	msg := err.Error()
+	for _, url := range p.urls {
+		msg = strings.ReplaceAll(msg, url, "<rpc>")
+	}
+	return msg
+}
+
+func (p *rpcPool) CodeAt(ctx context.Context, contract common.Address, blockNumber *big.Int) (code []byte, err error) {
+	err = p.try(func(c *ethclient.Client) error {
+		code, err = c.CodeAt(ctx, contract, blockNumber)
+		return err
+	})
+	return
+}
+
+func (p *rpcPool) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) (out []byte, err error) {
+	err = p.try(func(c *ethclient.Client) error {
+		out, err = c.CallContract(ctx, call, blockNumber)
+		return err
+	})
+	return
+}
+
+func (p *rpcPool) HeaderByNumber(ctx context.Context, number *big.Int) (header *types.Header, err error) {
+	err = p.try(func(c *ethclient.Client) error {
+		header, err = c.HeaderByNumber(ctx, number)
+		return err
+	})
+	return
+}
+
+func (p *rpcPool) PendingCodeAt(ctx context.Context, account common.Address) (code []byte, err error) {
+	err = p.try(func(c *ethclient.Client) error {
+		code, err = c.PendingCodeAt(ctx, account)
+		return err
+	})
+	return
+}
+
+func (p *rpcPool) PendingNonceAt(ctx context.Context, account common.Address) (nonce uint64, err error) {
+	err = p.try(func(c *ethclient.Client) error {
+		nonce, err = c.PendingNonceAt(ctx, account)
+		return err
+	})
+	return
+}
+
+func (p *rpcPool) SuggestGasPrice(ctx context.Context) (price *big.Int, err error) {
+	err = p.try(func(c *ethclient.Client) error {
+		price, err = c.SuggestGasPrice(ctx)
+		return err
+	})
+	return
+}
+
+func (p *rpcPool) SuggestGasTipCap(ctx context.Context) (tip *big.Int, err error) {
+	err = p.try(func(c *ethclient.Client) error {
+		tip, err = c.SuggestGasTipCap(ctx)
+		return err
+	})
+	return
+}
+
+func (p *rpcPool) EstimateGas(ctx context.Context, call ethereum.CallMsg) (gas uint64, err error) {
+	err = p.try(func(c *ethclient.Client) error {
+		gas, err = c.EstimateGas(ctx, call)
+		return err
+	})
+	return
+}
+
+func (p *rpcPool) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	return p.try(func(c *ethclient.Client) error {
+		return c.SendTransaction(ctx, tx)
+	})
+}
+
+func (p *rpcPool) FilterLogs(ctx context.Context, query ethereum.FilterQuery) (logs []types.Log, err error) {
+	err = p.try(func(c *ethclient.Client) error {
+		logs, err = c.FilterLogs(ctx, query)
+		return err
+	})
+	return
+}
+
+func (p *rpcPool) SubscribeFilterLogs(ctx context.Context, query ethereum.FilterQuery, ch chan<- types.Log) (sub ethereum.Subscription, err error) {
+	err = p.try(func(c *ethclient.Client) error {
+		sub, err = c.SubscribeFilterLogs(ctx, query, ch)
+		return err
+	})
+	return
+}
+
+func (p *rpcPool) BlockNumber(ctx context.Context) (number uint64, err error) {
+	err = p.try(func(c *ethclient.Client) error {
+		number, err = c.BlockNumber(ctx)
+		return err
+	})
+	return
+}
+
+func (p *rpcPool) ChainID(ctx context.Context) (id *big.Int, err error) {
+	err = p.try(func(c *ethclient.Client) error {
+		id, err = c.ChainID(ctx)
+		return err
+	})
+	return
+}
+
+func (p *rpcPool) TransactionReceipt(ctx context.Context, txHash common.Hash) (receipt *types.Receipt, err error) {
+	err = p.try(func(c *ethclient.Client) error {
+		receipt, err = c.TransactionReceipt(ctx, txHash)
+		return err
+	})
+	return
+}
+
+func (p *rpcPool) TransactionByHash(ctx context.Context, txHash common.Hash) (tx *types.Transaction, isPending bool, err error) {
+	err = p.try(func(c *ethclient.Client) error {
+		tx, isPending, err = c.TransactionByHash(ctx, txHash)
+		return err
+	})
+	return
+}
+
+func (p *rpcPool) BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (balance *big.Int, err error) {
+	err = p.try(func(c *ethclient.Client) error {
+		balance, err = c.BalanceAt(ctx, account, blockNumber)
+		return err
+	})
+	return
+}
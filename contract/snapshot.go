@@ -0,0 +1,202 @@
+package contract
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/shopspring/decimal"
+
+	"liquidator/decoder"
+)
+
+// CollateralSnapshot 借款人在一个抵押物市场上的状态
+type CollateralSnapshot struct {
+	Market           string
+	Listed           bool
+	CollateralFactor decimal.Decimal
+	Balance          *big.Int
+}
+
+// BorrowerSnapshot 同一区块上读取的借款人清算所需数据
+type BorrowerSnapshot struct {
+	Block             *big.Int
+	Borrower          string
+	Market            string
+	BorrowBalance     *big.Int
+	MarketListed      bool
+	MarketComptroller string
+	Collaterals       []CollateralSnapshot
+}
+
+func firstErr(calls ...*Call) error {
+	for _, call := range calls {
+		if call.Err != nil {
+			return call.Err
+		}
+	}
+	return nil
+}
+
+// LoadBorrower 用两次 multicall 读取借款人在 market 上的借款和所有抵押物：
+// 第一批读取 GetAssetsIn、借款余额和偿还市场信息，第二批读取每个抵押物的上架状态和余额
+func (p *Protocol) LoadBorrower(ctx context.Context, borrower, market string) (*BorrowerSnapshot, error) {
+	head, err := p.Chain.GetBlockNumber(ctx)
+	if err != nil {
+		return nil, callError("BlockNumber", err)
+	}
+	block := new(big.Int).SetUint64(head)
+	account := common.HexToAddress(borrower)
+	pToken := common.HexToAddress(market)
+
+	assetsIn := newCall(p.address, &comptrollerABI, "getAssetsIn", account)
+	borrowBalance := newCall(pToken, &ptokenABI, "borrowBalanceStored", account)
+	marketInfo := newCall(p.address, &comptrollerABI, "markets", pToken)
+	comptroller := newCall(pToken, &ptokenABI, "comptroller")
+	if err := p.Chain.Multicall(ctx, block, []*Call{assetsIn, borrowBalance, marketInfo, comptroller}); err != nil {
+		return nil, err
+	}
+	if err := firstErr(assetsIn, borrowBalance, marketInfo, comptroller); err != nil {
+		return nil, err
+	}
+
+	snapshot := &BorrowerSnapshot{
+		Block:             block,
+		Borrower:          borrower,
+		Market:            market,
+		BorrowBalance:     *abi.ConvertType(borrowBalance.Out[0], new(*big.Int)).(**big.Int),
+		MarketListed:      marketInfo.Out[0].(bool),
+		MarketComptroller: comptroller.Out[0].(common.Address).String(),
+	}
+
+	assets := *abi.ConvertType(assetsIn.Out[0], new([]common.Address)).(*[]common.Address)
+	calls := make([]*Call, 0, len(assets)*2)
+	for _, asset := range assets {
+		calls = append(calls,
+			newCall(p.address, &comptrollerABI, "markets", asset),
+			newCall(asset, &ptokenABI, "balanceOf", account))
+	}
+	if err := p.Chain.Multicall(ctx, block, calls); err != nil {
+		return nil, err
+	}
+	for i, asset := range assets {
+		info, balance := calls[i*2], calls[i*2+1]
+		if err := firstErr(info, balance); err != nil {
+			return nil, err
+		}
+		snapshot.Collaterals = append(snapshot.Collaterals, CollateralSnapshot{
+			Market:           asset.String(),
+			Listed:           info.Out[0].(bool),
+			CollateralFactor: decimal.NewFromBigInt(*abi.ConvertType(info.Out[1], new(*big.Int)).(**big.Int), -18),
+			Balance:          *abi.ConvertType(balance.Out[0], new(*big.Int)).(**big.Int),
+		})
+	}
+	return snapshot, nil
+}
+
+// SeizeTokens 在快照区块上批量计算偿还 repayAmount 时每个抵押物可扣押的 pToken 数量
+func (p *Protocol) SeizeTokens(ctx context.Context, snapshot *BorrowerSnapshot, collaterals []string, repayAmount *big.Int) ([]*big.Int, error) {
+	borrowed := common.HexToAddress(snapshot.Market)
+	calls := make([]*Call, len(collaterals))
+	for i, collateral := range collaterals {
+		calls[i] = newCall(p.address, &comptrollerABI, "liquidateCalculateSeizeTokens", borrowed, common.HexToAddress(collateral), repayAmount)
+	}
+	if err := p.Chain.Multicall(ctx, snapshot.Block, calls); err != nil {
+		return nil, err
+	}
+	result := make([]*big.Int, len(calls))
+	for i, call := range calls {
+		if call.Err != nil {
+			return nil, call.Err
+		}
+		code := *abi.ConvertType(call.Out[0], new(*big.Int)).(**big.Int)
+		if err := decoder.Code(decoder.Comptroller, "LiquidateCalculateSeizeTokens", code); err != nil {
+			return nil, err
+		}
+		result[i] = *abi.ConvertType(call.Out[1], new(*big.Int)).(**big.Int)
+	}
+	return result, nil
+}
+
+// AssetSnapshot 账户在一个已进入市场上的存款、借款及该市场的兑换率、价格和抵押率
+type AssetSnapshot struct {
+	Market           string
+	Supply           *big.Int
+	Borrow           *big.Int
+	ExchangeRate     *big.Int
+	Price            *big.Int
+	CollateralFactor decimal.Decimal
+}
+
+// AccountSnapshot 同一区块上读取的账户所有仓位和 GetAccountLiquidity 的结果
+type AccountSnapshot struct {
+	Block     *big.Int
+	Borrower  string
+	Liquidity *big.Int
+	Shortfall *big.Int
+	Assets    []AssetSnapshot
+}
+
+// LoadAccount 用两次 multicall 读取账户的所有仓位：第一批读取 GetAssetsIn、GetAccountLiquidity 和预言机地址，
+// 第二批读取每个市场的账户快照、抵押率和价格。任意一个调用失败都返回错误，避免用不完整的仓位算出错误的健康度
+func (p *Protocol) LoadAccount(ctx context.Context, borrower string) (*AccountSnapshot, error) {
+	head, err := p.Chain.GetBlockNumber(ctx)
+	if err != nil {
+		return nil, callError("BlockNumber", err)
+	}
+	block := new(big.Int).SetUint64(head)
+	account := common.HexToAddress(borrower)
+
+	assetsIn := newCall(p.address, &comptrollerABI, "getAssetsIn", account)
+	liquidity := newCall(p.address, &comptrollerABI, "getAccountLiquidity", account)
+	oracle := newCall(p.address, &comptrollerABI, "oracle")
+	if err := p.Chain.Multicall(ctx, block, []*Call{assetsIn, liquidity, oracle}); err != nil {
+		return nil, err
+	}
+	if err := firstErr(assetsIn, liquidity, oracle); err != nil {
+		return nil, err
+	}
+	code := *abi.ConvertType(liquidity.Out[0], new(*big.Int)).(**big.Int)
+	if err := decoder.Code(decoder.Comptroller, "GetAccountLiquidity", code); err != nil {
+		return nil, err
+	}
+	snapshot := &AccountSnapshot{
+		Block:     block,
+		Borrower:  borrower,
+		Liquidity: *abi.ConvertType(liquidity.Out[1], new(*big.Int)).(**big.Int),
+		Shortfall: *abi.ConvertType(liquidity.Out[2], new(*big.Int)).(**big.Int),
+	}
+
+	oracleAddress := oracle.Out[0].(common.Address)
+	assets := *abi.ConvertType(assetsIn.Out[0], new([]common.Address)).(*[]common.Address)
+	calls := make([]*Call, 0, len(assets)*3)
+	for _, asset := range assets {
+		calls = append(calls,
+			newCall(asset, &ptokenABI, "getAccountSnapshot", account, false),
+			newCall(p.address, &comptrollerABI, "markets", asset),
+			newCall(oracleAddress, &oracleABI, "getUnderlyingPrice", asset))
+	}
+	if err := p.Chain.Multicall(ctx, block, calls); err != nil {
+		return nil, err
+	}
+	for i, asset := range assets {
+		accountSnapshot, info, price := calls[i*3], calls[i*3+1], calls[i*3+2]
+		if err := firstErr(accountSnapshot, info, price); err != nil {
+			return nil, err
+		}
+		code := *abi.ConvertType(accountSnapshot.Out[0], new(*big.Int)).(**big.Int)
+		if err := decoder.Code(decoder.Token, "GetAccountSnapshot", code); err != nil {
+			return nil, err
+		}
+		snapshot.Assets = append(snapshot.Assets, AssetSnapshot{
+			Market:           asset.String(),
+			Supply:           *abi.ConvertType(accountSnapshot.Out[1], new(*big.Int)).(**big.Int),
+			Borrow:           *abi.ConvertType(accountSnapshot.Out[2], new(*big.Int)).(**big.Int),
+			ExchangeRate:     *abi.ConvertType(accountSnapshot.Out[3], new(*big.Int)).(**big.Int),
+			Price:            *abi.ConvertType(price.Out[0], new(*big.Int)).(**big.Int),
+			CollateralFactor: decimal.NewFromBigInt(*abi.ConvertType(info.Out[1], new(*big.Int)).(**big.Int), -18),
+		})
+	}
+	return snapshot, nil
+}
@@ -0,0 +1,102 @@
+// Package decoder 把 Compound 合约返回的错误码和 Failure 事件解码成可读的错误
+package decoder
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// Reporter 错误码所属的合约，comptroller 和 pToken 使用不同的枚举
+type Reporter int
+
+const (
+	Comptroller Reporter = iota
+	Token
+)
+
+func (r Reporter) String() string {
+	if r == Comptroller {
+		return "comptroller"
+	}
+	return "token"
+}
+
+func (r Reporter) errors() []string {
+	if r == Comptroller {
+		return comptrollerErrors
+	}
+	return tokenErrors
+}
+
+func (r Reporter) infos() []string {
+	if r == Comptroller {
+		return comptrollerFailureInfos
+	}
+	return tokenFailureInfos
+}
+
+func lookup(names []string, code uint64) string {
+	if code < uint64(len(names)) {
+		return names[code]
+	}
+	return fmt.Sprintf("UNKNOWN(%d)", code)
+}
+
+// ErrorName 返回错误码对应的枚举名
+func (r Reporter) ErrorName(code uint64) string {
+	return lookup(r.errors(), code)
+}
+
+// InfoName 返回 FailureInfo 对应的枚举名
+func (r Reporter) InfoName(code uint64) string {
+	return lookup(r.infos(), code)
+}
+
+// CodeError 合约调用成功但返回了非 0 错误码
+type CodeError struct {
+	Reporter Reporter
+	Method   string
+	Code     uint64
+}
+
+func (e *CodeError) Error() string {
+	return fmt.Sprintf("%s: %s error %s", e.Method, e.Reporter, e.Reporter.ErrorName(e.Code))
+}
+
+// Code 错误码为 0 时返回 nil
+func Code(reporter Reporter, method string, code *big.Int) error {
+	if code == nil || code.Sign() == 0 {
+		return nil
+	}
+	return &CodeError{Reporter: reporter, Method: method, Code: code.Uint64()}
+}
+
+// Failure 合约以 Failure(error, info, detail) 事件代替 revert 报告的失败
+type Failure struct {
+	Reporter Reporter
+	Code     uint64
+	Info     uint64
+	Detail   uint64
+}
+
+func NewFailure(reporter Reporter, code, info, detail *big.Int) *Failure {
+	return &Failure{Reporter: reporter, Code: code.Uint64(), Info: info.Uint64(), Detail: detail.Uint64()}
+}
+
+func (f *Failure) Error() string {
+	return fmt.Sprintf("%s failure %s at %s%s", f.Reporter, f.Reporter.ErrorName(f.Code), f.Reporter.InfoName(f.Info), f.detail())
+}
+
+// detail 的含义取决于错误码：被 comptroller 拒绝时是 comptroller 错误码，数学错误时是 MathError
+func (f *Failure) detail() string {
+	if f.Detail == 0 {
+		return ""
+	}
+	switch f.Reporter.ErrorName(f.Code) {
+	case "COMPTROLLER_REJECTION", "COMPTROLLER_CALCULATION_ERROR":
+		return " (comptroller " + Comptroller.ErrorName(f.Detail) + ")"
+	case "MATH_ERROR":
+		return " (" + lookup(mathErrors, f.Detail) + ")"
+	}
+	return fmt.Sprintf(" (detail %d)", f.Detail)
+}
@@ -0,0 +1,87 @@
+package executor
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"liquidator/alert"
+	"liquidator/conf"
+	"liquidator/contract"
+)
+
+// defaultStuckAfter 未配置 alerts.stuckAfter 时判断交易卡住的时间
+const defaultStuckAfter = 3 * time.Minute
+
+func planFields(plan Plan) map[string]string {
+	return map[string]string{
+		"cid":         plan.CorrelationID,
+		"protocol":    plan.Protocol,
+		"borrower":    plan.Borrower,
+		"market":      plan.RepayMarket,
+		"collateral":  plan.Collateral,
+		"repayAmount": fmt.Sprint(plan.RepayAmount),
+	}
+}
+
+// alertWalletBalance 钱包余额不足以偿还时告警：偿还价值不低于 alerts.largeAccount 的作为无力清算的大额账户，
+// 其他的作为该市场余额不足
+func alertWalletBalance(p *contract.Protocol, plan Plan, err error) {
+	fields := planFields(plan)
+	value := decimal.Zero
+	if price, priceErr := p.GetUnderlyingPrice(plan.RepayMarket); priceErr == nil {
+		value = usdValue(plan.RepayAmount, price)
+		fields["repayValue"] = value.StringFixed(2)
+	}
+	large := decimal.NewFromFloat(conf.Get().Alerts.LargeAccount)
+	if large.IsPositive() && value.GreaterThanOrEqual(large) {
+		alert.Send(alert.Event{
+			Type:    alert.Unaffordable,
+			Key:     plan.Protocol + ":" + plan.Borrower,
+			Title:   fmt.Sprintf("[%s] cannot afford to liquidate %s ($%s)", plan.Protocol, plan.Borrower, value.StringFixed(0)),
+			Message: err.Error(),
+			Fields:  fields,
+		})
+		return
+	}
+	alert.Send(alert.Event{
+		Type:    alert.LowBalance,
+		Key:     p.Chain.Name + ":" + plan.RepayMarket,
+		Title:   fmt.Sprintf("[%s] wallet balance too low to repay %s", plan.Protocol, plan.RepayMarket),
+		Message: err.Error(),
+		Fields:  fields,
+	})
+}
+
+func alertReverted(p *contract.Protocol, plan Plan, tx string, err error) {
+	fields := planFields(plan)
+	fields["tx"] = tx
+	alert.Send(alert.Event{
+		Type:    alert.LiquidationReverted,
+		Key:     tx,
+		Title:   fmt.Sprintf("[%s] liquidation of %s failed", p.Name, plan.Borrower),
+		Message: err.Error(),
+		Fields:  fields,
+	})
+}
+
+// watchStuck 交易提交后超过 alerts.stuckAfter 仍没有回执时告警，返回的函数在收到回执后调用
+func watchStuck(p *contract.Protocol, plan Plan, tx string) (stop func() bool) {
+	after := conf.Get().Alerts.StuckAfter
+	if after <= 0 {
+		after = defaultStuckAfter
+	}
+	timer := time.AfterFunc(after, func() {
+		fields := planFields(plan)
+		fields["tx"] = tx
+		alert.Send(alert.Event{
+			Type:    alert.TxStuck,
+			Key:     tx,
+			Title:   fmt.Sprintf("[%s] liquidation tx pending for over %s", p.Name, after),
+			Message: tx,
+			Fields:  fields,
+		})
+	})
+	return timer.Stop
+}
@@ -0,0 +1,72 @@
+package executor
+
+import (
+	"strings"
+	"sync"
+)
+
+const allMarkets = "*"
+
+var (
+	controlMu   sync.RWMutex
+	paused      = make(map[string]bool)
+	blacklisted = make(map[string]bool)
+)
+
+// Pause 暂停指定市场，market 为空时暂停全部市场
+func Pause(market string) {
+	controlMu.Lock()
+	defer controlMu.Unlock()
+	paused[marketKey(market)] = true
+}
+
+func Resume(market string) {
+	controlMu.Lock()
+	defer controlMu.Unlock()
+	delete(paused, marketKey(market))
+}
+
+func IsPaused(market string) bool {
+	controlMu.RLock()
+	defer controlMu.RUnlock()
+	return paused[allMarkets] || paused[marketKey(market)]
+}
+
+func PausedMarkets() []string {
+	controlMu.RLock()
+	defer controlMu.RUnlock()
+	result := make([]string, 0, len(paused))
+	for market := range paused {
+		result = append(result, market)
+	}
+	return result
+}
+
+func Blacklist(borrower string) {
+	controlMu.Lock()
+	defer controlMu.Unlock()
+	blacklisted[strings.ToLower(borrower)] = true
+}
+
+func IsBlacklisted(borrower string) bool {
+	controlMu.RLock()
+	defer controlMu.RUnlock()
+	return blacklisted[strings.ToLower(borrower)]
+}
+
+func Blacklisted() []string {
+	controlMu.RLock()
+	defer controlMu.RUnlock()
+	result := make([]string, 0, len(blacklisted))
+	for borrower := range blacklisted {
+		result = append(result, borrower)
+	}
+	return result
+}
+
+func marketKey(market string) string {
+	if market == "" {
+		return allMarkets
+	}
+	return strings.ToLower(market)
+}
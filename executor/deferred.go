@@ -0,0 +1,74 @@
+package executor
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"liquidator/contract"
+	"liquidator/handler"
+	"liquidator/log"
+)
+
+// Deferred 因 pause guardian 暂停而推迟的清算，收到 Action 的 ActionPaused(false) 后重新入队
+type Deferred struct {
+	Token      handler.AccountToken
+	Action     string
+	Reason     string
+	DeferredAt time.Time
+}
+
+var (
+	deferredMu sync.Mutex
+	deferred   = make(map[string]Deferred)
+)
+
+func deferToken(token handler.AccountToken, err error) {
+	key := strings.ToLower(token.Protocol + ":" + token.Market.Id + ":" + token.Account.Id)
+	var pause *PauseError
+	action := ""
+	if errors.As(err, &pause) {
+		action = pause.Action
+	}
+	deferredMu.Lock()
+	deferred[key] = Deferred{Token: token, Action: action, Reason: err.Error(), DeferredAt: time.Now()}
+	deferredMu.Unlock()
+	token.Logger().Printf("[%s] liquidate %s deferred: %s", token.Protocol, token.Account.Id, err)
+}
+
+// retryDeferred 协议级 action 解除暂停时，只把被该 action 阻止的清算重新入队，
+// 仍然被其他 action 暂停的会在计划阶段再次被推迟；市场级的 mint/borrow 不阻止清算，忽略
+func retryDeferred(p *contract.Protocol, action, market string) {
+	if market != "" {
+		return
+	}
+	deferredMu.Lock()
+	tokens := make([]handler.AccountToken, 0)
+	for key, d := range deferred {
+		if d.Token.Protocol == p.Name && strings.EqualFold(d.Action, action) {
+			tokens = append(tokens, d.Token)
+			delete(deferred, key)
+		}
+	}
+	deferredMu.Unlock()
+	if len(tokens) == 0 {
+		return
+	}
+	log.Printf("[%s] %s unpaused, retry %d deferred liquidations", p.Name, action, len(tokens))
+	go func() {
+		for _, token := range tokens {
+			handler.Requeue(token)
+		}
+	}()
+}
+
+func DeferredLiquidations() []Deferred {
+	deferredMu.Lock()
+	defer deferredMu.Unlock()
+	result := make([]Deferred, 0, len(deferred))
+	for _, d := range deferred {
+		result = append(result, d)
+	}
+	return result
+}
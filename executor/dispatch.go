@@ -0,0 +1,73 @@
+package executor
+
+import (
+	"strings"
+	"sync"
+
+	"liquidator/handler"
+)
+
+// chainQueue 一条链待处理的账户。Run 放入时不阻塞，某条链的 worker 卡住只会让这条链的队列变长，不影响其他链；
+// 同一个借款人和市场只保留最新的一个，队列长度不超过这条链上资不抵债的借款人数量
+type chainQueue struct {
+	mu     sync.Mutex
+	order  []string
+	tokens map[string]handler.AccountToken
+	closed bool
+	ready  chan struct{}
+}
+
+func newChainQueue() *chainQueue {
+	return &chainQueue{tokens: make(map[string]handler.AccountToken), ready: make(chan struct{}, 1)}
+}
+
+func queueKey(token handler.AccountToken) string {
+	return strings.ToLower(token.Protocol + ":" + token.Market.Id + ":" + token.Account.Id)
+}
+
+// push 放入账户，已在队列中时替换为新的 token，保持原来的位置
+func (q *chainQueue) push(token handler.AccountToken) {
+	key := queueKey(token)
+	q.mu.Lock()
+	if _, ok := q.tokens[key]; !ok {
+		q.order = append(q.order, key)
+	}
+	q.tokens[key] = token
+	q.mu.Unlock()
+	q.signal()
+}
+
+// pop 取出最早放入的账户，队列为空时等待；close 之后取完剩下的账户再返回 false
+func (q *chainQueue) pop() (handler.AccountToken, bool) {
+	for {
+		q.mu.Lock()
+		if len(q.order) > 0 {
+			key := q.order[0]
+			q.order = q.order[1:]
+			token := q.tokens[key]
+			delete(q.tokens, key)
+			q.mu.Unlock()
+			return token, true
+		}
+		closed := q.closed
+		q.mu.Unlock()
+		if closed {
+			return handler.AccountToken{}, false
+		}
+		<-q.ready
+	}
+}
+
+func (q *chainQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.signal()
+}
+
+func (q *chainQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
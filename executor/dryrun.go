@@ -0,0 +1,124 @@
+package executor
+
+import (
+	"math/big"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"liquidator/contract"
+	"liquidator/opportunity"
+)
+
+// StatusSimulated dryRun 模式下模拟成功的清算
+const StatusSimulated = "simulated"
+
+// StatusAllowanceMissing dryRun 模式下钱包没有授权 pToken 花费偿还资产，没有模拟清算
+const StatusAllowanceMissing = "allowance_missing"
+
+// recordSimulation dryRun 模式下代替 watchReceipt：读取 eth_call 的模拟结果，
+// 按预言机价格估算收益，记录日志并写入机会记录
+func recordSimulation(p *contract.Protocol, l *Liquidation, id string) {
+	logger := l.Plan.Logger().With("tx", id)
+	sim, ok := p.Chain.Simulation(id)
+	if !ok {
+		logger.Printf("[%s] dry run %s: simulation not found", p.Name, id)
+		return
+	}
+	plan := l.Plan
+	o := opportunity.Opportunity{
+		ID:               id,
+		CorrelationID:    plan.CorrelationID,
+		Time:             sim.Time,
+		Chain:            p.Chain.Name,
+		Protocol:         p.Name,
+		Borrower:         plan.Borrower,
+		RepayMarket:      plan.RepayMarket,
+		Collateral:       plan.Collateral,
+		RepayAmount:      plan.RepayAmount,
+		Block:            sim.Block,
+		DryRun:           true,
+		Success:          sim.Success,
+		Error:            sim.Error,
+		AllowanceMissing: sim.AllowanceMissing,
+		Gas:              sim.Gas,
+		GasPrice:         sim.GasPrice,
+	}
+	if err := estimateProfit(p, &o); err != nil {
+		logger.Printf("[%s] dry run %s estimate profit error: %s", p.Name, id, err)
+	}
+	if !opportunity.Add(o) {
+		logger.Debug("[%s] dry run %s: duplicate of the last record for %s, not saved", p.Name, id, plan.Borrower)
+	}
+
+	liquidationsMu.Lock()
+	l.FinishedAt = time.Now()
+	switch {
+	case sim.Success:
+		l.Status = StatusSimulated
+	case sim.AllowanceMissing:
+		l.Status = StatusAllowanceMissing
+		l.Error = sim.Error
+	default:
+		l.Status = StatusFailed
+		l.Error = sim.Error
+	}
+	liquidationsMu.Unlock()
+	logger.With("block", sim.Block, "success", sim.Success, "error", sim.Error, "gas", sim.Gas, "gasPrice", sim.GasPrice,
+		"seizeTokens", o.SeizeTokens, "profit", o.Profit.StringFixed(2), "gasCost", o.GasCost.StringFixed(2)).
+		Printf("[%s] dry run liquidation %s: success=%t profit=%s", p.Name, id, sim.Success, o.Profit.StringFixed(2))
+}
+
+// usdValue 底层资产数量按预言机价格折算为美元，价格已按底层资产精度放大
+func usdValue(amount, price *big.Int) decimal.Decimal {
+	return decimal.NewFromBigInt(amount, 0).Mul(decimal.NewFromBigInt(price, -36))
+}
+
+// estimateProfit 收益 = 扣押抵押物价值 - 偿还价值 - gas 成本，
+// gas 成本按原生币市场的价格折算，协议没有原生币市场时不计
+func estimateProfit(p *contract.Protocol, o *opportunity.Opportunity) error {
+	seizeTokens, err := p.LiquidateCalculateSeizeTokens(o.RepayMarket, o.Collateral, o.RepayAmount)
+	if err != nil {
+		return err
+	}
+	o.SeizeTokens = seizeTokens
+	_, _, exchangeRate, err := p.Chain.GetAccountSnapshot(o.Collateral, o.Borrower)
+	if err != nil {
+		return err
+	}
+	repayPrice, err := p.GetUnderlyingPrice(o.RepayMarket)
+	if err != nil {
+		return err
+	}
+	collateralPrice, err := p.GetUnderlyingPrice(o.Collateral)
+	if err != nil {
+		return err
+	}
+	o.RepayValue = usdValue(o.RepayAmount, repayPrice)
+	seized := new(big.Int).Div(new(big.Int).Mul(seizeTokens, exchangeRate), big.NewInt(1e18))
+	o.SeizeValue = usdValue(seized, collateralPrice)
+
+	if o.Gas > 0 && o.GasPrice != nil {
+		o.GasCost, err = gasCost(p, o.Gas, o.GasPrice)
+		if err != nil {
+			return err
+		}
+	}
+	o.Profit = o.SeizeValue.Sub(o.RepayValue).Sub(o.GasCost)
+	return nil
+}
+
+// gasCost gas 费用按原生币市场的价格折算为美元，协议没有原生币市场时为 0
+func gasCost(p *contract.Protocol, gas uint64, gasPrice *big.Int) (decimal.Decimal, error) {
+	for _, m := range p.Markets() {
+		if m.Underlying != "" {
+			continue
+		}
+		nativePrice, err := p.GetUnderlyingPrice(m.Address)
+		if err != nil {
+			return decimal.Zero, err
+		}
+		return usdValue(new(big.Int).Mul(new(big.Int).SetUint64(gas), gasPrice), nativePrice), nil
+	}
+	return decimal.Zero, nil
+}
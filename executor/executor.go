@@ -1,48 +1,468 @@
 package executor
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"liquidator/conf"
 	"liquidator/contract"
 	"liquidator/handler"
 	"liquidator/log"
+	"liquidator/utils"
 	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+type Plan struct {
+	Protocol    string
+	Borrower    string
+	RepayMarket string
+	Collateral  string
+	RepayAmount *big.Int
+	// ParamsVersion 生成计划时使用的协议参数版本
+	ParamsVersion uint64
+	// CorrelationID 来自扫描到该借款人的 AccountToken，手动提交的计划没有时在 Execute 中生成
+	CorrelationID string `json:",omitempty"`
+}
+
+// Logger 返回带有 correlation ID 和计划内容字段的日志
+func (plan Plan) Logger() *log.Logger {
+	return log.With("cid", plan.CorrelationID, "protocol", plan.Protocol, "market", plan.RepayMarket, "borrower", plan.Borrower,
+		"collateral", plan.Collateral, "repayAmount", plan.RepayAmount, "paramsVersion", plan.ParamsVersion)
+}
+
+type Liquidation struct {
+	Plan      Plan
+	Status    string
+	Tx        string
+	Error     string `json:",omitempty"`
+	StartedAt time.Time
+	// FinishedAt 收到回执或模拟结果的时间，还在等待时为零值
+	FinishedAt time.Time
+}
+
+// PendingAt t 时刻这次清算是否已开始提交且还没有结果
+func (l Liquidation) PendingAt(t time.Time) bool {
+	return !l.StartedAt.After(t) && (l.FinishedAt.IsZero() || !l.FinishedAt.Before(t))
+}
+
+const liquidationRetention = 10 * time.Minute
+
+// pending 正在提交或已提交但还没有回执的清算，同一个借款人和市场不能再次提交，避免重复花费偿还资金。
+// 等不到回执的清算在 liquidationRetention 后被清理
+func (l *Liquidation) pending() bool {
+	return l.Status == StatusInFlight || l.Status == StatusSubmitted
+}
+
+const (
+	StatusInFlight  = "inflight"
+	StatusSubmitted = "submitted"
+	StatusConfirmed = "confirmed"
+	StatusFailed    = "failed"
+)
+
+var (
+	ErrPaused         = errors.New("market paused")
+	ErrBlacklisted    = errors.New("borrower blacklisted")
+	ErrNotUnderwater  = errors.New("borrower has no shortfall")
+	ErrInvalidAmount  = errors.New("invalid repay amount")
+	ErrWalletBalance  = errors.New("wallet not enough balance")
+	ErrSeizeTooLarge  = errors.New("collateral balance less than seize amount")
+	ErrNoCollateral   = errors.New("borrower has no usable collateral")
+	ErrAlreadyRunning = errors.New("liquidation already in flight")
+	ErrStaleParams    = errors.New("protocol params changed since plan was made")
+	ErrGuardianPaused = errors.New("paused by pause guardian")
+	// ErrCheckFailed 链上数据读取失败，无法判断是否存在清算机会
+	ErrCheckFailed = errors.New("could not check")
+)
+
+// CheckError 读取链上数据失败，与 ErrNotUnderwater 等“没有清算机会”的错误区分
+type CheckError struct {
+	Err error
+}
+
+func (e *CheckError) Error() string {
+	return ErrCheckFailed.Error() + ": " + e.Err.Error()
+}
+
+func (e *CheckError) Unwrap() error {
+	return e.Err
+}
+
+func (e *CheckError) Is(target error) bool {
+	return target == ErrCheckFailed
+}
+
+func checkFailed(err error) error {
+	return &CheckError{Err: err}
+}
+
+// PauseError pause guardian 暂停了 Action，清算推迟到该 action 解除暂停
+type PauseError struct {
+	Action string
+}
+
+func (e *PauseError) Error() string {
+	return ErrGuardianPaused.Error() + ": " + e.Action
+}
+
+func (e *PauseError) Is(target error) bool {
+	return target == ErrGuardianPaused
+}
+
+var (
+	liquidationsMu sync.RWMutex
+	liquidations   = make(map[string]*Liquidation)
 )
 
-func Run() {
+// Run 每条链一个清算 worker 和待处理队列，某条链 RPC 卡住或 panic 不影响其他链。
+// ctx 结束后停止取出新的账户，等所有 worker 处理完队列中的账户后返回
+func Run(ctx context.Context) {
 	log.Println("executor running")
+	contract.OnUnpause(retryDeferred)
+	queues := make(map[*contract.Chain]*chainQueue)
+	var wg sync.WaitGroup
+	for _, chain := range contract.Chains() {
+		q := newChainQueue()
+		queues[chain] = q
+		wg.Add(1)
+		go func(chain *contract.Chain) {
+			defer wg.Done()
+			work(chain, q)
+		}(chain)
+	}
+	defer func() {
+		for _, q := range queues {
+			q.close()
+		}
+		wg.Wait()
+		log.Println("executor stopped")
+	}()
 	for {
-		token := <-handler.TokenChan
-		log.Printf("receive token: %+v", token)
-		borrower := token.Account.Id
-		marketId := token.Market.Id
-		if contract.IsHighRisk(borrower) {
-			walletUnderlyingBalance := contract.GetWalletUnderlyingBalance(marketId)
-			repayAmount, collateral := calculateRepayAmountAndCollateral(token)
-			if walletUnderlyingBalance.Cmp(repayAmount) < 0 {
-				log.Printf("Wallet not enough balance of %s", token.Market.UnderlyingSymbol)
-			} else {
-				tx, err := contract.LiquidateBorrow(marketId, borrower, collateral, repayAmount)
-				if err == nil {
-					log.Printf("LiquidateBorrow tx: %s", tx)
-				}
-			}
+		token, ok := handler.Dequeue(ctx)
+		if !ok {
+			return
+		}
+		logger := token.Logger()
+		logger.Printf("[%s] receive token %s", token.Protocol, token.Account.Id)
+		p, err := contract.GetProtocol(token.Protocol)
+		if err != nil {
+			logger.Printf("[%s] %s", token.Protocol, err)
+			continue
+		}
+		q, ok := queues[p.Chain]
+		if !ok {
+			logger.Warn("[%s] chain %s has no worker, drop token %s", token.Protocol, p.Chain.Name, token.Account.Id)
+			continue
+		}
+		q.push(token)
+	}
+}
+
+func work(chain *contract.Chain, q *chainQueue) {
+	for {
+		token, ok := q.pop()
+		if !ok {
+			return
+		}
+		func() {
+			defer utils.Recover(chain.Name)
+			handle(token)
+		}()
+	}
+}
+
+func handle(token handler.AccountToken) {
+	plan, err := makePlan(token)
+	if errors.Is(err, ErrGuardianPaused) {
+		deferToken(token, err)
+		return
+	}
+	if errors.Is(err, ErrCheckFailed) {
+		token.Logger().Warn("[%s] plan %s failed, will retry next scan: %s", token.Protocol, token.Account.Id, err)
+		return
+	}
+	if err != nil {
+		logger := token.Logger()
+		var reject *RejectError
+		if errors.As(err, &reject) {
+			logger = logger.With("rule", reject.Reason)
+		}
+		logger.Printf("[%s] plan %s: no opportunity: %s", token.Protocol, token.Account.Id, err)
+		return
+	}
+	logger := plan.Logger()
+	logger.Printf("[%s] plan %s", plan.Protocol, plan.Borrower)
+	if tx, err := Execute(plan); errors.Is(err, ErrGuardianPaused) {
+		deferToken(token, err)
+	} else if errors.Is(err, ErrCheckFailed) {
+		logger.Warn("[%s] liquidate %s check failed, will retry next scan: %s", plan.Protocol, plan.Borrower, err)
+	} else if err != nil {
+		var reject *RejectError
+		if errors.As(err, &reject) {
+			logger = logger.With("rule", reject.Reason)
+		}
+		logger.Printf("[%s] liquidate %s skipped: %s", plan.Protocol, plan.Borrower, err)
+		if p, pErr := contract.GetProtocol(plan.Protocol); pErr == nil && errors.Is(err, ErrWalletBalance) {
+			alertWalletBalance(p, plan, err)
 		}
+	} else {
+		logger.With("tx", tx).Printf("[%s] LiquidateBorrow tx: %s, params v%d", plan.Protocol, tx, plan.ParamsVersion)
 	}
 }
 
-func calculateRepayAmountAndCollateral(token handler.AccountToken) (*big.Int, string) {
-	borrower := token.Account.Id
-	marketId := token.Market.Id
-	repayAmount := contract.GetLiquidateRepayAmount(marketId, borrower)
-	collaterals := contract.GetCollaterals(borrower)
-loop:
-	for _, collateral := range collaterals {
-		seizeAmount := contract.LiquidateCalculateSeizeTokens(marketId, collateral, repayAmount)
-		balance := contract.GetAssetBalance(collateral, borrower)
-		if balance.Cmp(seizeAmount) >= 0 {
-			return repayAmount, collateral
+func makePlan(token handler.AccountToken) (Plan, error) {
+	p, err := contract.GetProtocol(token.Protocol)
+	if err != nil {
+		return Plan{}, err
+	}
+	snapshot, err := p.LoadBorrower(context.Background(), token.Account.Id, token.Market.Id)
+	if err != nil {
+		return Plan{}, checkFailed(err)
+	}
+	if reject := checkBorrowMarket(p, snapshot); reject != nil {
+		recordRejection(Rejection{Protocol: p.Name, Borrower: token.Account.Id, Market: token.Market.Id, Reason: reject.Reason})
+		return Plan{}, reject
+	}
+	strategy := marketStrategy(p, token.Market.Id)
+	if err := checkStrategy(p, strategy, token.Market.Id); err != nil {
+		return Plan{}, rejectPlan(p, token.Account.Id, token.Market.Id, err)
+	}
+	params := p.Params()
+	repayAmount, collateral, err := calculateRepayAmountAndCollateral(token, p, params, strategy, snapshot)
+	if err != nil {
+		return Plan{}, rejectPlan(p, token.Account.Id, token.Market.Id, err)
+	}
+	if collateral == "" {
+		return Plan{}, ErrNoCollateral
+	}
+	if action := p.PausedAction(); action != "" {
+		return Plan{}, &PauseError{Action: action}
+	}
+	if err := checkMinProfit(p, params, strategy, token.Market.Id, repayAmount); err != nil {
+		return Plan{}, rejectPlan(p, token.Account.Id, token.Market.Id, err)
+	}
+	return Plan{
+		Protocol:      p.Name,
+		Borrower:      token.Account.Id,
+		RepayMarket:   token.Market.Id,
+		Collateral:    collateral,
+		RepayAmount:   repayAmount,
+		ParamsVersion: params.Version,
+		CorrelationID: token.CorrelationID,
+	}, nil
+}
+
+func PlanFor(protocol, borrower, market string) (Plan, error) {
+	token := handler.AccountToken{Protocol: protocol, CorrelationID: log.NewCorrelationID()}
+	token.Market.Id = market
+	token.Account.Id = borrower
+	return makePlan(token)
+}
+
+// Execute 对自动和手动的清算计划执行同样的安全检查后再提交
+func Execute(plan Plan) (string, error) {
+	if plan.CorrelationID == "" {
+		plan.CorrelationID = log.NewCorrelationID()
+	}
+	key := liquidationKey(plan)
+	liquidationsMu.Lock()
+	pruneLiquidations()
+	if l, ok := liquidations[key]; ok && l.pending() {
+		liquidationsMu.Unlock()
+		return "", ErrAlreadyRunning
+	}
+	l := &Liquidation{Plan: plan, Status: StatusInFlight, StartedAt: time.Now()}
+	liquidations[key] = l
+	liquidationsMu.Unlock()
+
+	var tx string
+	p, err := contract.GetProtocol(plan.Protocol)
+	if err == nil {
+		err = Check(plan)
+		// dryRun 模式下钱包余额不足也继续模拟，模拟结果中会带上合约返回的错误
+		if errors.Is(err, ErrWalletBalance) && p.Chain.DryRun() {
+			plan.Logger().Printf("[%s] dry run %s: %s", plan.Protocol, plan.Borrower, err)
+			err = nil
+		}
+	}
+	if err == nil {
+		tx, err = p.Chain.LiquidateBorrow(plan.RepayMarket, plan.Borrower, plan.Collateral, plan.RepayAmount)
+	}
+
+	liquidationsMu.Lock()
+	defer liquidationsMu.Unlock()
+	if err != nil {
+		delete(liquidations, key)
+		return "", err
+	}
+	l.Status = StatusSubmitted
+	l.Tx = tx
+	if p.Chain.DryRun() {
+		go recordSimulation(p, l, tx)
+	} else {
+		go watchReceipt(p, l, tx)
+	}
+	return tx, nil
+}
+
+// liquidationKey 手动提交的计划可能使用 checksum 地址，与 deferred 一样不区分大小写
+func liquidationKey(plan Plan) string {
+	return strings.ToLower(plan.Protocol + ":" + plan.RepayMarket + ":" + plan.Borrower)
+}
+
+// watchReceipt 等待清算交易上链，revert 或出现 Failure 事件时记录解码后的原因
+func watchReceipt(p *contract.Protocol, l *Liquidation, tx string) {
+	ctx, cancel := context.WithTimeout(context.Background(), liquidationRetention)
+	defer cancel()
+	logger := l.Plan.Logger().With("tx", tx)
+	stopStuck := watchStuck(p, l.Plan, tx)
+	receipt, err := p.Chain.WaitReceipt(ctx, tx)
+	stopStuck()
+	if err != nil {
+		logger.Printf("[%s] wait receipt %s error: %s", p.Name, tx, err)
+		return
+	}
+	err = p.CheckReceipt(receipt)
+	logger = logger.With("block", receipt.BlockNumber, "gasUsed", receipt.GasUsed)
+
+	liquidationsMu.Lock()
+	defer liquidationsMu.Unlock()
+	l.FinishedAt = time.Now()
+	if err != nil {
+		l.Status = StatusFailed
+		l.Error = err.Error()
+		logger.Printf("[%s] liquidation %s failed: %s", p.Name, tx, err)
+		alertReverted(p, l.Plan, tx, err)
+		return
+	}
+	l.Status = StatusConfirmed
+	logger.Printf("[%s] liquidation %s confirmed in block %s", p.Name, tx, receipt.BlockNumber)
+}
+
+// Check 提交前在最新区块上重新检查计划，自动和手动的计划与 makePlan 执行相同的市场检查和市场策略
+func Check(plan Plan) error {
+	p, err := contract.GetProtocol(plan.Protocol)
+	if err != nil {
+		return err
+	}
+	if IsPaused(plan.RepayMarket) || IsPaused(plan.Collateral) {
+		return ErrPaused
+	}
+	if IsBlacklisted(plan.Borrower) {
+		return ErrBlacklisted
+	}
+	if action := p.PausedAction(); action != "" {
+		return &PauseError{Action: action}
+	}
+	if plan.RepayAmount == nil || plan.RepayAmount.Sign() <= 0 {
+		return ErrInvalidAmount
+	}
+	// 手动提交的计划没有参数版本，不做检查
+	if version := p.Params().Version; plan.ParamsVersion != 0 && plan.ParamsVersion != version {
+		return fmt.Errorf("%w: v%d -> v%d", ErrStaleParams, plan.ParamsVersion, version)
+	}
+	if err := checkPlan(p, plan); err != nil {
+		return rejectPlan(p, plan.Borrower, plan.RepayMarket, err)
+	}
+	highRisk, err := p.IsHighRisk(plan.Borrower)
+	if err != nil {
+		return checkFailed(err)
+	}
+	if !highRisk {
+		return ErrNotUnderwater
+	}
+	walletBalance, err := p.GetWalletRepayBalance(plan.RepayMarket)
+	if err != nil {
+		return checkFailed(err)
+	}
+	if walletBalance.Cmp(plan.RepayAmount) < 0 {
+		return fmt.Errorf("%w: have %s, need %s", ErrWalletBalance, walletBalance, plan.RepayAmount)
+	}
+	seizeAmount, err := p.LiquidateCalculateSeizeTokens(plan.RepayMarket, plan.Collateral, plan.RepayAmount)
+	if err != nil {
+		return checkFailed(err)
+	}
+	balance, err := p.Chain.GetAssetBalance(plan.Collateral, plan.Borrower)
+	if err != nil {
+		return checkFailed(err)
+	}
+	if balance.Cmp(seizeAmount) < 0 {
+		return ErrSeizeTooLarge
+	}
+	return nil
+}
+
+// 已提交的清算保留一段时间供查询
+func pruneLiquidations() {
+	for key, l := range liquidations {
+		if l.Status != StatusInFlight && time.Since(l.StartedAt) > liquidationRetention {
+			delete(liquidations, key)
+		}
+	}
+}
+
+func Liquidations() []Liquidation {
+	liquidationsMu.RLock()
+	defer liquidationsMu.RUnlock()
+	result := make([]Liquidation, 0, len(liquidations))
+	for _, l := range liquidations {
+		result = append(result, *l)
+	}
+	return result
+}
+
+// SeizeFunc 返回偿还 repayAmount 时每个抵押物需要扣押的 pToken 数量，顺序与 collaterals 相同
+type SeizeFunc func(collaterals []string, repayAmount *big.Int) ([]*big.Int, error)
+
+// calculateRepayAmountAndCollateral 在快照区块上按市场策略和钱包余额限制偿还数量，再按策略的抵押物顺序找出能覆盖扣押数量的抵押物，
+// 被拒绝的抵押物会记录原因
+func calculateRepayAmountAndCollateral(token handler.AccountToken, p *contract.Protocol, params contract.Params, strategy conf.Strategy, snapshot *contract.BorrowerSnapshot) (*big.Int, string, error) {
+	repayAmount, err := limitRepayAmount(token, p, strategy, maxRepayAmount(snapshot, params))
+	if err != nil {
+		return nil, "", err
+	}
+	seize := func(collaterals []string, repayAmount *big.Int) ([]*big.Int, error) {
+		return p.SeizeTokens(context.Background(), snapshot, collaterals, repayAmount)
+	}
+	return chooseCollateral(repayAmount, strategyCollaterals(p, strategy, snapshot, validCollaterals(p, snapshot)), seize)
+}
+
+// PlanFromSnapshot 只根据快照和协议参数计算偿还数量和抵押物，不读取链上数据，用于回测
+func PlanFromSnapshot(snapshot *contract.BorrowerSnapshot, params contract.Params, seize SeizeFunc) (*big.Int, string, error) {
+	collaterals, _ := filterCollaterals(snapshot)
+	return chooseCollateral(maxRepayAmount(snapshot, params), collaterals, seize)
+}
+
+func maxRepayAmount(snapshot *contract.BorrowerSnapshot, params contract.Params) *big.Int {
+	return decimal.NewFromBigInt(snapshot.BorrowBalance, 0).Mul(params.CloseFactor).BigInt()
+}
+
+// chooseCollateral 找出第一个余额足够被扣押的抵押物，都不够时把偿还数量减半重试
+func chooseCollateral(repayAmount *big.Int, collaterals []contract.CollateralSnapshot, seize SeizeFunc) (*big.Int, string, error) {
+	if len(collaterals) == 0 || repayAmount.Sign() <= 0 {
+		return repayAmount, "", nil
+	}
+	markets := make([]string, len(collaterals))
+	for i, collateral := range collaterals {
+		markets[i] = collateral.Market
+	}
+	for repayAmount.Sign() > 0 {
+		seizeAmounts, err := seize(markets, repayAmount)
+		if err != nil {
+			return nil, "", err
+		}
+		for i, collateral := range collaterals {
+			if collateral.Balance.Cmp(seizeAmounts[i]) >= 0 {
+				return repayAmount, collateral.Market, nil
+			}
 		}
+		// 如果执行到这里，说明单个抵押物无法一次偿还当前的repayAmount
+		repayAmount = new(big.Int).Div(repayAmount, big.NewInt(2))
 	}
-	// 如果执行到这里，说明单个抵押物无法一次偿还当前的repayAmount
-	repayAmount = repayAmount.Div(repayAmount, big.NewInt(2))
-	goto loop
+	return repayAmount, "", nil
 }
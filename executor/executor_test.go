@@ -0,0 +1,499 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/shopspring/decimal"
+
+	"liquidator/conf"
+	"liquidator/contract"
+	"liquidator/handler"
+	"liquidator/log"
+	"liquidator/opportunity"
+	"liquidator/simchain"
+)
+
+var borrower = common.HexToAddress("0x00000000000000000000000000000000000b0001")
+
+// newSim 借款人抵押 1 pETH 借 1400 USDC，ETH 跌到 1700 后资不抵债；清算人钱包有 10000 USDC
+func newSim(t *testing.T) (*simchain.Chain, simchain.Market, simchain.Market, *contract.Chain) {
+	if err := log.Init(t.TempDir(), "test", "", "DEBUG"); err != nil {
+		t.Fatal(err)
+	}
+	env := simchain.NewEnv(t, "10000", simchain.Position{Borrower: borrower, Supply: "1", Borrow: "1400"})
+	handler.Init()
+
+	if _, err := env.Chain.Approve(env.USDC.PToken.String()); err != nil {
+		t.Fatal(err)
+	}
+	env.Sim.Commit()
+
+	// 抵押物价格下跌：1 pETH * 1700 * 0.75 = 1275 < 1400
+	env.Sim.SetPrice(env.ETH, simchain.Mantissa("1700"))
+	return env.Sim, env.USDC, env.ETH, env.Chain
+}
+
+func TestLiquidateUnderwaterBorrower(t *testing.T) {
+	sim, usdc, eth, _ := newSim(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		Run(ctx)
+		close(stopped)
+	}()
+	// 停止 Run 后下一个测试才能重新初始化 handler
+	t.Cleanup(func() {
+		cancel()
+		<-stopped
+	})
+	handler.TriggerScan()
+
+	var liquidation *Liquidation
+	deadline := time.Now().Add(30 * time.Second)
+	for liquidation == nil && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+		for _, l := range Liquidations() {
+			l := l
+			switch l.Status {
+			case StatusSubmitted:
+				sim.Commit()
+			case StatusConfirmed, StatusFailed:
+				liquidation = &l
+			}
+		}
+	}
+	if liquidation == nil {
+		t.Fatal("no liquidation confirmed")
+	}
+	if liquidation.Status != StatusConfirmed {
+		t.Fatalf("liquidation %s: %s", liquidation.Status, liquidation.Error)
+	}
+
+	// close factor 0.5：偿还 700 USDC，获得 700 * 1.08 / 1700 个 pETH，与 Compound 相同先截断兑换比例再乘以偿还数量
+	repay := simchain.Mantissa("700")
+	ratio := new(big.Int).Div(new(big.Int).Mul(simchain.Mantissa("1.08"), simchain.Mantissa("1")), simchain.Mantissa("1700"))
+	seize := new(big.Int).Div(new(big.Int).Mul(ratio, repay), simchain.Mantissa("1"))
+	if liquidation.Plan.RepayAmount.Cmp(repay) != 0 || liquidation.Plan.Collateral != eth.PToken.String() {
+		t.Fatalf("plan = %+v", liquidation.Plan)
+	}
+
+	pUSDC, _ := contract.NewPtoken(usdc.PToken, sim.Backend)
+	pETH, _ := contract.NewPtoken(eth.PToken, sim.Backend)
+	underlying, _ := contract.NewErc20(usdc.Underlying, sim.Backend)
+	expect := func(name string, got *big.Int, err error, want *big.Int) {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if got.Cmp(want) != 0 {
+			t.Errorf("%s = %s, want %s", name, got, want)
+		}
+	}
+	borrow, err := pUSDC.BorrowBalanceStored(nil, borrower)
+	expect("borrower borrow", borrow, err, simchain.Mantissa("700"))
+	collateral, err := pETH.BalanceOf(nil, borrower)
+	expect("borrower collateral", collateral, err, new(big.Int).Sub(simchain.Mantissa("1"), seize))
+	seized, err := pETH.BalanceOf(nil, sim.Liquidator)
+	expect("liquidator seized", seized, err, seize)
+	balance, err := underlying.BalanceOf(nil, sim.Liquidator)
+	expect("liquidator balance", balance, err, simchain.Mantissa("9300"))
+}
+
+func TestSubmittedBlocksResubmit(t *testing.T) {
+	_, usdc, _, _ := newSim(t)
+	plan, err := PlanFor("sim", borrower.String(), usdc.PToken.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := liquidationKey(plan)
+	liquidationsMu.Lock()
+	liquidations[key] = &Liquidation{Plan: plan, Status: StatusSubmitted, Tx: "0x1", StartedAt: time.Now()}
+	liquidationsMu.Unlock()
+	t.Cleanup(func() {
+		liquidationsMu.Lock()
+		delete(liquidations, key)
+		liquidationsMu.Unlock()
+	})
+	// 回执还没到，下一轮扫描不能再次提交
+	if _, err := Execute(plan); err != ErrAlreadyRunning {
+		t.Fatalf("err = %v, want %s", err, ErrAlreadyRunning)
+	}
+	// 手动提交的计划地址大小写不同也是同一个清算
+	manual := plan
+	manual.Borrower = strings.ToLower(plan.Borrower)
+	manual.RepayMarket = strings.ToLower(plan.RepayMarket)
+	if _, err := Execute(manual); err != ErrAlreadyRunning {
+		t.Fatalf("manual err = %v, want %s", err, ErrAlreadyRunning)
+	}
+}
+
+func TestDryRunRecordsOpportunity(t *testing.T) {
+	sim, usdc, eth, chain := newSim(t)
+	chain.SetDryRun(true)
+
+	plan, err := PlanFor("sim", borrower.String(), usdc.PToken.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.CorrelationID == "" {
+		t.Fatal("plan has no correlation ID")
+	}
+	id, err := Execute(plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var recorded *opportunity.Opportunity
+	deadline := time.Now().Add(10 * time.Second)
+	for recorded == nil && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+		for _, o := range opportunity.Recent() {
+			if o.ID == id && o.CorrelationID == plan.CorrelationID {
+				o := o
+				recorded = &o
+			}
+		}
+	}
+	if recorded == nil {
+		t.Fatalf("opportunity %s not recorded", id)
+	}
+	if !recorded.Success || recorded.Gas == 0 || recorded.Collateral != eth.PToken.String() || recorded.CorrelationID != plan.CorrelationID {
+		t.Fatalf("opportunity = %+v", recorded)
+	}
+	// 扣押 700 * 1.08 / 1700 个 pETH，价值 756 美元，偿还 700 美元；模拟链没有原生币市场，不计 gas
+	if !recorded.Profit.Round(6).Equal(decimal.NewFromInt(56)) {
+		t.Errorf("profit = %s, want 56", recorded.Profit)
+	}
+
+	// 没有发送交易，链上仓位不变
+	pUSDC, _ := contract.NewPtoken(usdc.PToken, sim.Backend)
+	borrow, err := pUSDC.BorrowBalanceStored(nil, borrower)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if borrow.Cmp(simchain.Mantissa("1400")) != 0 {
+		t.Errorf("borrow = %s, want 1400", borrow)
+	}
+}
+
+// waitSimulated 等待计划 plan 的 dryRun 清算 id 读取模拟结果。每个测试的模拟链都从头编号，id 需要和 correlation ID 一起匹配
+func waitSimulated(t *testing.T, plan Plan, id string) Liquidation {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, l := range Liquidations() {
+			if l.Tx == id && l.Plan.CorrelationID == plan.CorrelationID && !l.pending() {
+				return l
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("liquidation %s not simulated", id)
+	return Liquidation{}
+}
+
+func TestDryRunAllowanceMissing(t *testing.T) {
+	if err := log.Init(t.TempDir(), "test", "", "DEBUG"); err != nil {
+		t.Fatal(err)
+	}
+	// 钱包有余额但没有授权 pUSDC
+	env := simchain.NewEnv(t, "10000", simchain.Position{Borrower: borrower, Supply: "1", Borrow: "1400"})
+	handler.Init()
+	env.Sim.SetPrice(env.ETH, simchain.Mantissa("1700"))
+	env.Chain.SetDryRun(true)
+
+	plan, err := PlanFor("sim", borrower.String(), env.USDC.PToken.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := func() []opportunity.Opportunity {
+		var result []opportunity.Opportunity
+		for _, o := range opportunity.Recent() {
+			if o.CorrelationID == plan.CorrelationID {
+				result = append(result, o)
+			}
+		}
+		return result
+	}
+	for i := 0; i < 2; i++ {
+		id, err := Execute(plan)
+		if err != nil {
+			t.Fatal(err)
+		}
+		l := waitSimulated(t, plan, id)
+		if l.Status != StatusAllowanceMissing {
+			t.Fatalf("execution %d: status = %s, want %s", i, l.Status, StatusAllowanceMissing)
+		}
+	}
+	// 同一区块的第二次模拟与第一次重复，只保存一条
+	got := records()
+	if len(got) != 1 {
+		t.Fatalf("recorded %d opportunities, want 1: %+v", len(got), got)
+	}
+	if !got[0].AllowanceMissing || got[0].Success {
+		t.Fatalf("opportunity = %+v", got[0])
+	}
+}
+
+// setStrategy 修改市场策略，测试结束后恢复
+func setStrategy(t *testing.T) func(conf.Strategies) {
+	saved := conf.Config.Strategy
+	t.Cleanup(func() { conf.Config.Strategy = saved })
+	return func(strategy conf.Strategies) { conf.Config.Strategy = strategy }
+}
+
+func expectReject(t *testing.T, err error, reason RejectReason) {
+	t.Helper()
+	reject, ok := err.(*RejectError)
+	if !ok || reject.Reason != reason {
+		t.Fatalf("err = %v, want %s", err, reason)
+	}
+	rejections := Rejections()
+	if last := rejections[len(rejections)-1]; last.Reason != reason {
+		t.Errorf("last rejection = %+v", last)
+	}
+}
+
+func TestStrategyRules(t *testing.T) {
+	_, usdc, eth, _ := newSim(t)
+	disabled := false
+	set := setStrategy(t)
+	plan := func(strategy conf.Strategies) (Plan, error) {
+		set(strategy)
+		return PlanFor("sim", borrower.String(), usdc.PToken.String())
+	}
+	expectReject := func(err error, reason RejectReason) {
+		t.Helper()
+		expectReject(t, err, reason)
+	}
+
+	// viper 读出的键是小写，按 symbol 匹配时不区分大小写
+	_, err := plan(conf.Strategies{Markets: map[string]conf.Strategy{"pusdc": {Enabled: &disabled}}})
+	expectReject(err, ReasonMarketDisabled)
+
+	_, err = plan(conf.Strategies{Markets: map[string]conf.Strategy{eth.PToken.String(): {Enabled: &disabled}}})
+	if err != ErrNoCollateral {
+		t.Fatalf("err = %v, want %s", err, ErrNoCollateral)
+	}
+	rejections := Rejections()
+	if last := rejections[len(rejections)-1]; last.Reason != ReasonCollateralDisabled || last.Collateral != eth.PToken.String() {
+		t.Errorf("last rejection = %+v", last)
+	}
+
+	_, err = plan(conf.Strategies{Default: conf.Strategy{MaxGasPrice: 1e-9}})
+	expectReject(err, ReasonGasPriceCeiling)
+
+	// 偿还 700 USDC 预计收益 56 美元
+	_, err = plan(conf.Strategies{Default: conf.Strategy{MinProfit: 100}})
+	expectReject(err, ReasonMinProfit)
+
+	p, err := plan(conf.Strategies{Default: conf.Strategy{MinProfit: 20, MaxRepay: 350}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.RepayAmount.Cmp(simchain.Mantissa("350")) != 0 || p.Collateral != eth.PToken.String() {
+		t.Errorf("plan = %+v", p)
+	}
+}
+
+// Check 对计划执行与 makePlan 相同的市场检查和策略，手动提交的计划不能绕过
+func TestCheckAppliesPlanRules(t *testing.T) {
+	_, usdc, _, _ := newSim(t)
+	plan, err := PlanFor("sim", borrower.String(), usdc.PToken.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Check(plan); err != nil {
+		t.Fatalf("check = %v", err)
+	}
+	disabled := false
+	set := setStrategy(t)
+	for _, c := range []struct {
+		strategy conf.Strategies
+		reason   RejectReason
+	}{
+		{conf.Strategies{Markets: map[string]conf.Strategy{"pusdc": {Enabled: &disabled}}}, ReasonMarketDisabled},
+		{conf.Strategies{Markets: map[string]conf.Strategy{"peth": {Enabled: &disabled}}}, ReasonCollateralDisabled},
+		{conf.Strategies{Default: conf.Strategy{MaxGasPrice: 1e-9}}, ReasonGasPriceCeiling},
+		{conf.Strategies{Default: conf.Strategy{MaxRepay: 350}}, ReasonMaxRepay},
+		{conf.Strategies{Default: conf.Strategy{MinProfit: 100}}, ReasonMinProfit},
+	} {
+		set(c.strategy)
+		expectReject(t, Check(plan), c.reason)
+	}
+	set(conf.Strategies{})
+
+	// 借款人没有存入 pUSDC
+	manual := plan
+	manual.Collateral = usdc.PToken.String()
+	expectReject(t, Check(manual), ReasonNoCollateralBalance)
+}
+
+// 借款人同时抵押 1 pETH 和 2000 pUSDC，两者都能覆盖偿还 700 USDC 的扣押数量，按偿还市场的 collaterals 选择
+func TestStrategyCollateralRank(t *testing.T) {
+	env := simchain.NewEnv(t, "10000", simchain.Position{Borrower: borrower, Supply: "1", SupplyUSDC: "2000", Borrow: "1400"})
+	set := setStrategy(t)
+	for _, preferred := range []simchain.Market{env.ETH, env.USDC, env.ETH} {
+		set(conf.Strategies{Markets: map[string]conf.Strategy{"pusdc": {Collaterals: []string{preferred.Symbol}}}})
+		plan, err := PlanFor("sim", borrower.String(), env.USDC.PToken.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if plan.Collateral != preferred.PToken.String() || plan.RepayAmount.Cmp(simchain.Mantissa("700")) != 0 {
+			t.Errorf("prefer %s: plan = %+v", preferred.Symbol, plan)
+		}
+	}
+}
+
+// 不允许闪电贷资金时偿还数量不超过钱包余额，钱包没有余额时放弃，与 Check 一致；允许时按 close factor 计算
+func TestFlashFunding(t *testing.T) {
+	position := simchain.Position{Borrower: borrower, Supply: "1", Borrow: "1400"}
+	env := simchain.NewEnv(t, "300", position)
+	plan, err := PlanFor("sim", borrower.String(), env.USDC.PToken.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.RepayAmount.Cmp(simchain.Mantissa("300")) != 0 || plan.Collateral != env.ETH.PToken.String() {
+		t.Fatalf("plan = %+v", plan)
+	}
+	if _, err := env.Chain.Approve(env.USDC.PToken.String()); err != nil {
+		t.Fatal(err)
+	}
+	env.Sim.Commit()
+	env.Sim.SetPrice(env.ETH, simchain.Mantissa("1700"))
+	if err := Check(plan); err != nil {
+		t.Errorf("check = %v", err)
+	}
+
+	// 本程序没有闪电贷执行合约，允许闪电贷资金时计划不受余额限制，提交前仍要求钱包有足够余额
+	allowed := true
+	set := setStrategy(t)
+	set(conf.Strategies{Markets: map[string]conf.Strategy{"pusdc": {FlashFunding: &allowed}}})
+	plan, err = PlanFor("sim", borrower.String(), env.USDC.PToken.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.RepayAmount.Cmp(simchain.Mantissa("700")) != 0 {
+		t.Fatalf("plan = %+v", plan)
+	}
+	if err := Check(plan); !errors.Is(err, ErrWalletBalance) {
+		t.Errorf("check = %v, want %s", err, ErrWalletBalance)
+	}
+
+	set(conf.Strategies{})
+	env = simchain.NewEnv(t, "", position)
+	_, err = PlanFor("sim", borrower.String(), env.USDC.PToken.String())
+	expectReject(t, err, ReasonFlashFundingDisallowed)
+}
+
+// 原生币市场的 liquidateBorrow 是 payable 的，Chain.LiquidateBorrow 只支持 ERC20 市场，偿还原生币市场在计划和检查阶段都被拒绝
+func TestNativeRepayMarketRejected(t *testing.T) {
+	if err := log.Init(t.TempDir(), "test", "", "DEBUG"); err != nil {
+		t.Fatal(err)
+	}
+	// 借款人抵押 1000 pUSDC 借 3 BNB，BNB 涨到 300 后 900 > 1000 * 0.8
+	sim := simchain.New(t)
+	usdc := sim.AddMarket("pUSDC", simchain.Mantissa("1"), simchain.Mantissa("0.8"))
+	bnb := sim.AddNativeMarket("pBNB", simchain.Mantissa("250"), simchain.Mantissa("0.6"))
+	sim.Supply(borrower, usdc, simchain.Mantissa("1000"))
+	sim.Borrow(borrower, bnb, simchain.Mantissa("3"))
+	sim.SetPrice(bnb, simchain.Mantissa("300"))
+	cfg := sim.Config("sim")
+	conf.Config = conf.ConfigStruct{Chains: []conf.Chain{cfg}}
+	chain, err := contract.NewChainWithBackend(cfg, sim.Backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contract.SetChains(chain)
+
+	_, err = PlanFor("sim", borrower.String(), bnb.PToken.String())
+	expectReject(t, err, ReasonNativeRepayMarket)
+
+	plan := Plan{Protocol: "sim", Borrower: borrower.String(), RepayMarket: bnb.PToken.String(), Collateral: usdc.PToken.String(), RepayAmount: simchain.Mantissa("1")}
+	var reject *RejectError
+	if _, err := Execute(plan); !errors.As(err, &reject) || reject.Reason != ReasonNativeRepayMarket {
+		t.Fatalf("execute = %v, want %s", err, ReasonNativeRepayMarket)
+	}
+}
+
+// worker 没有取出时放入不阻塞；队列按放入顺序取出，同一个借款人和市场只保留最新的 token，close 之后取完剩下的账户
+func TestChainQueue(t *testing.T) {
+	token := func(account, cid string) handler.AccountToken {
+		token := handler.AccountToken{Protocol: "sim", CorrelationID: cid}
+		token.Market.Id = "0xMarket"
+		token.Account.Id = account
+		return token
+	}
+	q := newChainQueue()
+	popped := make(chan string)
+	go func() {
+		token, _ := q.pop()
+		popped <- token.CorrelationID
+	}()
+	q.push(token("0xA", "1"))
+	if cid := <-popped; cid != "1" {
+		t.Fatalf("popped %s, want 1", cid)
+	}
+
+	q.push(token("0xA", "2"))
+	q.push(token("0xB", "3"))
+	q.push(token("0xa", "4"))
+	q.close()
+	var got []string
+	for {
+		token, ok := q.pop()
+		if !ok {
+			break
+		}
+		got = append(got, token.CorrelationID)
+	}
+	if strings.Join(got, ",") != "4,3" {
+		t.Errorf("popped %v, want [4 3]", got)
+	}
+}
+
+func TestRetryDeferredOnlyLiftedAction(t *testing.T) {
+	newSim(t)
+	p, err := contract.GetProtocol("sim")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seize := handler.AccountToken{Protocol: "sim"}
+	seize.Account.Id = "0x1"
+	transfer := handler.AccountToken{Protocol: "sim"}
+	transfer.Account.Id = "0x2"
+	deferToken(seize, &PauseError{Action: "Seize"})
+	deferToken(transfer, &PauseError{Action: "Transfer"})
+	t.Cleanup(func() {
+		deferredMu.Lock()
+		deferred = make(map[string]Deferred)
+		deferredMu.Unlock()
+	})
+
+	// 市场级 action 和其他 action 解除暂停都不影响被 seize 暂停推迟的清算
+	retryDeferred(p, "Mint", "0x3")
+	retryDeferred(p, "Transfer", "")
+	left := DeferredLiquidations()
+	if len(left) != 1 || left[0].Action != "Seize" || left[0].Token.Account.Id != "0x1" {
+		t.Fatalf("deferred = %+v", left)
+	}
+}
+
+func TestLiquidationPendingAt(t *testing.T) {
+	start := time.Now()
+	l := Liquidation{StartedAt: start}
+	if l.PendingAt(start.Add(-time.Second)) || !l.PendingAt(start.Add(time.Hour)) {
+		t.Error("unfinished liquidation should be pending only after it started")
+	}
+	l.FinishedAt = start.Add(time.Minute)
+	if !l.PendingAt(start.Add(30*time.Second)) || l.PendingAt(start.Add(2*time.Minute)) {
+		t.Error("finished liquidation should not be pending after it finished")
+	}
+}
@@ -0,0 +1,136 @@
+package executor
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"liquidator/contract"
+)
+
+// RejectReason 计划阶段拒绝某个市场的原因代码
+type RejectReason string
+
+const (
+	ReasonBorrowNotListed      RejectReason = "borrow_market_not_listed"
+	ReasonComptrollerMismatch  RejectReason = "comptroller_mismatch"
+	ReasonCollateralNotListed  RejectReason = "collateral_not_listed"
+	ReasonZeroCollateralFactor RejectReason = "zero_collateral_factor"
+	ReasonNoCollateralBalance  RejectReason = "no_collateral_balance"
+	ReasonNativeRepayMarket    RejectReason = "native_repay_market"
+
+	// 以下为市场策略（conf.Strategy）拒绝的原因
+	ReasonMarketDisabled         RejectReason = "market_disabled"
+	ReasonCollateralDisabled     RejectReason = "collateral_disabled"
+	ReasonGasPriceCeiling        RejectReason = "gas_price_ceiling"
+	ReasonMaxRepay               RejectReason = "max_repay"
+	ReasonFlashFundingDisallowed RejectReason = "flash_funding_disallowed"
+	ReasonMinProfit              RejectReason = "min_profit"
+)
+
+type RejectError struct {
+	Reason RejectReason
+	Market string
+	Detail string
+}
+
+func (e *RejectError) Error() string {
+	if e.Detail != "" {
+		return fmt.Sprintf("%s rejected: %s (%s)", e.Market, e.Reason, e.Detail)
+	}
+	return fmt.Sprintf("%s rejected: %s", e.Market, e.Reason)
+}
+
+type Rejection struct {
+	Protocol   string
+	Borrower   string
+	Market     string
+	Collateral string
+	Reason     RejectReason
+	Detail     string
+	At         time.Time
+}
+
+const maxRejections = 200
+
+var (
+	rejectionsMu sync.Mutex
+	rejections   []Rejection
+)
+
+func recordRejection(r Rejection) {
+	r.At = time.Now()
+	rejectionsMu.Lock()
+	rejections = append(rejections, r)
+	if len(rejections) > maxRejections {
+		rejections = rejections[len(rejections)-maxRejections:]
+	}
+	rejectionsMu.Unlock()
+}
+
+// Rejections 最近被计划阶段拒绝的市场组合
+func Rejections() []Rejection {
+	rejectionsMu.Lock()
+	defer rejectionsMu.Unlock()
+	result := make([]Rejection, len(rejections))
+	copy(result, rejections)
+	return result
+}
+
+// checkBorrowMarket 偿还市场必须已上架、pToken 绑定的 comptroller 就是当前协议，且不是原生币市场
+func checkBorrowMarket(p *contract.Protocol, snapshot *contract.BorrowerSnapshot) *RejectError {
+	if !snapshot.MarketListed {
+		return &RejectError{Reason: ReasonBorrowNotListed, Market: snapshot.Market}
+	}
+	if !strings.EqualFold(snapshot.MarketComptroller, p.Address()) {
+		return &RejectError{Reason: ReasonComptrollerMismatch, Market: snapshot.Market}
+	}
+	return checkNativeMarket(p, snapshot.Market)
+}
+
+// checkNativeMarket 原生币市场（CEther）的 liquidateBorrow 是 payable 的，以 msg.value 偿还，
+// 而 Chain.LiquidateBorrow 只发送 ERC20 市场的 liquidateBorrow(borrower, repayAmount, collateral)，不支持偿还原生币市场
+func checkNativeMarket(p *contract.Protocol, market string) *RejectError {
+	if m, ok := p.GetMarket(market); ok && m.Underlying == "" {
+		return &RejectError{Reason: ReasonNativeRepayMarket, Market: market}
+	}
+	return nil
+}
+
+// checkCollateral 抵押物市场必须已上架、抵押率大于 0，且借款人确实持有该 pToken
+func checkCollateral(collateral contract.CollateralSnapshot) *RejectError {
+	if !collateral.Listed {
+		return &RejectError{Reason: ReasonCollateralNotListed, Market: collateral.Market}
+	}
+	if collateral.CollateralFactor.Sign() <= 0 {
+		return &RejectError{Reason: ReasonZeroCollateralFactor, Market: collateral.Market}
+	}
+	if collateral.Balance.Sign() <= 0 {
+		return &RejectError{Reason: ReasonNoCollateralBalance, Market: collateral.Market}
+	}
+	return nil
+}
+
+// filterCollaterals 过滤掉不能用于清算的抵押物，同时返回被拒绝的原因
+func filterCollaterals(snapshot *contract.BorrowerSnapshot) ([]contract.CollateralSnapshot, []*RejectError) {
+	result := make([]contract.CollateralSnapshot, 0, len(snapshot.Collaterals))
+	rejects := make([]*RejectError, 0)
+	for _, collateral := range snapshot.Collaterals {
+		if reject := checkCollateral(collateral); reject != nil {
+			rejects = append(rejects, reject)
+			continue
+		}
+		result = append(result, collateral)
+	}
+	return result, rejects
+}
+
+// validCollaterals 过滤掉不能用于清算的抵押物，每个被拒绝的抵押物都会记录原因
+func validCollaterals(p *contract.Protocol, snapshot *contract.BorrowerSnapshot) []contract.CollateralSnapshot {
+	result, rejects := filterCollaterals(snapshot)
+	for _, reject := range rejects {
+		recordRejection(Rejection{Protocol: p.Name, Borrower: snapshot.Borrower, Market: snapshot.Market, Collateral: reject.Market, Reason: reject.Reason})
+	}
+	return result
+}
@@ -0,0 +1,191 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/shopspring/decimal"
+
+	"liquidator/conf"
+	"liquidator/contract"
+	"liquidator/handler"
+)
+
+// liquidationGas 计划阶段估算 gas 成本时使用的 liquidateBorrow gas 用量
+const liquidationGas = 600000
+
+// marketStrategy 市场的清算策略，按 pToken 地址或 symbol 匹配，可热加载
+func marketStrategy(p *contract.Protocol, market string) conf.Strategy {
+	m, _ := p.GetMarket(market)
+	return conf.Get().Strategy.For(market, m.Symbol)
+}
+
+// rejectPlan 检查或策略拒绝时记录原因后原样返回，其他错误是读取链上数据失败。被拒绝的是抵押物时记录在 Collateral 中
+func rejectPlan(p *contract.Protocol, borrower, market string, err error) error {
+	var reject *RejectError
+	if !errors.As(err, &reject) {
+		return checkFailed(err)
+	}
+	r := Rejection{Protocol: p.Name, Borrower: borrower, Market: market, Reason: reject.Reason, Detail: reject.Detail}
+	if !strings.EqualFold(reject.Market, market) {
+		r.Collateral = reject.Market
+	}
+	recordRejection(r)
+	return reject
+}
+
+// checkPlan 在最新区块上对计划执行与 makePlan 相同的检查：偿还市场和抵押物的上架状态、抵押率和余额，
+// 以及市场策略的启用、gas 单价上限、maxRepay 和 minProfit，手动提交的计划不能绕过
+func checkPlan(p *contract.Protocol, plan Plan) error {
+	snapshot, err := p.LoadBorrower(context.Background(), plan.Borrower, plan.RepayMarket)
+	if err != nil {
+		return err
+	}
+	if reject := checkBorrowMarket(p, snapshot); reject != nil {
+		return reject
+	}
+	if reject := checkPlanCollateral(snapshot, plan.Collateral); reject != nil {
+		return reject
+	}
+	strategy := marketStrategy(p, plan.RepayMarket)
+	if err := checkStrategy(p, strategy, plan.RepayMarket); err != nil {
+		return err
+	}
+	if !marketStrategy(p, plan.Collateral).IsEnabled() {
+		return &RejectError{Reason: ReasonCollateralDisabled, Market: plan.Collateral}
+	}
+	limit, err := maxRepayLimit(p, strategy, plan.RepayMarket)
+	if err != nil {
+		return err
+	}
+	if limit != nil && plan.RepayAmount.Cmp(limit) > 0 {
+		detail := fmt.Sprintf("repay amount %s above %s by maxRepay %v", plan.RepayAmount, limit, strategy.MaxRepay)
+		return &RejectError{Reason: ReasonMaxRepay, Market: plan.RepayMarket, Detail: detail}
+	}
+	return checkMinProfit(p, p.Params(), strategy, plan.RepayMarket, plan.RepayAmount)
+}
+
+// checkPlanCollateral 计划的抵押物必须在借款人的资产中，并通过 checkCollateral
+func checkPlanCollateral(snapshot *contract.BorrowerSnapshot, market string) *RejectError {
+	for _, collateral := range snapshot.Collaterals {
+		if strings.EqualFold(collateral.Market, market) {
+			return checkCollateral(collateral)
+		}
+	}
+	return &RejectError{Reason: ReasonNoCollateralBalance, Market: market, Detail: "not in borrower's assets"}
+}
+
+// checkStrategy 偿还市场未启用或当前 gas 单价超过上限时拒绝
+func checkStrategy(p *contract.Protocol, strategy conf.Strategy, market string) error {
+	if !strategy.IsEnabled() {
+		return &RejectError{Reason: ReasonMarketDisabled, Market: market}
+	}
+	if strategy.MaxGasPrice <= 0 {
+		return nil
+	}
+	gasPrice, err := p.Chain.GasPrice()
+	if err != nil {
+		return err
+	}
+	gwei := decimal.NewFromBigInt(gasPrice, -9)
+	if gwei.GreaterThan(decimal.NewFromFloat(strategy.MaxGasPrice)) {
+		detail := fmt.Sprintf("gas price %s gwei above %v gwei", gwei, strategy.MaxGasPrice)
+		return &RejectError{Reason: ReasonGasPriceCeiling, Market: market, Detail: detail}
+	}
+	return nil
+}
+
+// maxRepayLimit maxRepay 按预言机价格换算成偿还市场底层资产的数量，未配置或没有价格时返回 nil
+func maxRepayLimit(p *contract.Protocol, strategy conf.Strategy, market string) (*big.Int, error) {
+	if strategy.MaxRepay <= 0 {
+		return nil, nil
+	}
+	price, err := p.GetUnderlyingPrice(market)
+	if err != nil {
+		return nil, err
+	}
+	if price.Sign() <= 0 {
+		return nil, nil
+	}
+	return decimal.NewFromFloat(strategy.MaxRepay).Shift(36).Div(decimal.NewFromBigInt(price, 0)).BigInt(), nil
+}
+
+// limitRepayAmount 偿还价值不超过 maxRepay；不允许闪电贷资金时偿还数量不超过钱包余额，钱包不足以偿还时告警，没有余额时放弃。
+// 允许时保持按 close factor 计算的数量，提交前 Check 仍要求钱包有足够余额；dryRun 模式下 Execute 不要求余额，这里也不限制
+func limitRepayAmount(token handler.AccountToken, p *contract.Protocol, strategy conf.Strategy, repayAmount *big.Int) (*big.Int, error) {
+	market := token.Market.Id
+	limit, err := maxRepayLimit(p, strategy, market)
+	if err != nil {
+		return nil, err
+	}
+	if limit != nil && limit.Cmp(repayAmount) < 0 {
+		token.Logger().With("rule", "max_repay").Debug("repay amount %s capped to %s by maxRepay %v", repayAmount, limit, strategy.MaxRepay)
+		repayAmount = limit
+	}
+	if strategy.AllowFlashFunding() || p.Chain.DryRun() {
+		return repayAmount, nil
+	}
+	balance, err := p.GetWalletRepayBalance(market)
+	if err != nil {
+		return nil, err
+	}
+	if balance.Cmp(repayAmount) >= 0 {
+		return repayAmount, nil
+	}
+	short := fmt.Errorf("%w: have %s, need %s", ErrWalletBalance, balance, repayAmount)
+	plan := Plan{Protocol: p.Name, Borrower: token.Account.Id, RepayMarket: market, RepayAmount: repayAmount, CorrelationID: token.CorrelationID}
+	alertWalletBalance(p, plan, short)
+	if balance.Sign() <= 0 {
+		return nil, &RejectError{Reason: ReasonFlashFundingDisallowed, Market: market, Detail: short.Error()}
+	}
+	token.Logger().With("rule", "flash_funding").Debug("repay amount %s capped to wallet balance %s", repayAmount, balance)
+	return balance, nil
+}
+
+// strategyCollaterals 去掉策略未启用的抵押物市场并记录原因，再按偿还市场的 collaterals 排序
+func strategyCollaterals(p *contract.Protocol, strategy conf.Strategy, snapshot *contract.BorrowerSnapshot, collaterals []contract.CollateralSnapshot) []contract.CollateralSnapshot {
+	result := make([]contract.CollateralSnapshot, 0, len(collaterals))
+	rank := make(map[string]int, len(collaterals))
+	for _, collateral := range collaterals {
+		m, _ := p.GetMarket(collateral.Market)
+		if !conf.Get().Strategy.For(collateral.Market, m.Symbol).IsEnabled() {
+			recordRejection(Rejection{Protocol: p.Name, Borrower: snapshot.Borrower, Market: snapshot.Market, Collateral: collateral.Market, Reason: ReasonCollateralDisabled})
+			continue
+		}
+		rank[collateral.Market] = strategy.CollateralRank(collateral.Market, m.Symbol)
+		result = append(result, collateral)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return rank[result[i].Market] < rank[result[j].Market]
+	})
+	return result
+}
+
+// checkMinProfit 预计收益 = 偿还价值 × (清算激励 - 1) - gas 成本，低于 minProfit 时拒绝
+func checkMinProfit(p *contract.Protocol, params contract.Params, strategy conf.Strategy, market string, repayAmount *big.Int) error {
+	if strategy.MinProfit <= 0 {
+		return nil
+	}
+	price, err := p.GetUnderlyingPrice(market)
+	if err != nil {
+		return err
+	}
+	gasPrice, err := p.Chain.GasPrice()
+	if err != nil {
+		return err
+	}
+	cost, err := gasCost(p, liquidationGas, gasPrice)
+	if err != nil {
+		return err
+	}
+	profit := usdValue(repayAmount, price).Mul(params.LiquidationIncentive.Sub(decimal.NewFromInt(1))).Sub(cost)
+	if profit.LessThan(decimal.NewFromFloat(strategy.MinProfit)) {
+		detail := fmt.Sprintf("estimated profit %s below %v", profit.StringFixed(2), strategy.MinProfit)
+		return &RejectError{Reason: ReasonMinProfit, Market: market, Detail: detail}
+	}
+	return nil
+}
@@ -3,10 +3,8 @@ module liquidator
 go 1.16
 
 require (
-	github.com/ethereum/go-ethereum v1.10.3
+	github.com/ethereum/go-ethereum v1.10.26
 	github.com/fsnotify/fsnotify v1.4.9
-	github.com/machinebox/graphql v0.2.2
-	github.com/matryer/is v1.4.0 // indirect
 	github.com/robfig/cron v1.2.0
 	github.com/shopspring/decimal v1.2.0
 	github.com/spf13/viper v1.8.0
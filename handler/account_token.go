@@ -2,65 +2,32 @@ package handler
 
 import (
 	"liquidator/log"
-	"liquidator/utils"
-
-	"github.com/machinebox/graphql"
-	"github.com/shopspring/decimal"
+	"liquidator/subgraph"
 )
 
-type Account struct {
-	Id string
-}
+type Account = subgraph.Account
 
+// AccountToken subgraph 返回的借款记录，附带所属的协议；
+// CorrelationID 在扫描时生成，之后计划、提交和回执的日志都带上同一个 ID
 type AccountToken struct {
-	Id                  string
-	Symbol              string
-	AccrualBlockNumber  string
-	PTokenBalance       decimal.Decimal
-	StoredBorrowBalance decimal.Decimal
-	Market              Market
-	Account             Account
+	subgraph.AccountToken
+	Protocol      string
+	CorrelationID string `json:",omitempty"`
 }
 
-type QueryAccountTokensResp struct {
-	AccountPTokens []AccountToken
+// Logger 返回带有 correlation ID、协议、市场和借款人字段的日志
+func (t AccountToken) Logger() *log.Logger {
+	return log.With("cid", t.CorrelationID, "protocol", t.Protocol, "market", t.Market.Id, "borrower", t.Account.Id)
 }
 
-func queryAccountTokens(symbol string, lastBlockNumber uint) []AccountToken {
-	req := graphql.NewRequest(`
-	query ($symbol: String!, $lastBlockNumber: BigInt) {
-		accountPTokens(first: 1000, orderBy: accrualBlockNumber, 
-			where: {accrualBlockNumber_gt: $lastBlockNumber, storedBorrowBalance_gt: 0, symbol: $symbol}) {
-			id
-			symbol
-			pTokenBalance
-			accrualBlockNumber
-			storedBorrowBalance
-			market {
-				id
-				underlyingAddress
-				underlyingSymbol
-			}
-			account {
-				id
-			}
-		}
+func (h *protocolHandler) queryAccountTokens(symbol string) ([]AccountToken, error) {
+	tokens, err := h.client.AccountTokens(ctx, symbol)
+	if err != nil {
+		return nil, err
 	}
-	`)
-	req.Var("symbol", symbol)
-	req.Var("lastBlockNumber", lastBlockNumber)
-
-	var respData QueryAccountTokensResp
-	if err := client.Run(ctx, req, &respData); err != nil {
-		log.Print(err)
+	result := make([]AccountToken, 0, len(tokens))
+	for _, token := range tokens {
+		result = append(result, AccountToken{AccountToken: token, Protocol: h.protocol.Name})
 	}
-	tokens := respData.AccountPTokens
-	tokensLen := len(tokens)
-	if tokensLen == 1000 {
-		blockNumber := utils.String2Uint(tokens[tokensLen-1].AccrualBlockNumber)
-		tokens2 := queryAccountTokens(symbol, blockNumber)
-		tokens = append(tokens, tokens2...)
-	}
-
-	return tokens
+	return result, nil
 }
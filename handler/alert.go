@@ -0,0 +1,50 @@
+package handler
+
+import (
+	"fmt"
+
+	"github.com/shopspring/decimal"
+
+	"liquidator/alert"
+	"liquidator/conf"
+	"liquidator/contract"
+	"liquidator/log"
+)
+
+// subgraphDown subgraph 查询失败或没有健康的地址时告警，错误详情只写日志，避免把带 API key 的地址发出去
+func (h *protocolHandler) subgraphDown(reason string) {
+	name := h.protocol.Name
+	alert.Send(alert.Event{
+		Type:    alert.SubgraphDown,
+		Key:     name,
+		Title:   fmt.Sprintf("[%s] subgraph unavailable", name),
+		Message: reason,
+		Fields:  map[string]string{"protocol": name},
+	})
+}
+
+// checkWalletBalances 钱包原生币余额低于 alerts.minGasBalance 时告警，余额不足将无法支付清算的 gas
+func checkWalletBalances() {
+	min := decimal.NewFromFloat(conf.Get().Alerts.MinGasBalance)
+	if !min.IsPositive() {
+		return
+	}
+	for _, chain := range contract.Chains() {
+		balance, err := chain.GetWalletNativeBalance()
+		if err != nil {
+			log.Warn("[%s] get wallet balance error: %s", chain.Name, err)
+			continue
+		}
+		value := decimal.NewFromBigInt(balance, -18)
+		if value.GreaterThanOrEqual(min) {
+			continue
+		}
+		alert.Send(alert.Event{
+			Type:    alert.LowBalance,
+			Key:     chain.Name + ":native",
+			Title:   fmt.Sprintf("[%s] wallet gas balance low", chain.Name),
+			Message: fmt.Sprintf("balance %s below %s", value.StringFixed(4), min),
+			Fields:  map[string]string{"chain": chain.Name, "wallet": chain.WalletAddress(), "balance": value.String()},
+		})
+	}
+}
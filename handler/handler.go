@@ -4,45 +4,167 @@ import (
 	"liquidator/conf"
 	"liquidator/contract"
 	"liquidator/log"
+	"liquidator/risk"
+	"liquidator/subgraph"
+	"liquidator/utils"
 
 	"context"
+	"sync"
 
-	"github.com/machinebox/graphql"
 	"github.com/robfig/cron"
 )
 
+// protocolHandler 每个 comptroller 独立的 subgraph、市场列表、借款人和观察列表
+type protocolHandler struct {
+	protocol *contract.Protocol
+	client   *subgraph.Pool
+
+	borrowersMu sync.RWMutex
+	borrowers   map[string]string
+
+	watchlist     *risk.Watchlist
+	watchedMu     sync.Mutex
+	watchedTokens map[string][]AccountToken
+}
+
 var (
 	ctx       context.Context
-	client    *graphql.Client
+	protocols []*protocolHandler
 	TokenChan chan AccountToken
 )
 
-func Start() {
+func Init() {
 	ctx = context.Background()
-	client = graphql.NewClient(conf.Config.Subgraph)
 	TokenChan = make(chan AccountToken, 1000)
+	protocols = nil
+	for _, chain := range conf.Config.ChainConfigs() {
+		for _, c := range chain.Protocols {
+			p, err := contract.GetProtocol(c.Name)
+			if err != nil {
+				log.Printf("[%s] %s", c.Name, err)
+				continue
+			}
+			protocols = append(protocols, newProtocolHandler(p, c.Subgraphs))
+		}
+	}
+}
 
-	queryMarkets()
+func newProtocolHandler(p *contract.Protocol, sources []conf.SubgraphSource) *protocolHandler {
+	client := subgraph.NewPool()
+	for _, source := range sources {
+		client.Add(subgraph.NewClient(source.Url, subgraph.Options{
+			Timeout:     conf.Config.SubgraphClient.Timeout,
+			Retries:     conf.Config.SubgraphClient.Retries,
+			Backoff:     conf.Config.SubgraphClient.Backoff,
+			MaxBlockLag: conf.Config.SubgraphClient.MaxBlockLag,
+			Head:        p.Chain.GetBlockNumber,
+		}), source.Priority)
+	}
+	return &protocolHandler{
+		protocol:      p,
+		client:        client,
+		borrowers:     make(map[string]string),
+		watchlist:     risk.NewWatchlist(conf.Config.Watchlist.Margin),
+		watchedTokens: make(map[string][]AccountToken),
+	}
+}
+
+func getProtocolHandler(name string) *protocolHandler {
+	for _, h := range protocols {
+		if name == "" || h.protocol.Name == name {
+			return h
+		}
+	}
+	return nil
+}
+
+func Start() {
+	Init()
+
+	for _, h := range protocols {
+		h.pollEvents()
+	}
 	approveAll()
 	startCron()
 }
 
 func approveAll() {
-	for _, market := range markets {
-		contract.Approve(market.Id)
+	for _, h := range protocols {
+		for _, market := range h.Markets() {
+			h.protocol.Chain.Approve(market.Address)
+		}
 	}
 }
 
+// forEachProtocol 每个协议单独 recover，一个协议 panic 不影响其他协议
+func forEachProtocol(fn func(h *protocolHandler)) func() {
+	return func() {
+		for _, h := range protocols {
+			func() {
+				defer utils.Recover(h.protocol.Name)
+				fn(h)
+			}()
+		}
+	}
+}
+
+func chainProtocols(chain *contract.Chain) []*protocolHandler {
+	result := make([]*protocolHandler, 0)
+	for _, h := range protocols {
+		if h.protocol.Chain == chain {
+			result = append(result, h)
+		}
+	}
+	return result
+}
+
 func startCron() {
 	c := cron.New()
-	c.AddFunc("* 0/1 * * * ?", queryMarkets)
-	c.AddFunc("0/30 * * * * ?", taskRun)
+	c.AddFunc("0 0/10 * * * ?", forEachProtocol((*protocolHandler).refreshMarkets))
+	c.AddFunc("0/15 * * * * ?", forEachProtocol((*protocolHandler).pollEvents))
+	c.AddFunc("0 0/5 * * * ?", forEachProtocol((*protocolHandler).refreshParams))
+	// 配置了出块时间的链按出块时间扫描，否则每 30 秒扫描一次
+	for _, chain := range contract.Chains() {
+		spec := "0/30 * * * * ?"
+		if chain.BlockTime > 0 {
+			spec = "@every " + chain.BlockTime.String()
+		}
+		handlers := chainProtocols(chain)
+		c.AddFunc(spec, func() { scan(handlers) })
+	}
+	if conf.Config.SubgraphClient.HealthInterval != "" {
+		c.AddFunc(conf.Config.SubgraphClient.HealthInterval, forEachProtocol((*protocolHandler).checkSubgraphs))
+	}
+	if conf.Config.SubgraphClient.CrossCheck.Interval != "" {
+		c.AddFunc(conf.Config.SubgraphClient.CrossCheck.Interval, forEachProtocol((*protocolHandler).crossCheckSubgraphs))
+	}
+	if conf.Config.Watchlist.Interval != "" {
+		c.AddFunc(conf.Config.Watchlist.Interval, forEachProtocol((*protocolHandler).refreshWatchlist))
+	}
+	if conf.Config.Alerts.BalanceInterval != "" {
+		c.AddFunc(conf.Config.Alerts.BalanceInterval, checkWalletBalances)
+	}
+	// scenario.shocks 可以热加载，启动时没有配置也注册定时任务，运行时通过 conf.Get() 读取
+	if conf.Config.Scenario.Interval != "" {
+		c.AddFunc(conf.Config.Scenario.Interval, forEachProtocol((*protocolHandler).scenarioReport))
+	}
 	c.Start()
 }
 
+func TriggerScan() {
+	go taskRun()
+}
+
 func taskRun() {
+	scan(protocols)
+}
+
+func scan(handlers []*protocolHandler) {
 	log.Print("cron task running")
-	for _, market := range markets {
-		go handleMarket(market.Symbol)
+	for _, h := range handlers {
+		for _, market := range h.Markets() {
+			h, symbol := h, market.Symbol
+			utils.Go(h.protocol.Name, func() { h.handleMarket(symbol) })
+		}
 	}
 }
@@ -0,0 +1,173 @@
+package handler
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"liquidator/conf"
+	"liquidator/log"
+	"liquidator/risk"
+	"liquidator/simchain"
+	"liquidator/subgraph/subgraphtest"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "handler-test")
+	if err != nil {
+		panic(err)
+	}
+	if err := log.Init(dir, "test", "", "DEBUG"); err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+type fixture struct {
+	sim    *simchain.Chain
+	server *subgraphtest.Server
+	usdc   simchain.Market
+	eth    simchain.Market
+	h      *protocolHandler
+}
+
+var (
+	underwaterBorrower = common.HexToAddress("0x00000000000000000000000000000000000b0001")
+	nearBorrower       = common.HexToAddress("0x00000000000000000000000000000000000b0002")
+)
+
+// newFixture 模拟链上有两个借款人：一个资不抵债，一个健康度约 1.07
+func newFixture(t *testing.T) *fixture {
+	env := simchain.NewEnv(t, "",
+		simchain.Position{Borrower: underwaterBorrower, Supply: "1", Borrow: "1600"},
+		simchain.Position{Borrower: nearBorrower, Supply: "1", Borrow: "1400"})
+	f := &fixture{sim: env.Sim, server: env.Server, usdc: env.USDC, eth: env.ETH}
+	conf.Config.Watchlist = conf.Watchlist{Margin: 0.2}
+	underwaterMu.Lock()
+	underwater = make(map[string]Underwater)
+	underwaterMu.Unlock()
+	Init()
+	f.h = getProtocolHandler("sim")
+	if f.h == nil {
+		t.Fatal("protocol handler not initialized")
+	}
+	return f
+}
+
+func queuedTokens() []AccountToken {
+	result := make([]AccountToken, 0)
+	for {
+		select {
+		case token := <-TokenChan:
+			queueMu.Lock()
+			delete(queued, tokenKey(token))
+			queueMu.Unlock()
+			result = append(result, token)
+		default:
+			return result
+		}
+	}
+}
+
+func TestHandleMarketEnqueuesUnderwater(t *testing.T) {
+	f := newFixture(t)
+	f.h.handleMarket("pUSDC")
+
+	tokens := queuedTokens()
+	if len(tokens) != 1 || !strings.EqualFold(tokens[0].Account.Id, underwaterBorrower.String()) {
+		t.Fatalf("queued = %+v, want only %s", tokens, underwaterBorrower)
+	}
+	if tokens[0].Protocol != "sim" {
+		t.Errorf("protocol = %s, want sim", tokens[0].Protocol)
+	}
+	watched := f.h.watchlist.Borrowers()
+	if len(watched) != 1 || !strings.EqualFold(watched[0], nearBorrower.String()) {
+		t.Errorf("watchlist = %v, want %s", watched, nearBorrower)
+	}
+	if len(UnderwaterAccounts()) != 1 {
+		t.Errorf("underwater = %+v", UnderwaterAccounts())
+	}
+}
+
+func TestHandleMarketSubgraphErrors(t *testing.T) {
+	f := newFixture(t)
+
+	f.server.SetErrors("indexing_error")
+	f.h.handleMarket("pUSDC")
+	if tokens := queuedTokens(); len(tokens) != 0 {
+		t.Fatalf("queued on partial error: %+v", tokens)
+	}
+
+	f.server.SetErrors()
+	f.server.SetStatus(500)
+	f.h.handleMarket("pUSDC")
+	if tokens := queuedTokens(); len(tokens) != 0 {
+		t.Fatalf("queued on http error: %+v", tokens)
+	}
+	if status := SubgraphStatus()["sim"]; len(status) != 1 || status[0].Healthy {
+		t.Errorf("subgraph status = %+v, want unhealthy", status)
+	}
+
+	f.server.SetStatus(0)
+	f.h.handleMarket("pUSDC")
+	if tokens := queuedTokens(); len(tokens) != 1 {
+		t.Fatalf("queued after recovery = %+v, want 1", tokens)
+	}
+}
+
+func TestWatchlistBecomesLiquidatable(t *testing.T) {
+	f := newFixture(t)
+	f.h.handleMarket("pUSDC")
+	queuedTokens()
+
+	// 价格下跌后观察列表中的账户变为可清算
+	f.sim.SetPrice(f.eth, simchain.Mantissa("1800"))
+	f.h.refreshWatchlist()
+	tokens := queuedTokens()
+	if len(tokens) != 1 || !strings.EqualFold(tokens[0].Account.Id, nearBorrower.String()) {
+		t.Fatalf("queued = %+v, want %s", tokens, nearBorrower)
+	}
+	if len(f.h.watchlist.Borrowers()) != 0 {
+		t.Errorf("watchlist not cleared: %v", f.h.watchlist.Borrowers())
+	}
+}
+
+func TestRefreshMarkets(t *testing.T) {
+	f := newFixture(t)
+	markets := Markets()["sim"]
+	if len(markets) != 2 {
+		t.Fatalf("markets = %+v, want 2", markets)
+	}
+	f.h.refreshMarkets()
+	symbols := make([]string, 0)
+	for _, m := range f.h.Markets() {
+		symbols = append(symbols, m.Symbol)
+	}
+	if strings.Join(symbols, ",") != "pETH,pUSDC" {
+		t.Errorf("symbols = %v", symbols)
+	}
+}
+
+// ETH 下跌 10% 后健康度约 1.07 的借款人变为可清算，当前的链上流动性与仓位在同一区块读取
+func TestScenarioShock(t *testing.T) {
+	f := newFixture(t)
+	shocks, err := risk.ParseShocks("ETH=-10%")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := risk.RunScenario(f.h.protocol, []string{nearBorrower.String()}, shocks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Skipped) != 0 || len(s.Liquidatable) != 1 {
+		t.Fatalf("scenario = %+v", s)
+	}
+	h := s.Liquidatable[0]
+	if h.CurrentShortfall.Sign() != 0 || h.CurrentLiquidity.Cmp(simchain.Mantissa("100")) != 0 || h.Shortfall.Cmp(simchain.Mantissa("50")) != 0 {
+		t.Errorf("current %s/%s, shocked shortfall %s", h.CurrentLiquidity, h.CurrentShortfall, h.Shortfall)
+	}
+}
@@ -1,61 +1,110 @@
 package handler
 
 import (
-	"github.com/machinebox/graphql"
-
 	"liquidator/contract"
 	"liquidator/log"
 )
 
-type Market struct {
-	Id                 string
-	Name               string
-	Symbol             string
-	UnderlyingAddress  string
-	UnderlyingName     string
-	UnderlyingSymbol   string
-	AccrualBlockNumber uint
-	BlockTimestamp     uint
-}
-
-type QueryMarketsResp struct {
-	Markets []Market
-}
-
-var markets []Market
-
-func queryMarkets() {
-	req := graphql.NewRequest(`
-	query {
-		markets(orderBy: accrualBlockNumber, orderDirection: desc) {
-			id
-			name
-			symbol
-			underlyingAddress
-    		underlyingName
-			underlyingSymbol
-			accrualBlockNumber
-			blockTimestamp
-		}
+type Market = contract.Market
+
+// queryMarkets 从 comptroller 重新同步市场列表，失败时保留上一次的市场列表
+func (h *protocolHandler) queryMarkets() error {
+	return h.protocol.SyncMarkets()
+}
+
+func (h *protocolHandler) refreshMarkets() {
+	h.queryMarkets()
+}
+
+// refreshParams 定期读取协议参数，防止漏掉事件
+func (h *protocolHandler) refreshParams() {
+	if err := h.protocol.RefreshParams(); err != nil {
+		log.Printf("[%s] refresh params error: %s", h.protocol.Name, err)
 	}
-	`)
+}
 
-	var respData QueryMarketsResp
-	if err := client.Run(ctx, req, &respData); err != nil {
-		log.Print(err)
+// pollEvents 根据 comptroller 事件增量更新市场列表和协议参数
+func (h *protocolHandler) pollEvents() {
+	if err := h.protocol.PollEvents(ctx); err != nil {
+		log.Printf("[%s] poll comptroller events error: %s", h.protocol.Name, err)
 	}
-	markets = respData.Markets
-	log.Printf("markets: %+v", markets)
 }
 
-func handleMarket(symbol string) {
-	log.Printf("handle market start %s", symbol)
-	tokens := queryAccountTokens(symbol, 0)
-	log.Printf("%s tokens len: %d", symbol, len(tokens))
+func (h *protocolHandler) Markets() []Market {
+	return h.protocol.Markets()
+}
+
+func (h *protocolHandler) handleMarket(symbol string) {
+	name := h.protocol.Name
+	log.Printf("[%s] handle market start %s", name, symbol)
+	tokens, err := h.queryAccountTokens(symbol)
+	if err != nil {
+		log.Printf("[%s] %s query account tokens error: %s", name, symbol, err)
+		h.subgraphDown("query account tokens for " + symbol + " failed")
+		return
+	}
+	log.Printf("[%s] %s tokens len: %d", name, symbol, len(tokens))
 	for _, token := range tokens {
-		log.Printf("Token: %+v", token)
-		if contract.IsHighRisk(token.Account.Id) {
-			TokenChan <- token
+		token.CorrelationID = log.NewCorrelationID()
+		logger := token.Logger()
+		logger.With("symbol", token.Symbol, "borrow", token.StoredBorrowBalance, "pTokens", token.PTokenBalance, "accrualBlock", token.AccrualBlockNumber).
+			Debug("[%s] token", name)
+		h.rememberBorrower(token.Account.Id)
+		shortfall, err := h.protocol.GetShortfall(token.Account.Id)
+		if err != nil {
+			// 无法判断时保留上一次的状态，下一轮扫描重试
+			logger.Warn("[%s] check %s shortfall error: %s", name, token.Account.Id, err)
+			continue
+		}
+		setUnderwater(name, token.Account.Id, token.Market.Id, shortfall)
+		if shortfall.Sign() > 0 {
+			logger.With("shortfall", shortfall).Printf("[%s] %s underwater, enqueue", name, token.Account.Id)
+			enqueue(token)
+		} else {
+			h.watch(token)
 		}
 	}
 }
+
+// ScanOnce 一次性扫描所有协议的所有市场，返回资不抵债的账户，不进入清算队列
+func ScanOnce() ([]Underwater, error) {
+	for _, h := range protocols {
+		if err := h.queryMarkets(); err != nil {
+			return nil, err
+		}
+		for _, market := range h.Markets() {
+			tokens, err := h.queryAccountTokens(market.Symbol)
+			if err != nil {
+				return nil, err
+			}
+			for _, token := range tokens {
+				h.rememberBorrower(token.Account.Id)
+				shortfall, err := h.protocol.GetShortfall(token.Account.Id)
+				if err != nil {
+					log.Warn("[%s] check %s shortfall error: %s", h.protocol.Name, token.Account.Id, err)
+					continue
+				}
+				setUnderwater(h.protocol.Name, token.Account.Id, token.Market.Id, shortfall)
+			}
+		}
+	}
+	return UnderwaterAccounts(), nil
+}
+
+// Markets 按协议名返回市场列表
+func Markets() map[string][]Market {
+	result := make(map[string][]Market, len(protocols))
+	for _, h := range protocols {
+		result[h.protocol.Name] = h.Markets()
+	}
+	return result
+}
+
+// Params 按协议名返回当前的协议参数
+func Params() map[string]contract.Params {
+	result := make(map[string]contract.Params, len(protocols))
+	for _, h := range protocols {
+		result[h.protocol.Name] = h.protocol.Params()
+	}
+	return result
+}
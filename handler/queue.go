@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"context"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Underwater struct {
+	Protocol  string
+	Borrower  string
+	Market    string
+	Shortfall *big.Int
+	// Since 第一次发现该账户资不抵债的时间
+	Since time.Time
+}
+
+var (
+	queueMu sync.Mutex
+	queued  = make(map[string]AccountToken)
+
+	underwaterMu sync.RWMutex
+	underwater   = make(map[string]Underwater)
+)
+
+func tokenKey(token AccountToken) string {
+	return token.Protocol + ":" + token.Market.Id + ":" + token.Account.Id
+}
+
+// enqueue 同一个借款人在同一个市场只排队一次，避免重复清算
+func enqueue(token AccountToken) {
+	key := tokenKey(token)
+	queueMu.Lock()
+	if _, ok := queued[key]; ok {
+		queueMu.Unlock()
+		return
+	}
+	queued[key] = token
+	queueMu.Unlock()
+
+	TokenChan <- token
+}
+
+// Requeue 重新放入清算队列，用于之前因暂停等原因推迟的清算
+func Requeue(token AccountToken) {
+	enqueue(token)
+}
+
+// Dequeue 取出下一个待清算的账户，ctx 结束时返回 false
+func Dequeue(ctx context.Context) (AccountToken, bool) {
+	select {
+	case token := <-TokenChan:
+		queueMu.Lock()
+		delete(queued, tokenKey(token))
+		queueMu.Unlock()
+		return token, true
+	case <-ctx.Done():
+		return AccountToken{}, false
+	}
+}
+
+func Queued() []AccountToken {
+	queueMu.Lock()
+	defer queueMu.Unlock()
+	result := make([]AccountToken, 0, len(queued))
+	for _, token := range queued {
+		result = append(result, token)
+	}
+	return result
+}
+
+func setUnderwater(protocol, borrower, market string, shortfall *big.Int) {
+	key := protocol + ":" + strings.ToLower(borrower)
+	underwaterMu.Lock()
+	defer underwaterMu.Unlock()
+	if shortfall.Sign() > 0 {
+		since := time.Now()
+		if prev, ok := underwater[key]; ok {
+			since = prev.Since
+		}
+		underwater[key] = Underwater{Protocol: protocol, Borrower: borrower, Market: market, Shortfall: shortfall, Since: since}
+	} else {
+		delete(underwater, key)
+	}
+}
+
+func UnderwaterAccounts() []Underwater {
+	underwaterMu.RLock()
+	defer underwaterMu.RUnlock()
+	result := make([]Underwater, 0, len(underwater))
+	for _, account := range underwater {
+		result = append(result, account)
+	}
+	return result
+}
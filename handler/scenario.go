@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"fmt"
+	"strings"
+
+	"liquidator/conf"
+	"liquidator/log"
+	"liquidator/risk"
+)
+
+func (h *protocolHandler) rememberBorrower(borrower string) {
+	h.borrowersMu.Lock()
+	defer h.borrowersMu.Unlock()
+	h.borrowers[strings.ToLower(borrower)] = borrower
+}
+
+// Borrowers 返回扫描中见过的所有借款人
+func (h *protocolHandler) Borrowers() []string {
+	h.borrowersMu.RLock()
+	defer h.borrowersMu.RUnlock()
+	result := make([]string, 0, len(h.borrowers))
+	for _, borrower := range h.borrowers {
+		result = append(result, borrower)
+	}
+	return result
+}
+
+// DiscoverBorrowers 从 subgraph 查询指定协议所有有借款的账户
+func DiscoverBorrowers(protocol string) ([]string, error) {
+	h := getProtocolHandler(protocol)
+	if h == nil {
+		return nil, fmt.Errorf("unknown protocol %q", protocol)
+	}
+	if err := h.queryMarkets(); err != nil {
+		return nil, err
+	}
+	for _, market := range h.Markets() {
+		tokens, err := h.queryAccountTokens(market.Symbol)
+		if err != nil {
+			return nil, err
+		}
+		for _, token := range tokens {
+			h.rememberBorrower(token.Account.Id)
+		}
+	}
+	return h.Borrowers(), nil
+}
+
+func (h *protocolHandler) scenarioReport() {
+	specs := conf.Get().Scenario.Shocks
+	if len(specs) == 0 {
+		return
+	}
+	name := h.protocol.Name
+	accounts := h.Borrowers()
+	for _, spec := range specs {
+		shocks, err := risk.ParseShocks(spec)
+		if err != nil {
+			log.Printf("[%s] scenario %q error: %s", name, spec, err)
+			continue
+		}
+		s, err := risk.RunScenario(h.protocol, accounts, shocks)
+		if err != nil {
+			log.Printf("[%s] scenario %s error: %s", name, shocks, err)
+			continue
+		}
+		log.Printf("[%s] scenario %s: %d of %d borrowers liquidatable, %d skipped", name, shocks, len(s.Liquidatable), len(accounts), len(s.Skipped))
+		for _, m := range s.Markets {
+			if m.Error != "" {
+				log.Printf("[%s] scenario %s: market %s repayable %s, read wallet balance error: %s", name, shocks, m.Symbol, m.Repayable, m.Error)
+				continue
+			}
+			log.Printf("[%s] scenario %s: market %s repayable %s inventory %s needed %s", name, shocks, m.Symbol, m.Repayable, m.Inventory, m.Needed)
+		}
+	}
+}
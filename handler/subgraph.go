@@ -0,0 +1,47 @@
+package handler
+
+import (
+	"liquidator/conf"
+	"liquidator/log"
+	"liquidator/subgraph"
+)
+
+// SubgraphStatus 按协议名返回各 subgraph 地址的状态
+func SubgraphStatus() map[string][]subgraph.SourceStatus {
+	result := make(map[string][]subgraph.SourceStatus, len(protocols))
+	for _, h := range protocols {
+		result[h.protocol.Name] = h.client.Status()
+	}
+	return result
+}
+
+func (h *protocolHandler) checkSubgraphs() {
+	h.client.CheckHealth(ctx)
+	healthy := 0
+	for _, status := range h.client.Status() {
+		if !status.Healthy {
+			log.Warn("[%s] subgraph %s unhealthy: %s", h.protocol.Name, status.URL, status.Error)
+		} else {
+			healthy++
+		}
+	}
+	if healthy == 0 {
+		h.subgraphDown("no healthy subgraph source")
+	}
+}
+
+// crossCheckSubgraphs 抽样对比两个 subgraph 的借款人数据，不一致时告警
+func (h *protocolHandler) crossCheckSubgraphs() {
+	name := h.protocol.Name
+	sample := conf.Get().SubgraphClient.CrossCheck.Sample
+	for _, market := range h.Markets() {
+		mismatches, err := h.client.CrossCheck(ctx, market.Symbol, sample)
+		if err != nil {
+			log.Warn("[%s] subgraph cross check %s error: %s", name, market.Symbol, err)
+			continue
+		}
+		for _, m := range mismatches {
+			log.Warn("[%s] subgraph mismatch %s borrower %s between %s and %s: %s", name, m.Symbol, m.Borrower, m.Primary, m.Backup, m.Reason)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"strings"
+
+	"liquidator/log"
+	"liquidator/risk"
+)
+
+// Watchlist 所有协议中即将可清算的账户
+func Watchlist() []risk.Health {
+	result := make([]risk.Health, 0)
+	for _, h := range protocols {
+		result = append(result, h.watchlist.List()...)
+	}
+	return result
+}
+
+// watch 评估未资不抵债的账户，接近清算线的加入观察列表。已在观察列表中的账户由 refreshWatchlist 按更短的间隔评估，
+// 这里只记下新的借款市场，不再读取仓位
+func (h *protocolHandler) watch(token AccountToken) {
+	key := strings.ToLower(token.Account.Id)
+	h.watchedMu.Lock()
+	if _, ok := h.watchedTokens[key]; ok {
+		h.addWatchedToken(key, token)
+		h.watchedMu.Unlock()
+		return
+	}
+	h.watchedMu.Unlock()
+
+	health, err := risk.Evaluate(h.protocol, token.Account.Id)
+	if err != nil {
+		log.Warn("[%s] evaluate %s error: %s", h.protocol.Name, token.Account.Id, err)
+		return
+	}
+	h.watchedMu.Lock()
+	defer h.watchedMu.Unlock()
+	if !h.watchlist.Update(health) {
+		delete(h.watchedTokens, key)
+		return
+	}
+	h.addWatchedToken(key, token)
+}
+
+// addWatchedToken 调用方持有 watchedMu
+func (h *protocolHandler) addWatchedToken(key string, token AccountToken) {
+	for _, t := range h.watchedTokens[key] {
+		if t.Market.Id == token.Market.Id {
+			return
+		}
+	}
+	h.watchedTokens[key] = append(h.watchedTokens[key], token)
+}
+
+// refreshWatchlist 比全量扫描更频繁地刷新观察列表，账户变为可清算时立即入队
+func (h *protocolHandler) refreshWatchlist() {
+	name := h.protocol.Name
+	borrowers := h.watchlist.Borrowers()
+	log.Printf("[%s] refresh watchlist: %d accounts", name, len(borrowers))
+	for _, borrower := range borrowers {
+		health, err := risk.Evaluate(h.protocol, borrower)
+		if err != nil {
+			log.Warn("[%s] evaluate %s error: %s", name, borrower, err)
+			continue
+		}
+		key := strings.ToLower(borrower)
+		if !health.Liquidatable() {
+			if !h.watchlist.Update(health) {
+				h.watchedMu.Lock()
+				delete(h.watchedTokens, key)
+				h.watchedMu.Unlock()
+			}
+			continue
+		}
+
+		log.Printf("[%s] watchlist account %s became liquidatable, health %s", name, borrower, health.HealthFactor.StringFixed(4))
+		h.watchlist.Remove(borrower)
+		h.watchedMu.Lock()
+		tokens := h.watchedTokens[key]
+		delete(h.watchedTokens, key)
+		h.watchedMu.Unlock()
+		for _, token := range tokens {
+			setUnderwater(name, token.Account.Id, token.Market.Id, health.Shortfall)
+			token.Logger().With("shortfall", health.Shortfall).Printf("[%s] watchlist %s underwater, enqueue", name, borrower)
+			enqueue(token)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package log
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	FORMAT_TEXT = "text"
+	FORMAT_JSON = "json"
+)
+
+var jsonFormat int32
+
+// SetFormat 设置输出格式：text 为原有的单行文本，字段以 key=value 追加在消息后；
+// json 每行一个 JSON 对象，包含 time、level、caller、msg 和所有字段
+func SetFormat(format string) error {
+	switch strings.ToLower(format) {
+	case "", FORMAT_TEXT:
+		atomic.StoreInt32(&jsonFormat, 0)
+	case FORMAT_JSON:
+		atomic.StoreInt32(&jsonFormat, 1)
+	default:
+		return fmt.Errorf("unknown log format %q", format)
+	}
+	return nil
+}
+
+func isJSON() bool {
+	return atomic.LoadInt32(&jsonFormat) == 1
+}
+
+// NewCorrelationID 生成一个随机 ID，用于串联同一个清算机会从发现到回执的所有日志
+func NewCorrelationID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Logger 带固定字段的日志，字段按 key, value 成对传入
+type Logger struct {
+	fields []interface{}
+}
+
+// With 返回带有 kv 字段的 Logger
+func With(kv ...interface{}) *Logger {
+	return (*Logger)(nil).With(kv...)
+}
+
+// With 在已有字段后追加 kv，l 为 nil 时等同于包级的 With
+func (l *Logger) With(kv ...interface{}) *Logger {
+	fields := make([]interface{}, 0, l.len()+len(kv))
+	if l != nil {
+		fields = append(fields, l.fields...)
+	}
+	return &Logger{fields: append(fields, kv...)}
+}
+
+func (l *Logger) len() int {
+	if l == nil {
+		return 0
+	}
+	return len(l.fields)
+}
+
+func (l *Logger) get() []interface{} {
+	if l == nil {
+		return nil
+	}
+	return l.fields
+}
+
+func (l *Logger) Printf(format string, v ...interface{}) {
+	if enabled(INFO) {
+		output(2, "", l.get(), fmt.Sprintf(format, v...))
+	}
+}
+
+func (l *Logger) Debug(format string, v ...interface{}) {
+	if enabled(DEBUG) {
+		output(2, "DEBUG", l.get(), fmt.Sprintf(format, v...))
+	}
+}
+
+func (l *Logger) Info(format string, v ...interface{}) {
+	if enabled(INFO) {
+		output(2, "INFO", l.get(), fmt.Sprintf(format, v...))
+	}
+}
+
+func (l *Logger) Warn(format string, v ...interface{}) {
+	if enabled(WARN) {
+		output(2, "WARN", l.get(), fmt.Sprintf(format, v...))
+	}
+}
+
+func (l *Logger) Error(format string, v ...interface{}) {
+	if enabled(ERROR) {
+		output(2, "ERROR", l.get(), fmt.Sprintf(format, v...))
+	}
+}
+
+type entry struct {
+	time   time.Time
+	level  string
+	caller string
+	msg    string
+	fields []interface{}
+}
+
+// pairs 把字段整理成 key, value 对，缺少 value 的 key 记为空字符串
+func (e entry) pairs() [][2]interface{} {
+	result := make([][2]interface{}, 0, (len(e.fields)+1)/2)
+	for i := 0; i < len(e.fields); i += 2 {
+		pair := [2]interface{}{e.fields[i], ""}
+		if i+1 < len(e.fields) {
+			pair[1] = e.fields[i+1]
+		}
+		result = append(result, pair)
+	}
+	return result
+}
+
+// text 与原有的文本格式相同：[file:line][LEVEL]msg key=value ...
+func (e entry) text() string {
+	var b strings.Builder
+	b.WriteString("[" + e.caller + "]")
+	if e.level != "" {
+		b.WriteString("[" + e.level + "]")
+	}
+	b.WriteString(strings.TrimRight(e.msg, "\n"))
+	for _, pair := range e.pairs() {
+		value := fmt.Sprint(pair[1])
+		if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+			value = fmt.Sprintf("%q", value)
+		}
+		fmt.Fprintf(&b, " %v=%s", pair[0], value)
+	}
+	return b.String()
+}
+
+// json 输出一行 JSON，字段顺序固定为 time、level、caller、msg，之后按传入顺序输出字段
+func (e entry) json(prefix string) []byte {
+	level := e.level
+	if level == "" {
+		level = "INFO"
+	}
+	var b bytes.Buffer
+	b.WriteByte('{')
+	writeJSON(&b, "time", e.time.Format(time.RFC3339Nano))
+	b.WriteByte(',')
+	writeJSON(&b, "level", level)
+	if prefix != "" {
+		b.WriteByte(',')
+		writeJSON(&b, "prefix", strings.TrimSpace(prefix))
+	}
+	b.WriteByte(',')
+	writeJSON(&b, "caller", e.caller)
+	b.WriteByte(',')
+	writeJSON(&b, "msg", strings.TrimRight(e.msg, "\n"))
+	for _, pair := range e.pairs() {
+		b.WriteByte(',')
+		writeJSON(&b, fmt.Sprint(pair[0]), pair[1])
+	}
+	b.WriteString("}\n")
+	return b.Bytes()
+}
+
+// writeJSON 写入 "key":value，error 输出为错误信息，无法编码的值按 %v 输出为字符串
+func writeJSON(b *bytes.Buffer, key string, value interface{}) {
+	k, _ := json.Marshal(key)
+	b.Write(k)
+	b.WriteByte(':')
+	if err, ok := value.(error); ok {
+		value = err.Error()
+	}
+	v, err := json.Marshal(value)
+	if err != nil {
+		v, _ = json.Marshal(fmt.Sprint(value))
+	}
+	b.Write(v)
+}
@@ -0,0 +1,56 @@
+package log
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestEntryText(t *testing.T) {
+	e := entry{
+		level:  "WARN",
+		caller: "market.go:10",
+		msg:    "check shortfall error\n",
+		fields: []interface{}{"cid", "ab12", "amount", big.NewInt(5), "error", errors.New("rpc down"), "dangling"},
+	}
+	want := `[market.go:10][WARN]check shortfall error cid=ab12 amount=5 error="rpc down" dangling=""`
+	if got := e.text(); got != want {
+		t.Errorf("text = %s, want %s", got, want)
+	}
+}
+
+func TestEntryJSON(t *testing.T) {
+	e := entry{
+		time:   time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC),
+		caller: "executor.go:20",
+		msg:    "plan",
+		fields: []interface{}{"cid", "ab12", "repayAmount", big.NewInt(700), "error", errors.New("reverted")},
+	}
+	data := e.json("")
+	var got map[string]interface{}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("%s: %s", data, err)
+	}
+	want := map[string]interface{}{
+		"time": "2022-01-02T03:04:05Z", "level": "INFO", "caller": "executor.go:20", "msg": "plan",
+		"cid": "ab12", "repayAmount": float64(700), "error": "reverted",
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s = %v, want %v", k, got[k], v)
+		}
+	}
+}
+
+func TestLoggerWith(t *testing.T) {
+	base := With("cid", "ab12")
+	child := base.With("tx", "0x1")
+	if len(base.fields) != 2 || len(child.fields) != 4 {
+		t.Errorf("base = %v, child = %v", base.fields, child.fields)
+	}
+	if a, b := NewCorrelationID(), NewCorrelationID(); len(a) != 16 || a == b {
+		t.Errorf("correlation IDs %s %s", a, b)
+	}
+}
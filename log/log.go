@@ -2,12 +2,13 @@ package log
 
 import (
 	"fmt"
-	"log"
+	"io"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -22,190 +23,303 @@ const (
 
 const DATE_FORMAT = "2006-01-02"
 
+// TIME_FORMAT 文本格式每行开头的时间，与标准库 log.LstdFlags|log.Lmicroseconds 相同
+const TIME_FORMAT = "2006/01/02 15:04:05.000000"
+
+// 日志队列满时的处理方式
+const (
+	// OVERFLOW_BLOCK 等待队列有空位，不丢日志但会拖慢调用方
+	OVERFLOW_BLOCK = "block"
+	// OVERFLOW_DROP 丢弃新的日志
+	OVERFLOW_DROP = "drop"
+	// OVERFLOW_DROP_OLDEST 丢弃队列中最早的日志，保留最新的
+	OVERFLOW_DROP_OLDEST = "dropOldest"
+)
+
+const defaultBufferSize = 5000
+
+// Options 日志配置，零值字段使用默认值：不按大小切分、不限制保留数量、队列 5000 条、队列满时等待
+type Options struct {
+	FileDir  string
+	FileName string
+	Prefix   string
+	Level    string
+	// Format text 或 json
+	Format string
+	// Stdout 同时输出到标准输出，FileName 为空时只输出到标准输出
+	Stdout bool
+	// MaxSize 单个日志文件的最大字节数，超过后切分，0 表示只按日期切分
+	MaxSize int64
+	// MaxFiles 保留的已切分文件数量，0 表示不删除
+	MaxFiles int
+	// Compress 切分后的文件用 gzip 压缩
+	Compress bool
+	// BufferSize 日志队列长度
+	BufferSize int
+	// Overflow 队列满时的处理方式：block、drop 或 dropOldest
+	Overflow string
+}
+
 type FileLogger struct {
-	fileDir       string
-	fileName      string
 	prefix        string
-	logLevel      LEVEL
-	logFile       *os.File
-	date          *time.Time
-	lg            *log.Logger
+	file          *rotateFile
+	stdout        io.Writer
+	overflow      string
+	dropped       uint64
 	mu            *sync.RWMutex
-	logChan       chan string
+	closed        bool
+	logChan       chan entry
+	done          chan struct{}
 	stopTikerChan chan bool
 }
 
-var fileLogger *FileLogger
+var (
+	fileLogger *FileLogger
+	logLevel   int32 = int32(INFO)
+)
 
 func Init(fileDir, fileName, prefix, level string) error {
+	return InitWithOptions(Options{FileDir: fileDir, FileName: fileName, Prefix: prefix, Level: level})
+}
+
+// InitWithOptions 按 opts 打开文件和标准输出，启动写日志和定时切分的 goroutine
+func InitWithOptions(opts Options) error {
 	CloseLogger()
 
+	switch opts.Overflow {
+	case "":
+		opts.Overflow = OVERFLOW_BLOCK
+	case OVERFLOW_BLOCK, OVERFLOW_DROP, OVERFLOW_DROP_OLDEST:
+	default:
+		return fmt.Errorf("unknown log overflow policy %q", opts.Overflow)
+	}
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = defaultBufferSize
+	}
+	if err := SetFormat(opts.Format); err != nil {
+		return err
+	}
+	SetLevel(opts.Level)
+
 	f := &FileLogger{
-		fileDir:       fileDir,
-		fileName:      fileName,
-		prefix:        prefix,
+		prefix:        opts.Prefix,
+		overflow:      opts.Overflow,
 		mu:            new(sync.RWMutex),
-		logChan:       make(chan string, 5000),
+		logChan:       make(chan entry, opts.BufferSize),
+		done:          make(chan struct{}),
 		stopTikerChan: make(chan bool, 1),
 	}
+	if opts.FileName != "" {
+		file, err := openRotateFile(opts)
+		if err != nil {
+			return err
+		}
+		f.file = file
+	}
+	if opts.Stdout || f.file == nil {
+		f.stdout = os.Stdout
+	}
+
+	go f.logWriter()
+	go f.fileMonitor()
+
+	fileLogger = f
+
+	return nil
+}
 
+// ParseLevel 解析日志级别，无法识别时为 INFO
+func ParseLevel(level string) LEVEL {
 	switch strings.ToUpper(level) {
 	case "DEBUG":
-		f.logLevel = DEBUG
+		return DEBUG
 	case "WARN":
-		f.logLevel = WARN
+		return WARN
 	case "ERROR":
-		f.logLevel = ERROR
+		return ERROR
 	default:
-		f.logLevel = INFO
+		return INFO
 	}
+}
 
-	t, _ := time.Parse(DATE_FORMAT, time.Now().Format(DATE_FORMAT))
-	f.date = &t
-
-	f.isExistOrCreateFileDir()
-
-	fullFileName := filepath.Join(f.fileDir, f.fileName+".log")
-	file, err := os.OpenFile(fullFileName, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0666)
-	if err != nil {
-		return err
-	}
-	f.logFile = file
-
-	f.lg = log.New(f.logFile, prefix, log.LstdFlags|log.Lmicroseconds)
-
-	go f.logWriter()
-	go f.fileMonitor()
+// SetLevel 修改日志级别，可以在运行中调用
+func SetLevel(level string) {
+	atomic.StoreInt32(&logLevel, int32(ParseLevel(level)))
+}
 
-	fileLogger = f
+func GetLevel() LEVEL {
+	return LEVEL(atomic.LoadInt32(&logLevel))
+}
 
-	return nil
+func enabled(level LEVEL) bool {
+	return level >= GetLevel()
 }
 
-func (f *FileLogger) isExistOrCreateFileDir() {
-	_, err := os.Stat(f.fileDir)
-	if err != nil && !os.IsExist(err) {
-		os.Mkdir(f.fileDir, 0755)
+// Dropped 队列满时被丢弃的日志数量
+func Dropped() uint64 {
+	if fileLogger == nil {
+		return 0
 	}
+	return atomic.LoadUint64(&fileLogger.dropped)
 }
 
 func (f *FileLogger) logWriter() {
+	defer close(f.done)
 	defer func() { recover() }()
 
-	for {
-		str, ok := <-f.logChan
-		if !ok {
-			return
+	for e := range f.logChan {
+		var line []byte
+		if isJSON() {
+			line = e.json(f.prefix)
+		} else {
+			line = []byte(f.prefix + e.time.Format(TIME_FORMAT) + " " + e.text() + "\n")
+		}
+		if f.file != nil {
+			if err := f.file.Write(line, e.time); err != nil {
+				fmt.Fprintf(os.Stderr, "log write error: %v\n", err)
+			}
+		}
+		if f.stdout != nil {
+			f.stdout.Write(line)
 		}
-
-		f.mu.RLock()
-		f.lg.Output(2, str)
-		f.mu.RUnlock()
 	}
 }
 
+// fileMonitor 跨天时切分文件，并报告队列满时丢弃的日志数量
 func (f *FileLogger) fileMonitor() {
 	defer func() { recover() }()
 
 	ticker := time.NewTicker(30 * time.Second)
 	defer ticker.Stop()
+	var reported uint64
 	for {
 		select {
 		case <-ticker.C:
-			if f.isMustSplit() {
-				if err := f.split(); err != nil {
-					Error("Log split error: %v\n", err)
+			if f.file != nil {
+				if err := f.file.SplitByDate(time.Now()); err != nil {
+					Error("Log split error: %v", err)
 				}
 			}
+			if dropped := atomic.LoadUint64(&f.dropped); dropped > reported {
+				Warn("log queue full, dropped %d entries", dropped-reported)
+				reported = dropped
+			}
 		case <-f.stopTikerChan:
 			return
 		}
 	}
 }
 
-func (f *FileLogger) isMustSplit() bool {
-	t, _ := time.Parse(DATE_FORMAT, time.Now().Format(DATE_FORMAT))
-	return t.After(*f.date)
-}
-
-func (f *FileLogger) split() error {
-	f.mu.Lock()
-	defer f.mu.Unlock()
-
-	logFile := filepath.Join(f.fileDir, f.fileName)
-	logFileBak := logFile + "-" + f.date.Format(DATE_FORMAT) + ".log"
-
-	if f.logFile != nil {
-		f.logFile.Close()
+// push 按 overflow 策略放入队列，日志已关闭时丢弃
+func (f *FileLogger) push(e entry) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.closed {
+		return
 	}
-
-	err := os.Rename(logFile, logFileBak)
-	if err != nil {
-		return err
-	}
-
-	t, _ := time.Parse(DATE_FORMAT, time.Now().Format(DATE_FORMAT))
-	f.date = &t
-
-	f.logFile, err = os.OpenFile(logFile, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0666)
-	if err != nil {
-		return err
+	switch f.overflow {
+	case OVERFLOW_DROP:
+		select {
+		case f.logChan <- e:
+		default:
+			atomic.AddUint64(&f.dropped, 1)
+		}
+	case OVERFLOW_DROP_OLDEST:
+		for {
+			select {
+			case f.logChan <- e:
+				return
+			default:
+			}
+			select {
+			case <-f.logChan:
+				atomic.AddUint64(&f.dropped, 1)
+			default:
+			}
+		}
+	default:
+		f.logChan <- e
 	}
-
-	f.lg = log.New(f.logFile, f.prefix, log.LstdFlags|log.Lmicroseconds)
-
-	return nil
 }
 
+// CloseLogger 写完队列中剩余的日志后关闭文件
 func CloseLogger() {
-	if fileLogger != nil {
-		fileLogger.stopTikerChan <- true
-		close(fileLogger.stopTikerChan)
-		close(fileLogger.logChan)
-		fileLogger.lg = nil
-		fileLogger.logFile.Close()
+	f := fileLogger
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return
+	}
+	f.closed = true
+	f.stopTikerChan <- true
+	close(f.stopTikerChan)
+	close(f.logChan)
+	f.mu.Unlock()
+
+	<-f.done
+	if f.file != nil {
+		f.file.Close()
 	}
 }
 
+// Printf、Print、Println 按 INFO 级别过滤，输出时不带级别标记
 func Printf(format string, v ...interface{}) {
-	_, file, line, _ := runtime.Caller(1)
-	fileLogger.logChan <- fmt.Sprintf("[%v:%v]", filepath.Base(file), line) + fmt.Sprintf(format, v...)
+	if enabled(INFO) {
+		output(2, "", nil, fmt.Sprintf(format, v...))
+	}
 }
 
 func Print(v ...interface{}) {
-	_, file, line, _ := runtime.Caller(1)
-	fileLogger.logChan <- fmt.Sprintf("[%v:%v]", filepath.Base(file), line) + fmt.Sprint(v...)
+	if enabled(INFO) {
+		output(2, "", nil, fmt.Sprint(v...))
+	}
 }
 
 func Println(v ...interface{}) {
-	_, file, line, _ := runtime.Caller(1)
-	fileLogger.logChan <- fmt.Sprintf("[%v:%v]", filepath.Base(file), line) + fmt.Sprintln(v...)
+	if enabled(INFO) {
+		output(2, "", nil, fmt.Sprintln(v...))
+	}
 }
 
 func Debug(format string, v ...interface{}) {
-	_, file, line, _ := runtime.Caller(1)
-	if fileLogger.logLevel <= DEBUG {
-		fileLogger.logChan <- fmt.Sprintf("[%v:%v]", filepath.Base(file), line) + fmt.Sprintf("[DEBUG]"+format, v...)
+	if enabled(DEBUG) {
+		output(2, "DEBUG", nil, fmt.Sprintf(format, v...))
 	}
 }
 
 func Info(format string, v ...interface{}) {
-	_, file, line, _ := runtime.Caller(1)
-	if fileLogger.logLevel <= INFO {
-		fileLogger.logChan <- fmt.Sprintf("[%v:%v]", filepath.Base(file), line) + fmt.Sprintf("[INFO]"+format, v...)
+	if enabled(INFO) {
+		output(2, "INFO", nil, fmt.Sprintf(format, v...))
 	}
 }
 
 func Warn(format string, v ...interface{}) {
-	_, file, line, _ := runtime.Caller(1)
-	if fileLogger.logLevel <= WARN {
-		fileLogger.logChan <- fmt.Sprintf("[%v:%v]", filepath.Base(file), line) + fmt.Sprintf("[WARN]"+format, v...)
+	if enabled(WARN) {
+		output(2, "WARN", nil, fmt.Sprintf(format, v...))
 	}
 }
 
 func Error(format string, v ...interface{}) {
-	_, file, line, _ := runtime.Caller(1)
-	if fileLogger.logLevel <= ERROR {
-		fileLogger.logChan <- fmt.Sprintf("[%v:%v]", filepath.Base(file), line) + fmt.Sprintf("[ERROR]"+format, v...)
+	if enabled(ERROR) {
+		output(2, "ERROR", nil, fmt.Sprintf(format, v...))
+	}
+}
+
+// output 记录调用位置后放入写日志的队列，depth 为从调用方到 output 的层数；Init 之前的日志丢弃
+func output(depth int, level string, fields []interface{}, msg string) {
+	f := fileLogger
+	if f == nil {
+		return
 	}
+	_, file, line, _ := runtime.Caller(depth)
+	f.push(entry{
+		time:   time.Now(),
+		level:  level,
+		caller: fmt.Sprintf("%v:%v", filepath.Base(file), line),
+		msg:    msg,
+		fields: fields,
+	})
 }
@@ -0,0 +1,135 @@
+package log
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func readLog(t *testing.T, dir string) string {
+	data, err := ioutil.ReadFile(filepath.Join(dir, "test.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestLevelFiltersAllEntryPoints(t *testing.T) {
+	dir := t.TempDir()
+	if err := Init(dir, "test", "", "WARN"); err != nil {
+		t.Fatal(err)
+	}
+	Printf("printf hidden")
+	Println("println hidden")
+	With("cid", "1").Printf("with hidden")
+	Info("info hidden")
+	Warn("warn shown")
+	SetLevel("INFO")
+	Printf("printf shown")
+	CloseLogger()
+
+	out := readLog(t, dir)
+	if strings.Contains(out, "hidden") {
+		t.Errorf("filtered entries written:\n%s", out)
+	}
+	if !strings.Contains(out, "[WARN]warn shown") || !strings.Contains(out, "printf shown") {
+		t.Errorf("missing entries:\n%s", out)
+	}
+}
+
+func TestRotateBySize(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{FileDir: dir, FileName: "test", MaxSize: 200, MaxFiles: 2, Compress: true}
+	r, err := openRotateFile(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := []byte(strings.Repeat("x", 99) + "\n")
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		if err := r.Write(line, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r.Close()
+	// 等待后台压缩和清理完成
+	r.cleanup.Lock()
+	r.cleanup.Unlock()
+
+	var backups []string
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		backups, err = r.Backups()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(backups) == 2 && strings.HasSuffix(backups[0], ".gz") && strings.HasSuffix(backups[1], ".gz") {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	date := now.Format(DATE_FORMAT)
+	want := []string{"test-" + date + ".2.log.gz", "test-" + date + ".3.log.gz"}
+	if len(backups) != 2 || filepath.Base(backups[0]) != want[0] || filepath.Base(backups[1]) != want[1] {
+		t.Fatalf("backups = %v, want %v", backups, want)
+	}
+	f, err := os.Open(backups[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(zr)
+	if err != nil || len(data) != 200 {
+		t.Errorf("backup size = %d, err = %v", len(data), err)
+	}
+	if current := readLog(t, dir); len(current) != 200 {
+		t.Errorf("current size = %d", len(current))
+	}
+}
+
+func TestRotateByDate(t *testing.T) {
+	dir := t.TempDir()
+	r, err := openRotateFile(Options{FileDir: dir, FileName: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	yesterday := r.date.AddDate(0, 0, -1)
+	r.date = yesterday
+	if err := r.Write([]byte("today\n"), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if !exists(filepath.Join(dir, "test-"+yesterday.Format(DATE_FORMAT)+".log")) {
+		t.Error("yesterday's file not split")
+	}
+	if current := readLog(t, dir); current != "today\n" {
+		t.Errorf("current = %q", current)
+	}
+}
+
+func TestOverflowDrop(t *testing.T) {
+	f := &FileLogger{overflow: OVERFLOW_DROP, logChan: make(chan entry, 2), mu: new(sync.RWMutex)}
+	for i := 0; i < 5; i++ {
+		f.push(entry{msg: "m"})
+	}
+	if len(f.logChan) != 2 || f.dropped != 3 {
+		t.Errorf("queued = %d, dropped = %d", len(f.logChan), f.dropped)
+	}
+
+	f = &FileLogger{overflow: OVERFLOW_DROP_OLDEST, logChan: make(chan entry, 2), mu: new(sync.RWMutex)}
+	for _, msg := range []string{"a", "b", "c"} {
+		f.push(entry{msg: msg})
+	}
+	if first := <-f.logChan; first.msg != "b" || f.dropped != 1 {
+		t.Errorf("first = %s, dropped = %d", first.msg, f.dropped)
+	}
+}
@@ -0,0 +1,275 @@
+package log
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rotateFile 当前写入的日志文件，跨天或超过 maxSize 时切分为 fileName-日期[.序号].log，
+// 切分后的文件按需压缩为 .gz，只保留最新的 maxFiles 个
+type rotateFile struct {
+	dir      string
+	name     string
+	maxSize  int64
+	maxFiles int
+	compress bool
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+	date time.Time
+	// cleanup 串行执行压缩和删除，避免两次切分同时处理同一个文件
+	cleanup sync.Mutex
+}
+
+func openRotateFile(opts Options) (*rotateFile, error) {
+	dir := opts.FileDir
+	if dir == "" {
+		dir = "."
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	r := &rotateFile{
+		dir:      dir,
+		name:     opts.FileName,
+		maxSize:  opts.MaxSize,
+		maxFiles: opts.MaxFiles,
+		compress: opts.Compress,
+		date:     day(time.Now()),
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func day(t time.Time) time.Time {
+	d, _ := time.Parse(DATE_FORMAT, t.Format(DATE_FORMAT))
+	return d
+}
+
+func (r *rotateFile) path() string {
+	return filepath.Join(r.dir, r.name+".log")
+}
+
+func (r *rotateFile) open() error {
+	file, err := os.OpenFile(r.path(), os.O_RDWR|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	r.file = file
+	r.size = info.Size()
+	return nil
+}
+
+// Write 写入一行日志，t 与文件日期不同或写入后超过 maxSize 时先切分
+func (r *rotateFile) Write(line []byte, t time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return os.ErrClosed
+	}
+	if day(t).After(r.date) || (r.maxSize > 0 && r.size > 0 && r.size+int64(len(line)) > r.maxSize) {
+		if err := r.rotate(day(t)); err != nil {
+			return err
+		}
+	}
+	n, err := r.file.Write(line)
+	r.size += int64(n)
+	return err
+}
+
+// SplitByDate 跨天且没有新日志写入时由 fileMonitor 触发切分
+func (r *rotateFile) SplitByDate(now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil || !day(now).After(r.date) {
+		return nil
+	}
+	return r.rotate(day(now))
+}
+
+// rotate 把当前文件改名为以其日期命名的备份并打开新文件，压缩和清理在后台进行
+func (r *rotateFile) rotate(date time.Time) error {
+	r.file.Close()
+	r.file = nil
+	backup := r.backupName()
+	if err := os.Rename(r.path(), backup); err != nil {
+		// 改名失败时继续写原文件，避免丢日志
+		if openErr := r.open(); openErr != nil {
+			return openErr
+		}
+		return err
+	}
+	r.date = date
+	if err := r.open(); err != nil {
+		return err
+	}
+	go r.afterRotate(backup)
+	return nil
+}
+
+// backupName fileName-日期.log，同一天已有备份时使用比已有备份更大的序号 .1、.2 等，
+// 已删除的旧备份的序号不会被重新使用
+func (r *rotateFile) backupName() string {
+	base := filepath.Join(r.dir, r.name+"-"+r.date.Format(DATE_FORMAT))
+	next := 0
+	backups, _ := r.backups()
+	for _, b := range backups {
+		if b.date.Equal(r.date) && b.index >= next {
+			next = b.index + 1
+		}
+	}
+	for i := next; ; i++ {
+		name := base + ".log"
+		if i > 0 {
+			name = fmt.Sprintf("%s.%d.log", base, i)
+		}
+		if !exists(name) && !exists(name+".gz") {
+			return name
+		}
+	}
+}
+
+func exists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+func (r *rotateFile) afterRotate(backup string) {
+	r.cleanup.Lock()
+	defer r.cleanup.Unlock()
+	if r.compress {
+		if err := compressFile(backup); err != nil {
+			fmt.Fprintf(os.Stderr, "log compress %s error: %v\n", backup, err)
+		}
+	}
+	if err := r.prune(); err != nil {
+		fmt.Fprintf(os.Stderr, "log prune error: %v\n", err)
+	}
+}
+
+// compressFile 压缩为 name.gz 后删除原文件
+func compressFile(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(name+".gz", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	if _, err := io.Copy(zw, src); err != nil {
+		zw.Close()
+		dst.Close()
+		os.Remove(name + ".gz")
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		dst.Close()
+		os.Remove(name + ".gz")
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(name + ".gz")
+		return err
+	}
+	return os.Remove(name)
+}
+
+type backup struct {
+	name  string
+	date  time.Time
+	index int
+}
+
+// Backups 已切分的日志文件，按日期和序号从旧到新排列
+func (r *rotateFile) Backups() ([]string, error) {
+	backups, err := r.backups()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]string, len(backups))
+	for i, b := range backups {
+		result[i] = b.name
+	}
+	return result, nil
+}
+
+func (r *rotateFile) backups() ([]backup, error) {
+	matches, err := filepath.Glob(filepath.Join(r.dir, r.name+"-*.log*"))
+	if err != nil {
+		return nil, err
+	}
+	backups := make([]backup, 0, len(matches))
+	for _, name := range matches {
+		rest := strings.TrimPrefix(filepath.Base(name), r.name+"-")
+		rest = strings.TrimSuffix(rest, ".gz")
+		if !strings.HasSuffix(rest, ".log") {
+			continue
+		}
+		rest = strings.TrimSuffix(rest, ".log")
+		b := backup{name: name}
+		if i := strings.IndexByte(rest, '.'); i >= 0 {
+			if _, err := fmt.Sscanf(rest[i+1:], "%d", &b.index); err != nil {
+				continue
+			}
+			rest = rest[:i]
+		}
+		if b.date, err = time.Parse(DATE_FORMAT, rest); err != nil {
+			continue
+		}
+		backups = append(backups, b)
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		if !backups[i].date.Equal(backups[j].date) {
+			return backups[i].date.Before(backups[j].date)
+		}
+		return backups[i].index < backups[j].index
+	})
+	return backups, nil
+}
+
+// prune 只保留最新的 maxFiles 个备份
+func (r *rotateFile) prune() error {
+	if r.maxFiles <= 0 {
+		return nil
+	}
+	backups, err := r.Backups()
+	if err != nil {
+		return err
+	}
+	for len(backups) > r.maxFiles {
+		if err := os.Remove(backups[0]); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+func (r *rotateFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
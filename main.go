@@ -3,40 +3,98 @@ package main
 import (
 	// "liquidator/log"
 
+	"context"
 	"fmt"
+	"liquidator/admin"
+	"liquidator/alert"
+	"liquidator/competitor"
 	"liquidator/conf"
 	"liquidator/contract"
 	"liquidator/executor"
 	"liquidator/handler"
 	"liquidator/log"
+	"liquidator/opportunity"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 )
 
 func initLog() {
-	fileDir := conf.Config.Log.FileDir
-	fileName := conf.Config.Log.FileName
-	prefix := conf.Config.Log.Prefix
-	level := conf.Config.Log.Level
-
-	if err := log.Init(fileDir, fileName, prefix, level); err != nil {
+	c := conf.Config.Log
+	opts := log.Options{
+		FileDir:    c.FileDir,
+		FileName:   c.FileName,
+		Prefix:     c.Prefix,
+		Level:      c.Level,
+		Format:     c.Format,
+		Stdout:     c.Stdout,
+		MaxSize:    c.MaxSize << 20,
+		MaxFiles:   c.MaxFiles,
+		Compress:   c.Compress,
+		BufferSize: c.BufferSize,
+		Overflow:   c.Overflow,
+	}
+	if err := log.InitWithOptions(opts); err != nil {
 		panic(err)
 	}
 }
 
-func init() {
-	conf.Init()
+func main() {
+	configPath, args := parseConfigFlag(os.Args[1:])
+	if err := conf.Init(configPath); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 
 	initLog()
 
-	contract.Init()
+	if err := runCommand(args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 }
 
-func main() {
+// parseConfigFlag 取出命令之前的 --config <path> 或 --config=<path>，其余参数交给子命令
+func parseConfigFlag(args []string) (string, []string) {
+	path := ""
+	for len(args) > 0 {
+		switch {
+		case (args[0] == "--config" || args[0] == "-config") && len(args) > 1:
+			path, args = args[1], args[2:]
+		case strings.HasPrefix(args[0], "--config="):
+			path, args = strings.TrimPrefix(args[0], "--config="), args[1:]
+		case strings.HasPrefix(args[0], "-config="):
+			path, args = strings.TrimPrefix(args[0], "-config="), args[1:]
+		default:
+			return path, args
+		}
+	}
+	return path, args
+}
+
+func runDaemon() {
 	fmt.Println("starting...")
+	if err := alert.Init(conf.Config.Alerts); err != nil {
+		log.Printf("init alerts error: %s", err)
+	}
+	if err := opportunity.Init(conf.Config.DryRun.Store); err != nil {
+		log.Printf("open opportunity store %s error: %s", conf.Config.DryRun.Store, err)
+	}
+	for _, chain := range contract.Chains() {
+		if chain.DryRun() {
+			log.Printf("[%s] dry run: transactions are simulated with eth_call and not sent", chain.Name)
+		}
+	}
 	handler.Start()
-	go executor.Run()
+	go executor.Run(context.Background())
+	if c := conf.Config.Competitors; c.Enabled {
+		if err := competitor.Init(c.Store); err != nil {
+			log.Printf("open competitor store %s error: %s", c.Store, err)
+		}
+		competitor.Start(c.Backfill)
+	}
+	admin.Start(conf.Config.Admin.Listen, conf.Config.Admin.Token)
 
 	//如果监听到系统信号 SIGQUIT 就退出程序，否则一直阻塞
 	exitChan := make(chan int)
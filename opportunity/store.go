@@ -0,0 +1,143 @@
+// Package opportunity 记录清算机会及其模拟结果，每行一条 JSON 追加写入文件，
+// 同时在内存中保留最近的记录供管理接口查询
+package opportunity
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"liquidator/log"
+)
+
+// recentSize 内存中保留的记录数量
+const recentSize = 1000
+
+// Opportunity 一次清算机会：计划、模拟结果和按预言机价格估算的收益（美元）
+type Opportunity struct {
+	ID string
+	// CorrelationID 与该机会从扫描到提交的日志中的 cid 字段相同
+	CorrelationID string `json:",omitempty"`
+	Time          time.Time
+	Chain         string
+	Protocol      string
+	Borrower      string
+	RepayMarket   string
+	Collateral    string
+	RepayAmount   *big.Int
+	SeizeTokens   *big.Int `json:",omitempty"`
+	Block         uint64
+	DryRun        bool
+	Success       bool
+	Error         string `json:",omitempty"`
+	// AllowanceMissing dryRun 时钱包授权不足，没有模拟清算，不是清算失败
+	AllowanceMissing bool `json:",omitempty"`
+	Gas              uint64
+	GasPrice         *big.Int `json:",omitempty"`
+	RepayValue       decimal.Decimal
+	SeizeValue       decimal.Decimal
+	GasCost          decimal.Decimal
+	Profit           decimal.Decimal
+}
+
+var (
+	mu     sync.Mutex
+	file   *os.File
+	recent []Opportunity
+)
+
+// Init 打开记录文件，path 为空时只保存在内存中
+func Init(path string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	if file != nil {
+		file.Close()
+		file = nil
+	}
+	if path == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	file = f
+	return nil
+}
+
+// duplicate 每轮扫描都会重新模拟同一个资不抵债的借款人：与该借款人在该市场的上一条记录同一区块，
+// 或计划和结果都没有变化时视为重复，调用方需持有 mu
+func duplicate(o Opportunity) bool {
+	for i := len(recent) - 1; i >= 0; i-- {
+		r := recent[i]
+		if r.Protocol != o.Protocol || r.Borrower != o.Borrower || r.RepayMarket != o.RepayMarket {
+			continue
+		}
+		if r.Block == o.Block {
+			return true
+		}
+		return r.Collateral == o.Collateral && r.Success == o.Success && r.Error == o.Error &&
+			r.AllowanceMissing == o.AllowanceMissing && r.RepayAmount.Cmp(o.RepayAmount) == 0
+	}
+	return false
+}
+
+// Add 保存一条记录，重复的记录不保存并返回 false；写文件失败只记录日志
+func Add(o Opportunity) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	if duplicate(o) {
+		return false
+	}
+	recent = append(recent, o)
+	if len(recent) > recentSize {
+		recent = recent[len(recent)-recentSize:]
+	}
+	if file == nil {
+		return true
+	}
+	data, err := json.Marshal(o)
+	if err != nil {
+		log.Printf("opportunity %s marshal error: %s", o.ID, err)
+		return true
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		log.Printf("opportunity %s write error: %s", o.ID, err)
+	}
+	return true
+}
+
+// Recent 返回内存中最近的记录，按时间先后排列
+func Recent() []Opportunity {
+	mu.Lock()
+	defer mu.Unlock()
+	return append([]Opportunity(nil), recent...)
+}
+
+// Load 读取记录文件
+func Load(r io.Reader) ([]Opportunity, error) {
+	result := make([]Opportunity, 0)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var o Opportunity
+		if err := json.Unmarshal(scanner.Bytes(), &o); err != nil {
+			return nil, err
+		}
+		result = append(result, o)
+	}
+	return result, scanner.Err()
+}
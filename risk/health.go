@@ -0,0 +1,112 @@
+package risk
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/shopspring/decimal"
+
+	"liquidator/contract"
+)
+
+var (
+	mantissaOne = decimal.New(1, 18)
+	one         = decimal.NewFromInt(1)
+)
+
+type Position struct {
+	Market           string
+	Supply           *big.Int
+	Borrow           *big.Int
+	ExchangeRate     *big.Int
+	Price            *big.Int
+	CollateralFactor decimal.Decimal
+}
+
+type Health struct {
+	Protocol        string
+	Borrower        string
+	Positions       []Position
+	CollateralValue decimal.Decimal
+	BorrowValue     decimal.Decimal
+	Liquidity       *big.Int
+	Shortfall       *big.Int
+	HasDebt         bool
+	HealthFactor    decimal.Decimal
+	PriceMove       decimal.Decimal
+}
+
+// LoadPositions 在同一区块上批量读取账户的所有仓位，任意一个市场读取失败都返回错误，避免用不完整的仓位算出错误的健康度
+func LoadPositions(p *contract.Protocol, borrower string) ([]Position, error) {
+	snapshot, err := p.LoadAccount(context.Background(), borrower)
+	if err != nil {
+		return nil, err
+	}
+	return snapshotPositions(snapshot), nil
+}
+
+func snapshotPositions(snapshot *contract.AccountSnapshot) []Position {
+	result := make([]Position, 0, len(snapshot.Assets))
+	for _, asset := range snapshot.Assets {
+		result = append(result, Position{
+			Market:           asset.Market,
+			Supply:           asset.Supply,
+			Borrow:           asset.Borrow,
+			ExchangeRate:     asset.ExchangeRate,
+			Price:            asset.Price,
+			CollateralFactor: asset.CollateralFactor,
+		})
+	}
+	return result
+}
+
+// Evaluate 用本地模型计算健康度，同时记录同一区块上链上 GetAccountLiquidity 的结果用于对照
+func Evaluate(p *contract.Protocol, borrower string) (Health, error) {
+	snapshot, err := p.LoadAccount(context.Background(), borrower)
+	if err != nil {
+		return Health{}, err
+	}
+	h := Compute(borrower, snapshotPositions(snapshot))
+	h.Protocol = p.Name
+	h.Liquidity, h.Shortfall = snapshot.Liquidity, snapshot.Shortfall
+	return h, nil
+}
+
+// Compute 健康度 = 按抵押率折算的抵押价值 / 借款价值，小于 1 即可被清算。
+// PriceMove 为抵押物价格整体下跌多少比例后账户变为可清算。
+func Compute(borrower string, positions []Position) Health {
+	h := Health{
+		Borrower:        borrower,
+		Positions:       positions,
+		CollateralValue: decimal.Zero,
+		BorrowValue:     decimal.Zero,
+		HealthFactor:    decimal.Zero,
+		PriceMove:       decimal.Zero,
+	}
+	for _, p := range positions {
+		price := decimal.NewFromBigInt(p.Price, 0)
+		if p.Supply.Sign() > 0 {
+			underlying := decimal.NewFromBigInt(p.Supply, 0).Mul(decimal.NewFromBigInt(p.ExchangeRate, 0)).Div(mantissaOne)
+			h.CollateralValue = h.CollateralValue.Add(underlying.Mul(price).Div(mantissaOne).Mul(p.CollateralFactor))
+		}
+		if p.Borrow.Sign() > 0 {
+			h.BorrowValue = h.BorrowValue.Add(decimal.NewFromBigInt(p.Borrow, 0).Mul(price).Div(mantissaOne))
+		}
+	}
+	if h.BorrowValue.Sign() <= 0 {
+		return h
+	}
+	h.HasDebt = true
+	h.HealthFactor = h.CollateralValue.Div(h.BorrowValue)
+	if h.HealthFactor.GreaterThan(one) {
+		h.PriceMove = one.Sub(one.Div(h.HealthFactor))
+	}
+	return h
+}
+
+func (h Health) Liquidatable() bool {
+	if h.Shortfall != nil && h.Shortfall.Sign() > 0 {
+		return true
+	}
+	return h.HasDebt && h.HealthFactor.LessThan(one)
+}
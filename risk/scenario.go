@@ -0,0 +1,182 @@
+package risk
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/shopspring/decimal"
+
+	"liquidator/contract"
+)
+
+// Shocks 价格冲击，key 为 underlying 符号、pToken 符号或 pToken 地址，value 为相对变化，-0.2 表示下跌 20%
+type Shocks map[string]decimal.Decimal
+
+type MarketImpact struct {
+	Market    string
+	Symbol    string
+	Repayable *big.Int
+	Inventory *big.Int
+	Needed    *big.Int
+	// Error 读取钱包余额失败的原因，此时 Inventory 和 Needed 为空，不影响其他市场
+	Error string `json:",omitempty"`
+}
+
+// ScenarioHealth 冲击后的健康度，Liquidity 和 Shortfall 按冲击后的价格由本地模型计算；
+// CurrentLiquidity 和 CurrentShortfall 是与仓位同一区块上链上 GetAccountLiquidity 返回的当前值，未施加冲击，仅用于对照
+type ScenarioHealth struct {
+	Health
+	CurrentLiquidity *big.Int
+	CurrentShortfall *big.Int
+}
+
+type Scenario struct {
+	Protocol     string
+	Shocks       Shocks
+	Liquidatable []ScenarioHealth
+	Markets      []MarketImpact
+	// Skipped 读取仓位失败、无法判断的借款人
+	Skipped []string
+}
+
+// ParseShocks 解析 "ETH=-20%,BTC=-10%" 或 "ETH -20%" 形式的价格冲击
+func ParseShocks(spec string) (Shocks, error) {
+	shocks := make(Shocks)
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		fields := strings.FieldsFunc(item, func(r rune) bool { return r == '=' || r == ' ' })
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid shock %q", item)
+		}
+		value := strings.TrimSuffix(fields[1], "%")
+		change, err := decimal.NewFromString(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid shock %q: %s", item, err)
+		}
+		if strings.HasSuffix(fields[1], "%") {
+			change = change.Shift(-2)
+		}
+		if change.LessThanOrEqual(one.Neg()) {
+			return nil, fmt.Errorf("invalid shock %q: price would drop to zero", item)
+		}
+		shocks[strings.ToUpper(fields[0])] = change
+	}
+	return shocks, nil
+}
+
+func (s Shocks) String() string {
+	items := make([]string, 0, len(s))
+	for key, change := range s {
+		items = append(items, key+"="+change.Shift(2).String()+"%")
+	}
+	sort.Strings(items)
+	return strings.Join(items, ",")
+}
+
+// change 依次按 pToken 地址、pToken 符号、去掉前缀 p 的 underlying 符号匹配
+func (s Shocks) change(market, symbol string) (decimal.Decimal, bool) {
+	keys := []string{strings.ToUpper(market), strings.ToUpper(symbol)}
+	if len(symbol) > 1 && (symbol[0] == 'p' || symbol[0] == 'c') {
+		keys = append(keys, strings.ToUpper(symbol[1:]))
+	}
+	for _, key := range keys {
+		if change, ok := s[key]; ok {
+			return change, true
+		}
+	}
+	return decimal.Zero, false
+}
+
+func (s Shocks) apply(positions []Position, symbols map[string]string) []Position {
+	result := make([]Position, len(positions))
+	for i, p := range positions {
+		result[i] = p
+		change, ok := s.change(p.Market, symbols[strings.ToLower(p.Market)])
+		if !ok {
+			continue
+		}
+		price := decimal.NewFromBigInt(p.Price, 0).Mul(one.Add(change))
+		result[i].Price = price.BigInt()
+	}
+	return result
+}
+
+// shockedLiquidity 按本地模型的抵押价值和借款价值计算流动性和缺口，单位与 comptroller 返回的一致
+func shockedLiquidity(h Health) (liquidity, shortfall *big.Int) {
+	diff := h.CollateralValue.Sub(h.BorrowValue).BigInt()
+	if diff.Sign() >= 0 {
+		return diff, new(big.Int)
+	}
+	return new(big.Int), diff.Neg(diff)
+}
+
+// RunScenario 在本地价格模型上施加价格冲击，找出会变为可清算的借款人，
+// 统计每个市场可偿还的总额以及钱包还需要准备多少资金。
+// 读取仓位的同一批调用中链上 GetAccountLiquidity 的当前值记录作为基准，不修改任何仓位。
+func RunScenario(p *contract.Protocol, borrowers []string, shocks Shocks) (Scenario, error) {
+	symbols := make(map[string]string)
+	for _, market := range p.Markets() {
+		symbols[strings.ToLower(market.Address)] = market.Symbol
+	}
+
+	closeFactor := p.GetCloseFactor()
+	repayable := make(map[string]*big.Int)
+	scenario := Scenario{Protocol: p.Name, Shocks: shocks}
+	for _, borrower := range borrowers {
+		snapshot, err := p.LoadAccount(context.Background(), borrower)
+		if err != nil {
+			scenario.Skipped = append(scenario.Skipped, borrower)
+			continue
+		}
+		positions := shocks.apply(snapshotPositions(snapshot), symbols)
+		h := ScenarioHealth{Health: Compute(borrower, positions)}
+		h.Protocol = p.Name
+		h.Liquidity, h.Shortfall = shockedLiquidity(h.Health)
+		h.CurrentLiquidity, h.CurrentShortfall = snapshot.Liquidity, snapshot.Shortfall
+		if !h.HasDebt || !h.HealthFactor.LessThan(one) {
+			continue
+		}
+		scenario.Liquidatable = append(scenario.Liquidatable, h)
+		for _, position := range positions {
+			if position.Borrow.Sign() <= 0 {
+				continue
+			}
+			key := strings.ToLower(position.Market)
+			if repayable[key] == nil {
+				repayable[key] = new(big.Int)
+			}
+			amount := decimal.NewFromBigInt(position.Borrow, 0).Mul(closeFactor).BigInt()
+			repayable[key].Add(repayable[key], amount)
+		}
+	}
+
+	for market, amount := range repayable {
+		impact := MarketImpact{Market: market, Symbol: symbols[market], Repayable: amount}
+		// 原生币市场没有 underlying，GetWalletRepayBalance 读取原生币余额
+		inventory, err := p.GetWalletRepayBalance(market)
+		if err != nil {
+			impact.Error = err.Error()
+			scenario.Markets = append(scenario.Markets, impact)
+			continue
+		}
+		needed := new(big.Int).Sub(amount, inventory)
+		if needed.Sign() < 0 {
+			needed.SetInt64(0)
+		}
+		impact.Inventory, impact.Needed = inventory, needed
+		scenario.Markets = append(scenario.Markets, impact)
+	}
+	sort.Slice(scenario.Markets, func(i, j int) bool {
+		return scenario.Markets[i].Symbol < scenario.Markets[j].Symbol
+	})
+	sort.Slice(scenario.Liquidatable, func(i, j int) bool {
+		return scenario.Liquidatable[i].BorrowValue.GreaterThan(scenario.Liquidatable[j].BorrowValue)
+	})
+	return scenario, nil
+}
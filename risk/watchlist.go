@@ -0,0 +1,70 @@
+package risk
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/shopspring/decimal"
+)
+
+// Watchlist 记录健康度在 [1, 1+margin) 之间、即将变为可清算的账户
+type Watchlist struct {
+	mu       sync.RWMutex
+	margin   decimal.Decimal
+	accounts map[string]Health
+}
+
+func NewWatchlist(margin float64) *Watchlist {
+	return &Watchlist{
+		margin:   decimal.NewFromFloat(margin),
+		accounts: make(map[string]Health),
+	}
+}
+
+func (w *Watchlist) Near(h Health) bool {
+	return h.HasDebt && !h.Liquidatable() && h.HealthFactor.LessThan(one.Add(w.margin))
+}
+
+// Update 根据最新健康度加入或移出观察列表，返回是否在列表中
+func (w *Watchlist) Update(h Health) bool {
+	key := strings.ToLower(h.Borrower)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.Near(h) {
+		w.accounts[key] = h
+		return true
+	}
+	delete(w.accounts, key)
+	return false
+}
+
+func (w *Watchlist) Remove(borrower string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.accounts, strings.ToLower(borrower))
+}
+
+// List 按健康度从低到高排序
+func (w *Watchlist) List() []Health {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	result := make([]Health, 0, len(w.accounts))
+	for _, h := range w.accounts {
+		result = append(result, h)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].HealthFactor.LessThan(result[j].HealthFactor)
+	})
+	return result
+}
+
+func (w *Watchlist) Borrowers() []string {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	result := make([]string, 0, len(w.accounts))
+	for _, h := range w.accounts {
+		result = append(result, h.Borrower)
+	}
+	return result
+}
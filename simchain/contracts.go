@@ -0,0 +1,56 @@
+package simchain
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// contracts/combined.json 由 contracts 目录下的 Solidity 源码编译生成，修改合约后重新运行 go generate
+//go:generate sh -c "solc --evm-version london --optimize --combined-json abi,bin contracts/*.sol > contracts/combined.json"
+
+//go:embed contracts/combined.json
+var combinedJSON []byte
+
+type compiled struct {
+	abi abi.ABI
+	bin []byte
+}
+
+// compiledContracts 按合约名索引的 ABI 和部署字节码
+var compiledContracts = loadContracts()
+
+func loadContracts() map[string]compiled {
+	var combined struct {
+		Contracts map[string]struct {
+			Abi json.RawMessage
+			Bin string
+		}
+	}
+	if err := json.Unmarshal(combinedJSON, &combined); err != nil {
+		panic(fmt.Sprintf("parse contracts/combined.json: %s", err))
+	}
+	result := make(map[string]compiled, len(combined.Contracts))
+	for key, c := range combined.Contracts {
+		parsed, err := abi.JSON(bytes.NewReader(c.Abi))
+		if err != nil {
+			panic(fmt.Sprintf("parse %s abi: %s", key, err))
+		}
+		name := key[strings.LastIndex(key, ":")+1:]
+		result[name] = compiled{abi: parsed, bin: common.FromHex(c.Bin)}
+	}
+	return result
+}
+
+func contractABI(name string) abi.ABI {
+	c, ok := compiledContracts[name]
+	if !ok {
+		panic("unknown contract " + name)
+	}
+	return c.abi
+}
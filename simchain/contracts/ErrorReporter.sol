@@ -0,0 +1,97 @@
+// SPDX-License-Identifier: BSD-3-Clause
+pragma solidity ^0.8.0;
+
+// 错误码与 Compound ErrorReporter.sol 的枚举顺序一致，decoder 包按下标解码
+
+contract ComptrollerErrorReporter {
+    enum Error {
+        NO_ERROR,
+        UNAUTHORIZED,
+        COMPTROLLER_MISMATCH,
+        INSUFFICIENT_SHORTFALL,
+        INSUFFICIENT_LIQUIDITY,
+        INVALID_CLOSE_FACTOR,
+        INVALID_COLLATERAL_FACTOR,
+        INVALID_LIQUIDATION_INCENTIVE,
+        MARKET_NOT_ENTERED,
+        MARKET_NOT_LISTED,
+        MARKET_ALREADY_LISTED,
+        MATH_ERROR,
+        NONZERO_BORROW_BALANCE,
+        PRICE_ERROR,
+        REJECTION,
+        SNAPSHOT_ERROR,
+        TOO_MANY_ASSETS,
+        TOO_MUCH_REPAY
+    }
+}
+
+contract TokenErrorReporter {
+    enum Error {
+        NO_ERROR,
+        UNAUTHORIZED,
+        BAD_INPUT,
+        COMPTROLLER_REJECTION,
+        COMPTROLLER_CALCULATION_ERROR,
+        INTEREST_RATE_MODEL_ERROR,
+        INVALID_ACCOUNT_PAIR,
+        INVALID_CLOSE_AMOUNT_REQUESTED,
+        INVALID_COLLATERAL_FACTOR,
+        MATH_ERROR,
+        MARKET_NOT_FRESH,
+        MARKET_NOT_LISTED,
+        TOKEN_INSUFFICIENT_ALLOWANCE,
+        TOKEN_INSUFFICIENT_BALANCE,
+        TOKEN_INSUFFICIENT_CASH,
+        TOKEN_TRANSFER_IN_FAILED,
+        TOKEN_TRANSFER_OUT_FAILED
+    }
+
+    enum FailureInfo {
+        ACCEPT_ADMIN_PENDING_ADMIN_CHECK,
+        ACCRUE_INTEREST_ACCUMULATED_INTEREST_CALCULATION_FAILED,
+        ACCRUE_INTEREST_BORROW_RATE_CALCULATION_FAILED,
+        ACCRUE_INTEREST_NEW_BORROW_INDEX_CALCULATION_FAILED,
+        ACCRUE_INTEREST_NEW_TOTAL_BORROWS_CALCULATION_FAILED,
+        ACCRUE_INTEREST_NEW_TOTAL_RESERVES_CALCULATION_FAILED,
+        ACCRUE_INTEREST_SIMPLE_INTEREST_FACTOR_CALCULATION_FAILED,
+        BORROW_ACCUMULATED_BALANCE_CALCULATION_FAILED,
+        BORROW_ACCRUE_INTEREST_FAILED,
+        BORROW_CASH_NOT_AVAILABLE,
+        BORROW_FRESHNESS_CHECK,
+        BORROW_NEW_TOTAL_BALANCE_CALCULATION_FAILED,
+        BORROW_NEW_ACCOUNT_BORROW_BALANCE_CALCULATION_FAILED,
+        BORROW_MARKET_NOT_LISTED,
+        BORROW_COMPTROLLER_REJECTION,
+        LIQUIDATE_ACCRUE_BORROW_INTEREST_FAILED,
+        LIQUIDATE_ACCRUE_COLLATERAL_INTEREST_FAILED,
+        LIQUIDATE_COLLATERAL_FRESHNESS_CHECK,
+        LIQUIDATE_COMPTROLLER_REJECTION,
+        LIQUIDATE_COMPTROLLER_CALCULATE_AMOUNT_SEIZE_FAILED,
+        LIQUIDATE_CLOSE_AMOUNT_IS_UINT_MAX,
+        LIQUIDATE_CLOSE_AMOUNT_IS_ZERO,
+        LIQUIDATE_FRESHNESS_CHECK,
+        LIQUIDATE_LIQUIDATOR_IS_BORROWER,
+        LIQUIDATE_REPAY_BORROW_FRESH_FAILED,
+        LIQUIDATE_SEIZE_BALANCE_INCREMENT_FAILED,
+        LIQUIDATE_SEIZE_BALANCE_DECREMENT_FAILED,
+        LIQUIDATE_SEIZE_COMPTROLLER_REJECTION,
+        LIQUIDATE_SEIZE_LIQUIDATOR_IS_BORROWER,
+        LIQUIDATE_SEIZE_TOO_MUCH
+    }
+
+    // MathError 与 CarefulMath 一致，MATH_ERROR 时作为 detail
+    uint internal constant MATH_INTEGER_UNDERFLOW = 3;
+
+    event Failure(uint error, uint info, uint detail);
+
+    function fail(Error err, FailureInfo info) internal returns (uint) {
+        emit Failure(uint(err), uint(info), 0);
+        return uint(err);
+    }
+
+    function failOpaque(Error err, FailureInfo info, uint opaqueError) internal returns (uint) {
+        emit Failure(uint(err), uint(info), opaqueError);
+        return uint(err);
+    }
+}
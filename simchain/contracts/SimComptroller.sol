@@ -0,0 +1,283 @@
+// SPDX-License-Identifier: BSD-3-Clause
+pragma solidity ^0.8.0;
+
+import "./ErrorReporter.sol";
+import "./SimOracle.sol";
+
+interface SimPTokenLike {
+    function comptroller() external view returns (address);
+    function exchangeRateStored() external view returns (uint);
+    function borrowBalanceStored(address account) external view returns (uint);
+    function getAccountSnapshot(address account, bool) external view returns (uint, uint, uint, uint);
+}
+
+// SimComptroller 最小的 Compound comptroller：市场上架、抵押率、账户流动性、清算和扣押检查以及 pause guardian。
+// 计算方式与 Compound 的 Exponential 相同（18 位精度，乘法后截断）；没有 mint、borrow、redeem 的检查和 COMP 奖励
+contract SimComptroller is ComptrollerErrorReporter {
+    struct Market {
+        bool isListed;
+        uint collateralFactorMantissa;
+        bool isComped;
+    }
+
+    uint internal constant expScale = 1e18;
+
+    address public admin;
+    SimOracle public oracle;
+    uint public closeFactorMantissa;
+    uint public liquidationIncentiveMantissa;
+    uint public maxAssets = 20;
+
+    bool public seizeGuardianPaused;
+    bool public transferGuardianPaused;
+    mapping(address => bool) public mintGuardianPaused;
+    mapping(address => bool) public borrowGuardianPaused;
+
+    mapping(address => Market) public markets;
+    address[] internal allMarkets;
+    mapping(address => address[]) internal accountAssets;
+    mapping(address => mapping(address => bool)) internal accountMembership;
+
+    event MarketListed(address pToken);
+    event NewCloseFactor(uint oldCloseFactorMantissa, uint newCloseFactorMantissa);
+    event NewCollateralFactor(address pToken, uint oldCollateralFactorMantissa, uint newCollateralFactorMantissa);
+    event NewLiquidationIncentive(uint oldLiquidationIncentiveMantissa, uint newLiquidationIncentiveMantissa);
+    event NewPriceOracle(address oldPriceOracle, address newPriceOracle);
+    event ActionPaused(string action, bool pauseState);
+    event ActionPaused(address pToken, string action, bool pauseState);
+
+    modifier onlyAdmin() {
+        require(msg.sender == admin, "only admin");
+        _;
+    }
+
+    constructor(SimOracle oracle_, uint closeFactorMantissa_, uint liquidationIncentiveMantissa_) {
+        admin = msg.sender;
+        oracle = oracle_;
+        closeFactorMantissa = closeFactorMantissa_;
+        liquidationIncentiveMantissa = liquidationIncentiveMantissa_;
+    }
+
+    /*** 管理 ***/
+
+    function _supportMarket(address pToken) external onlyAdmin returns (uint) {
+        if (markets[pToken].isListed) {
+            return uint(Error.MARKET_ALREADY_LISTED);
+        }
+        markets[pToken].isListed = true;
+        allMarkets.push(pToken);
+        emit MarketListed(pToken);
+        return uint(Error.NO_ERROR);
+    }
+
+    function _setCollateralFactor(address pToken, uint newCollateralFactorMantissa) external onlyAdmin returns (uint) {
+        Market storage market = markets[pToken];
+        if (!market.isListed) {
+            return uint(Error.MARKET_NOT_LISTED);
+        }
+        emit NewCollateralFactor(pToken, market.collateralFactorMantissa, newCollateralFactorMantissa);
+        market.collateralFactorMantissa = newCollateralFactorMantissa;
+        return uint(Error.NO_ERROR);
+    }
+
+    function _setCloseFactor(uint newCloseFactorMantissa) external onlyAdmin returns (uint) {
+        emit NewCloseFactor(closeFactorMantissa, newCloseFactorMantissa);
+        closeFactorMantissa = newCloseFactorMantissa;
+        return uint(Error.NO_ERROR);
+    }
+
+    function _setLiquidationIncentive(uint newLiquidationIncentiveMantissa) external onlyAdmin returns (uint) {
+        emit NewLiquidationIncentive(liquidationIncentiveMantissa, newLiquidationIncentiveMantissa);
+        liquidationIncentiveMantissa = newLiquidationIncentiveMantissa;
+        return uint(Error.NO_ERROR);
+    }
+
+    function _setPriceOracle(SimOracle newOracle) external onlyAdmin returns (uint) {
+        emit NewPriceOracle(address(oracle), address(newOracle));
+        oracle = newOracle;
+        return uint(Error.NO_ERROR);
+    }
+
+    function _setSeizePaused(bool state) external onlyAdmin returns (bool) {
+        seizeGuardianPaused = state;
+        emit ActionPaused("Seize", state);
+        return state;
+    }
+
+    function _setTransferPaused(bool state) external onlyAdmin returns (bool) {
+        transferGuardianPaused = state;
+        emit ActionPaused("Transfer", state);
+        return state;
+    }
+
+    function _setMintPaused(address pToken, bool state) external onlyAdmin returns (bool) {
+        mintGuardianPaused[pToken] = state;
+        emit ActionPaused(pToken, "Mint", state);
+        return state;
+    }
+
+    function _setBorrowPaused(address pToken, bool state) external onlyAdmin returns (bool) {
+        borrowGuardianPaused[pToken] = state;
+        emit ActionPaused(pToken, "Borrow", state);
+        return state;
+    }
+
+    /*** 市场和账户 ***/
+
+    function getAllMarkets() external view returns (address[] memory) {
+        return allMarkets;
+    }
+
+    function getAssetsIn(address account) external view returns (address[] memory) {
+        return accountAssets[account];
+    }
+
+    function checkMembership(address account, address pToken) external view returns (bool) {
+        return accountMembership[pToken][account];
+    }
+
+    // enterMarketFor 由已上架的 pToken 在给账户记入存款或借款时调用，对应 Compound borrowAllowed 中自动进入市场
+    function enterMarketFor(address account) external returns (uint) {
+        if (!markets[msg.sender].isListed) {
+            return uint(Error.MARKET_NOT_LISTED);
+        }
+        if (accountMembership[msg.sender][account]) {
+            return uint(Error.NO_ERROR);
+        }
+        if (accountAssets[account].length >= maxAssets) {
+            return uint(Error.TOO_MANY_ASSETS);
+        }
+        accountMembership[msg.sender][account] = true;
+        accountAssets[account].push(msg.sender);
+        return uint(Error.NO_ERROR);
+    }
+
+    function getAccountLiquidity(address account) external view returns (uint, uint, uint) {
+        (Error err, uint liquidity, uint shortfall) = getHypotheticalAccountLiquidityInternal(account, address(0), 0, 0);
+        return (uint(err), liquidity, shortfall);
+    }
+
+    function getHypotheticalAccountLiquidity(address account, address pTokenModify, uint redeemTokens, uint borrowAmount)
+        external
+        view
+        returns (uint, uint, uint)
+    {
+        (Error err, uint liquidity, uint shortfall) =
+            getHypotheticalAccountLiquidityInternal(account, pTokenModify, redeemTokens, borrowAmount);
+        return (uint(err), liquidity, shortfall);
+    }
+
+    // AccountLiquidityLocalVars 与 Compound 相同，避免局部变量过多
+    struct AccountLiquidityLocalVars {
+        uint sumCollateral;
+        uint sumBorrowPlusEffects;
+        uint pTokenBalance;
+        uint borrowBalance;
+        uint exchangeRate;
+        uint price;
+        uint tokensToDenom;
+    }
+
+    function getHypotheticalAccountLiquidityInternal(
+        address account,
+        address pTokenModify,
+        uint redeemTokens,
+        uint borrowAmount
+    ) internal view returns (Error, uint, uint) {
+        AccountLiquidityLocalVars memory vars;
+        uint err;
+        address[] memory assets = accountAssets[account];
+        for (uint i = 0; i < assets.length; i++) {
+            address asset = assets[i];
+            (err, vars.pTokenBalance, vars.borrowBalance, vars.exchangeRate) =
+                SimPTokenLike(asset).getAccountSnapshot(account, false);
+            if (err != 0) {
+                return (Error.SNAPSHOT_ERROR, 0, 0);
+            }
+            vars.price = oracle.getUnderlyingPrice(asset);
+            if (vars.price == 0) {
+                return (Error.PRICE_ERROR, 0, 0);
+            }
+            vars.tokensToDenom = mulExp(mulExp(markets[asset].collateralFactorMantissa, vars.exchangeRate), vars.price);
+            vars.sumCollateral += mulScalarTruncate(vars.tokensToDenom, vars.pTokenBalance);
+            vars.sumBorrowPlusEffects += mulScalarTruncate(vars.price, vars.borrowBalance);
+            if (asset == pTokenModify) {
+                vars.sumBorrowPlusEffects += mulScalarTruncate(vars.tokensToDenom, redeemTokens);
+                vars.sumBorrowPlusEffects += mulScalarTruncate(vars.price, borrowAmount);
+            }
+        }
+        if (vars.sumCollateral > vars.sumBorrowPlusEffects) {
+            return (Error.NO_ERROR, vars.sumCollateral - vars.sumBorrowPlusEffects, 0);
+        }
+        return (Error.NO_ERROR, 0, vars.sumBorrowPlusEffects - vars.sumCollateral);
+    }
+
+    /*** 清算 ***/
+
+    function liquidateBorrowAllowed(
+        address pTokenBorrowed,
+        address pTokenCollateral,
+        address,
+        address borrower,
+        uint repayAmount
+    ) external view returns (uint) {
+        if (!markets[pTokenBorrowed].isListed || !markets[pTokenCollateral].isListed) {
+            return uint(Error.MARKET_NOT_LISTED);
+        }
+        (Error err, , uint shortfall) = getHypotheticalAccountLiquidityInternal(borrower, address(0), 0, 0);
+        if (err != Error.NO_ERROR) {
+            return uint(err);
+        }
+        if (shortfall == 0) {
+            return uint(Error.INSUFFICIENT_SHORTFALL);
+        }
+        uint borrowBalance = SimPTokenLike(pTokenBorrowed).borrowBalanceStored(borrower);
+        if (repayAmount > mulScalarTruncate(closeFactorMantissa, borrowBalance)) {
+            return uint(Error.TOO_MUCH_REPAY);
+        }
+        return uint(Error.NO_ERROR);
+    }
+
+    function seizeAllowed(
+        address pTokenCollateral,
+        address pTokenBorrowed,
+        address,
+        address,
+        uint
+    ) external view returns (uint) {
+        require(!seizeGuardianPaused, "seize is paused");
+        if (!markets[pTokenCollateral].isListed || !markets[pTokenBorrowed].isListed) {
+            return uint(Error.MARKET_NOT_LISTED);
+        }
+        if (SimPTokenLike(pTokenCollateral).comptroller() != SimPTokenLike(pTokenBorrowed).comptroller()) {
+            return uint(Error.COMPTROLLER_MISMATCH);
+        }
+        return uint(Error.NO_ERROR);
+    }
+
+    // liquidateCalculateSeizeTokens seizeTokens = repay * incentive * priceBorrowed / (priceCollateral * exchangeRate)
+    function liquidateCalculateSeizeTokens(address pTokenBorrowed, address pTokenCollateral, uint actualRepayAmount)
+        external
+        view
+        returns (uint, uint)
+    {
+        uint priceBorrowed = oracle.getUnderlyingPrice(pTokenBorrowed);
+        uint priceCollateral = oracle.getUnderlyingPrice(pTokenCollateral);
+        if (priceBorrowed == 0 || priceCollateral == 0) {
+            return (uint(Error.PRICE_ERROR), 0);
+        }
+        uint exchangeRate = SimPTokenLike(pTokenCollateral).exchangeRateStored();
+        uint numerator = mulExp(liquidationIncentiveMantissa, priceBorrowed);
+        uint denominator = mulExp(priceCollateral, exchangeRate);
+        uint ratio = numerator * expScale / denominator;
+        return (uint(Error.NO_ERROR), mulScalarTruncate(ratio, actualRepayAmount));
+    }
+
+    function mulExp(uint a, uint b) internal pure returns (uint) {
+        return a * b / expScale;
+    }
+
+    function mulScalarTruncate(uint a, uint scalar) internal pure returns (uint) {
+        return a * scalar / expScale;
+    }
+}
@@ -0,0 +1,55 @@
+// SPDX-License-Identifier: BSD-3-Clause
+pragma solidity ^0.8.0;
+
+// SimERC20 市场的底层资产，管理员可以给任意账户发放余额
+contract SimERC20 {
+    address public admin;
+    string public name;
+    string public symbol;
+    uint8 public decimals;
+    uint public totalSupply;
+    mapping(address => uint) public balanceOf;
+    mapping(address => mapping(address => uint)) public allowance;
+
+    event Transfer(address indexed from, address indexed to, uint amount);
+    event Approval(address indexed owner, address indexed spender, uint amount);
+
+    constructor(string memory symbol_, uint8 decimals_) {
+        admin = msg.sender;
+        name = symbol_;
+        symbol = symbol_;
+        decimals = decimals_;
+    }
+
+    function mint(address to, uint amount) external {
+        require(msg.sender == admin, "only admin");
+        balanceOf[to] += amount;
+        totalSupply += amount;
+        emit Transfer(address(0), to, amount);
+    }
+
+    function approve(address spender, uint amount) external returns (bool) {
+        allowance[msg.sender][spender] = amount;
+        emit Approval(msg.sender, spender, amount);
+        return true;
+    }
+
+    function transfer(address to, uint amount) external returns (bool) {
+        move(msg.sender, to, amount);
+        return true;
+    }
+
+    function transferFrom(address from, address to, uint amount) external returns (bool) {
+        require(allowance[from][msg.sender] >= amount, "insufficient allowance");
+        allowance[from][msg.sender] -= amount;
+        move(from, to, amount);
+        return true;
+    }
+
+    function move(address from, address to, uint amount) internal {
+        require(balanceOf[from] >= amount, "insufficient balance");
+        balanceOf[from] -= amount;
+        balanceOf[to] += amount;
+        emit Transfer(from, to, amount);
+    }
+}
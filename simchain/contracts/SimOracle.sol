@@ -0,0 +1,24 @@
+// SPDX-License-Identifier: BSD-3-Clause
+pragma solidity ^0.8.0;
+
+// SimOracle 由管理员设置价格的预言机，价格精度与 Compound 相同：1e(36 - 底层资产精度)
+contract SimOracle {
+    address public admin;
+    mapping(address => uint) internal prices;
+
+    event PricePosted(address pToken, uint previousPriceMantissa, uint newPriceMantissa);
+
+    constructor() {
+        admin = msg.sender;
+    }
+
+    function getUnderlyingPrice(address pToken) external view returns (uint) {
+        return prices[pToken];
+    }
+
+    function setUnderlyingPrice(address pToken, uint price) external {
+        require(msg.sender == admin, "only admin");
+        emit PricePosted(pToken, prices[pToken], price);
+        prices[pToken] = price;
+    }
+}
@@ -0,0 +1,211 @@
+// SPDX-License-Identifier: BSD-3-Clause
+pragma solidity ^0.8.0;
+
+import "./ErrorReporter.sol";
+import "./SimComptroller.sol";
+import "./SimERC20.sol";
+
+// SimPToken 最小的 Compound pToken：兑换率由管理员设置，没有利息累计，存款和借款由管理员直接记账。
+// 清算和扣押的流程、检查顺序、错误码和事件与 Compound CToken 相同
+abstract contract SimPToken is TokenErrorReporter {
+    address public admin;
+    SimComptroller public comptroller;
+    string public name;
+    string public symbol;
+    uint8 public constant decimals = 18;
+    uint public constant borrowIndex = 1e18;
+    uint public totalSupply;
+    uint public totalBorrows;
+
+    uint internal exchangeRateMantissa = 1e18;
+    mapping(address => uint) internal accountTokens;
+    mapping(address => uint) internal accountBorrows;
+
+    event Transfer(address indexed from, address indexed to, uint amount);
+    event Borrow(address borrower, uint borrowAmount, uint accountBorrows, uint totalBorrows, bool isCreditLoan);
+    event RepayBorrow(
+        address payer,
+        address borrower,
+        uint repayAmount,
+        uint accountBorrows,
+        uint totalBorrows,
+        bool isCreditLoan
+    );
+    event LiquidateBorrow(
+        address liquidator,
+        address borrower,
+        uint repayAmount,
+        address pTokenCollateral,
+        uint seizeTokens
+    );
+
+    modifier onlyAdmin() {
+        require(msg.sender == admin, "only admin");
+        _;
+    }
+
+    constructor(SimComptroller comptroller_, string memory symbol_) {
+        admin = msg.sender;
+        comptroller = comptroller_;
+        name = symbol_;
+        symbol = symbol_;
+    }
+
+    /*** 测试设置 ***/
+
+    // _supply 给 account 记入 pTokens 个 pToken 作为抵押物，不转入底层资产
+    function _supply(address account, uint pTokens) external onlyAdmin {
+        require(comptroller.enterMarketFor(account) == 0, "enter market failed");
+        accountTokens[account] += pTokens;
+        totalSupply += pTokens;
+        emit Transfer(address(this), account, pTokens);
+    }
+
+    // _borrow 给 account 记入 amount 的借款，不转出底层资产
+    function _borrow(address account, uint amount) external onlyAdmin {
+        require(comptroller.enterMarketFor(account) == 0, "enter market failed");
+        accountBorrows[account] += amount;
+        totalBorrows += amount;
+        emit Borrow(account, amount, accountBorrows[account], totalBorrows, false);
+    }
+
+    function _setExchangeRate(uint exchangeRateMantissa_) external onlyAdmin {
+        exchangeRateMantissa = exchangeRateMantissa_;
+    }
+
+    /*** 读取 ***/
+
+    function balanceOf(address owner) external view returns (uint) {
+        return accountTokens[owner];
+    }
+
+    function borrowBalanceStored(address account) external view returns (uint) {
+        return accountBorrows[account];
+    }
+
+    function exchangeRateStored() external view returns (uint) {
+        return exchangeRateMantissa;
+    }
+
+    function getAccountSnapshot(address account, bool) external view returns (uint, uint, uint, uint) {
+        return (uint(Error.NO_ERROR), accountTokens[account], accountBorrows[account], exchangeRateMantissa);
+    }
+
+    /*** 清算 ***/
+
+    function liquidateBorrowInternal(address borrower, uint repayAmount, SimPToken pTokenCollateral)
+        internal
+        returns (uint)
+    {
+        uint allowed = comptroller.liquidateBorrowAllowed(
+            address(this),
+            address(pTokenCollateral),
+            msg.sender,
+            borrower,
+            repayAmount
+        );
+        if (allowed != 0) {
+            return failOpaque(Error.COMPTROLLER_REJECTION, FailureInfo.LIQUIDATE_COMPTROLLER_REJECTION, allowed);
+        }
+        if (borrower == msg.sender) {
+            return fail(Error.INVALID_ACCOUNT_PAIR, FailureInfo.LIQUIDATE_LIQUIDATOR_IS_BORROWER);
+        }
+        if (repayAmount == 0) {
+            return fail(Error.INVALID_CLOSE_AMOUNT_REQUESTED, FailureInfo.LIQUIDATE_CLOSE_AMOUNT_IS_ZERO);
+        }
+        if (repayAmount == type(uint).max) {
+            return fail(Error.INVALID_CLOSE_AMOUNT_REQUESTED, FailureInfo.LIQUIDATE_CLOSE_AMOUNT_IS_UINT_MAX);
+        }
+
+        uint actualRepayAmount = doTransferIn(msg.sender, repayAmount);
+        accountBorrows[borrower] -= actualRepayAmount;
+        totalBorrows -= actualRepayAmount;
+        emit RepayBorrow(msg.sender, borrower, actualRepayAmount, accountBorrows[borrower], totalBorrows, false);
+
+        (uint amountSeizeError, uint seizeTokens) = comptroller.liquidateCalculateSeizeTokens(
+            address(this),
+            address(pTokenCollateral),
+            actualRepayAmount
+        );
+        require(amountSeizeError == 0, "LIQUIDATE_COMPTROLLER_CALCULATE_AMOUNT_SEIZE_FAILED");
+        require(pTokenCollateral.balanceOf(borrower) >= seizeTokens, "LIQUIDATE_SEIZE_TOO_MUCH");
+
+        uint seizeError;
+        if (address(pTokenCollateral) == address(this)) {
+            seizeError = seizeInternal(address(this), msg.sender, borrower, seizeTokens);
+        } else {
+            seizeError = pTokenCollateral.seize(msg.sender, borrower, seizeTokens);
+        }
+        require(seizeError == 0, "token seizure failed");
+
+        emit LiquidateBorrow(msg.sender, borrower, actualRepayAmount, address(pTokenCollateral), seizeTokens);
+        return uint(Error.NO_ERROR);
+    }
+
+    function seize(address liquidator, address borrower, uint seizeTokens) external returns (uint) {
+        return seizeInternal(msg.sender, liquidator, borrower, seizeTokens);
+    }
+
+    function seizeInternal(address seizerToken, address liquidator, address borrower, uint seizeTokens)
+        internal
+        returns (uint)
+    {
+        uint allowed = comptroller.seizeAllowed(address(this), seizerToken, liquidator, borrower, seizeTokens);
+        if (allowed != 0) {
+            return failOpaque(Error.COMPTROLLER_REJECTION, FailureInfo.LIQUIDATE_SEIZE_COMPTROLLER_REJECTION, allowed);
+        }
+        if (borrower == liquidator) {
+            return fail(Error.INVALID_ACCOUNT_PAIR, FailureInfo.LIQUIDATE_SEIZE_LIQUIDATOR_IS_BORROWER);
+        }
+        if (accountTokens[borrower] < seizeTokens) {
+            return failOpaque(
+                Error.MATH_ERROR,
+                FailureInfo.LIQUIDATE_SEIZE_BALANCE_DECREMENT_FAILED,
+                MATH_INTEGER_UNDERFLOW
+            );
+        }
+        accountTokens[borrower] -= seizeTokens;
+        accountTokens[liquidator] += seizeTokens;
+        emit Transfer(borrower, liquidator, seizeTokens);
+        return uint(Error.NO_ERROR);
+    }
+
+    // doTransferIn 从 from 转入 amount 的底层资产，失败时 revert，返回实际转入的数量
+    function doTransferIn(address from, uint amount) internal virtual returns (uint);
+}
+
+// SimPErc20 底层资产为 ERC20 的市场，对应 CErc20
+contract SimPErc20 is SimPToken {
+    address public underlying;
+
+    constructor(SimComptroller comptroller_, address underlying_, string memory symbol_)
+        SimPToken(comptroller_, symbol_)
+    {
+        underlying = underlying_;
+    }
+
+    function liquidateBorrow(address borrower, uint repayAmount, SimPToken pTokenCollateral) external returns (uint) {
+        return liquidateBorrowInternal(borrower, repayAmount, pTokenCollateral);
+    }
+
+    function doTransferIn(address from, uint amount) internal override returns (uint) {
+        require(SimERC20(underlying).transferFrom(from, address(this), amount), "TOKEN_TRANSFER_IN_FAILED");
+        return amount;
+    }
+}
+
+// SimPEther 原生币市场，对应 CEther：没有 underlying()，清算时以 msg.value 偿还
+contract SimPEther is SimPToken {
+    constructor(SimComptroller comptroller_, string memory symbol_) SimPToken(comptroller_, symbol_) {}
+
+    function liquidateBorrow(address borrower, SimPToken pTokenCollateral) external payable {
+        uint err = liquidateBorrowInternal(borrower, msg.value, pTokenCollateral);
+        require(err == 0, "liquidateBorrow failed");
+    }
+
+    function doTransferIn(address from, uint amount) internal view override returns (uint) {
+        require(msg.sender == from, "sender mismatch");
+        require(msg.value == amount, "value mismatch");
+        return amount;
+    }
+}
@@ -0,0 +1,80 @@
+package simchain
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/shopspring/decimal"
+
+	"liquidator/conf"
+	"liquidator/contract"
+	"liquidator/subgraph"
+	"liquidator/subgraph/subgraphtest"
+)
+
+// Position 借款人抵押 Supply 个 pETH 和 SupplyUSDC 个 pUSDC（可以为空），借 Borrow 个 USDC
+type Position struct {
+	Borrower   common.Address
+	Supply     string
+	SupplyUSDC string
+	Borrow     string
+}
+
+// Env handler 和 executor 测试共用的环境：模拟链上有 pUSDC（价格 1、抵押率 0.8）和 pETH（价格 2000、抵押率 0.75）
+// 两个市场，所有借款同时写入本地 subgraph，清算机器人已通过 contract.SetChains 连接名为 sim 的协议
+type Env struct {
+	Sim    *Chain
+	USDC   Market
+	ETH    Market
+	Server *subgraphtest.Server
+	Chain  *contract.Chain
+}
+
+// NewEnv 按 positions 建立仓位，给清算人钱包发放 fund 个 USDC（为空时不发放），并把 conf.Config 设为只包含这条链的配置。
+// handler.Init 等初始化由调用方完成，避免 simchain 依赖 handler
+func NewEnv(t testing.TB, fund string, positions ...Position) *Env {
+	t.Helper()
+	e := &Env{Sim: New(t)}
+	e.USDC = e.Sim.AddMarket("pUSDC", Mantissa("1"), Mantissa("0.8"))
+	e.ETH = e.Sim.AddMarket("pETH", Mantissa("2000"), Mantissa("0.75"))
+	for _, p := range positions {
+		e.Sim.Supply(p.Borrower, e.ETH, Mantissa(p.Supply))
+		if p.SupplyUSDC != "" {
+			e.Sim.Supply(p.Borrower, e.USDC, Mantissa(p.SupplyUSDC))
+		}
+		e.Sim.Borrow(p.Borrower, e.USDC, Mantissa(p.Borrow))
+	}
+	if fund != "" {
+		e.Sim.Fund(e.Sim.Liquidator, e.USDC, Mantissa(fund))
+	}
+
+	e.Server = subgraphtest.NewServer(1)
+	t.Cleanup(e.Server.Close)
+	for _, p := range positions {
+		e.Server.AddAccountTokens(e.accountToken(p.Borrower, p.Borrow))
+	}
+
+	cfg := e.Sim.Config("sim", e.Server.URL)
+	conf.Config = conf.ConfigStruct{Chains: []conf.Chain{cfg}}
+	chain, err := contract.NewChainWithBackend(cfg, e.Sim.Backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contract.SetChains(chain)
+	e.Chain = chain
+	return e
+}
+
+func (e *Env) accountToken(borrower common.Address, borrow string) subgraph.AccountToken {
+	market := strings.ToLower(e.USDC.PToken.String())
+	token := subgraph.AccountToken{
+		Id:                  market + "-" + strings.ToLower(borrower.String()),
+		Symbol:              "pUSDC",
+		AccrualBlockNumber:  "1",
+		StoredBorrowBalance: decimal.RequireFromString(borrow),
+	}
+	token.Market.Id = market
+	token.Account.Id = borrower.String()
+	return token
+}
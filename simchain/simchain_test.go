@@ -0,0 +1,130 @@
+package simchain
+
+import (
+	"context"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"liquidator/contract"
+	"liquidator/log"
+)
+
+var borrower = common.HexToAddress("0x00000000000000000000000000000000000b0001")
+
+func newProtocol(t *testing.T, sim *Chain) (*contract.Chain, *contract.Protocol) {
+	t.Helper()
+	if err := log.Init(t.TempDir(), "test", "", "DEBUG"); err != nil {
+		t.Fatal(err)
+	}
+	chain, err := contract.NewChainWithBackend(sim.Config("sim"), sim.Backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chain, chain.Protocols()[0]
+}
+
+func TestProtocolReads(t *testing.T) {
+	sim := New(t)
+	usdc := sim.AddMarket("pUSDC", Mantissa("1"), Mantissa("0.8"))
+	eth := sim.AddMarket("pETH", Mantissa("2000"), Mantissa("0.75"))
+	sim.Supply(borrower, eth, Mantissa("1"))
+	sim.Borrow(borrower, usdc, Mantissa("1400"))
+	_, p := newProtocol(t, sim)
+
+	markets := p.Markets()
+	if len(markets) != 2 {
+		t.Fatalf("markets = %d, want 2", len(markets))
+	}
+	if m, ok := p.GetMarket(usdc.PToken.String()); !ok || m.Symbol != "pUSDC" || m.Decimals != 18 || m.Underlying != usdc.Underlying.String() {
+		t.Fatalf("pUSDC market = %+v", m)
+	}
+	if params := p.Params(); params.CloseFactor.String() != "0.5" || params.LiquidationIncentive.String() != "1.08" {
+		t.Fatalf("params = %+v", params)
+	}
+
+	liquidity, shortfall, err := p.GetAccountLiquidity(borrower.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if liquidity.Cmp(Mantissa("100")) != 0 || shortfall.Sign() != 0 {
+		t.Fatalf("liquidity = %s, shortfall = %s, want 100e18, 0", liquidity, shortfall)
+	}
+
+	sim.SetPrice(eth, Mantissa("1700"))
+	shortfall, err = p.GetShortfall(borrower.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shortfall.Cmp(Mantissa("125")) != 0 {
+		t.Fatalf("shortfall = %s, want 125e18", shortfall)
+	}
+	account, err := p.LoadAccount(context.Background(), borrower.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.Shortfall.Cmp(shortfall) != 0 || len(account.Assets) != 2 {
+		t.Fatalf("account = %+v", account)
+	}
+	for _, asset := range account.Assets {
+		if asset.Market == eth.PToken.String() && (asset.Supply.Cmp(Mantissa("1")) != 0 || asset.Price.Cmp(Mantissa("1700")) != 0 || asset.CollateralFactor.String() != "0.75") {
+			t.Errorf("pETH asset = %+v", asset)
+		}
+		if asset.Market == usdc.PToken.String() && (asset.Borrow.Cmp(Mantissa("1400")) != 0 || asset.ExchangeRate.Cmp(Mantissa("1")) != 0) {
+			t.Errorf("pUSDC asset = %+v", asset)
+		}
+	}
+
+	// 与 Compound 相同，先算出 incentive * priceBorrowed / priceCollateral 并截断，再乘以偿还数量
+	seize, err := p.LiquidateCalculateSeizeTokens(usdc.PToken.String(), eth.PToken.String(), Mantissa("1700"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ratio := new(big.Int).Div(new(big.Int).Mul(Mantissa("1.08"), Mantissa("1")), Mantissa("1700"))
+	want := new(big.Int).Div(new(big.Int).Mul(ratio, Mantissa("1700")), Mantissa("1"))
+	if seize.Cmp(want) != 0 {
+		t.Fatalf("seize = %s, want %s", seize, want)
+	}
+}
+
+func TestNativeMarketHasNoUnderlying(t *testing.T) {
+	sim := New(t)
+	sim.AddMarket("pUSDC", Mantissa("1"), Mantissa("0.8"))
+	bnb := sim.AddNativeMarket("pBNB", Mantissa("300"), Mantissa("0.6"))
+	_, p := newProtocol(t, sim)
+
+	if m, ok := p.GetMarket(bnb.PToken.String()); !ok || m.Symbol != "pBNB" || m.Underlying != "" {
+		t.Fatalf("pBNB market = %+v", m)
+	}
+}
+
+// 借款人没有 shortfall 时 comptroller 拒绝清算，pToken 不 revert 而是返回错误码并记录 Failure 事件
+func TestLiquidateHealthyBorrowerFailure(t *testing.T) {
+	sim := New(t)
+	usdc := sim.AddMarket("pUSDC", Mantissa("1"), Mantissa("0.8"))
+	eth := sim.AddMarket("pETH", Mantissa("2000"), Mantissa("0.75"))
+	sim.Supply(borrower, eth, Mantissa("1"))
+	sim.Borrow(borrower, usdc, Mantissa("1400"))
+	sim.Fund(sim.Liquidator, usdc, Mantissa("1000"))
+	chain, p := newProtocol(t, sim)
+
+	if _, err := chain.Approve(usdc.PToken.String()); err != nil {
+		t.Fatal(err)
+	}
+	sim.Commit()
+	tx, err := chain.LiquidateBorrow(usdc.PToken.String(), borrower.String(), eth.PToken.String(), Mantissa("700"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim.Commit()
+	receipt, err := chain.WaitReceipt(context.Background(), tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.CheckReceipt(receipt)
+	if err == nil || !strings.Contains(err.Error(), "LIQUIDATE_COMPTROLLER_REJECTION") || !strings.Contains(err.Error(), "INSUFFICIENT_SHORTFALL") {
+		t.Fatalf("err = %v, want comptroller rejection INSUFFICIENT_SHORTFALL", err)
+	}
+}
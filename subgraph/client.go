@@ -0,0 +1,178 @@
+package subgraph
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+type Options struct {
+	Timeout     time.Duration
+	Retries     int
+	Backoff     time.Duration
+	MaxBlockLag uint64
+	// Head 返回链上最新区块号，为空时不检查 _meta 落后程度
+	Head func(ctx context.Context) (uint64, error)
+}
+
+type Client struct {
+	url  string
+	opts Options
+	http *http.Client
+}
+
+type request struct {
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables,omitempty"`
+}
+
+type response struct {
+	Data   json.RawMessage `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+func NewClient(url string, opts Options) *Client {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = time.Second
+	}
+	if opts.Retries < 0 {
+		opts.Retries = 0
+	}
+	return &Client{url: url, opts: opts, http: &http.Client{}}
+}
+
+func (c *Client) URL() string {
+	return c.url
+}
+
+// Run 执行查询，网络错误按指数退避重试，GraphQL 和 schema 错误直接返回
+func (c *Client) Run(ctx context.Context, query string, vars map[string]interface{}, out interface{}) error {
+	backoff := c.opts.Backoff
+	var err error
+	for attempt := 0; attempt <= c.opts.Retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return &RequestError{URL: c.url, Err: ctx.Err()}
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+		err = c.run(ctx, query, vars, out)
+		var reqErr *RequestError
+		if err == nil || !errors.As(err, &reqErr) {
+			return err
+		}
+	}
+	return err
+}
+
+func (c *Client) run(ctx context.Context, query string, vars map[string]interface{}, out interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, c.opts.Timeout)
+	defer cancel()
+
+	body, err := json.Marshal(request{Query: query, Variables: vars})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return &RequestError{URL: c.url, Err: err}
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return &RequestError{URL: c.url, Err: err}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return &RequestError{URL: c.url, StatusCode: resp.StatusCode}
+	}
+
+	var r response
+	if err := json.Unmarshal(data, &r); err != nil {
+		return &SchemaError{URL: c.url, Err: err}
+	}
+	if len(r.Errors) > 0 {
+		messages := make([]string, 0, len(r.Errors))
+		for _, e := range r.Errors {
+			messages = append(messages, e.Message)
+		}
+		return &QueryError{URL: c.url, Messages: messages}
+	}
+	if len(r.Data) == 0 || string(r.Data) == "null" {
+		return &SchemaError{URL: c.url, Err: errors.New("missing data")}
+	}
+	if err := json.Unmarshal(r.Data, out); err != nil {
+		return &SchemaError{URL: c.url, Err: err}
+	}
+	return nil
+}
+
+type meta struct {
+	Block struct {
+		Number *uint64 `json:"number"`
+	} `json:"block"`
+}
+
+func (m *meta) number() (uint64, error) {
+	if m == nil || m.Block.Number == nil {
+		return 0, errors.New("missing _meta.block.number")
+	}
+	return *m.Block.Number, nil
+}
+
+// Meta 返回 subgraph 已索引的区块号
+func (c *Client) Meta(ctx context.Context) (uint64, error) {
+	var out struct {
+		Meta *meta `json:"_meta"`
+	}
+	if err := c.Run(ctx, `{ _meta { block { number } } }`, nil, &out); err != nil {
+		return 0, err
+	}
+	block, err := out.Meta.number()
+	if err != nil {
+		return 0, &SchemaError{URL: c.url, Err: err}
+	}
+	return block, nil
+}
+
+// checkLag 拒绝落后链上超过 MaxBlockLag 的数据
+func (c *Client) checkLag(ctx context.Context, block uint64) error {
+	if c.opts.Head == nil || c.opts.MaxBlockLag == 0 {
+		return nil
+	}
+	head, err := c.opts.Head(ctx)
+	if err != nil {
+		return fmt.Errorf("get chain head: %w", err)
+	}
+	if head > block && head-block > c.opts.MaxBlockLag {
+		return &StaleError{URL: c.url, Block: block, Head: head, MaxLag: c.opts.MaxBlockLag}
+	}
+	return nil
+}
+
+// CheckHealth 查询 _meta 并检查落后程度，返回已索引的区块号
+func (c *Client) CheckHealth(ctx context.Context) (uint64, error) {
+	block, err := c.Meta(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return block, c.checkLag(ctx, block)
+}
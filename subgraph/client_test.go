@@ -0,0 +1,246 @@
+package subgraph_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"liquidator/subgraph"
+	"liquidator/subgraph/subgraphtest"
+)
+
+func accountToken(i int, symbol, accrualBlock string) subgraph.AccountToken {
+	account := fmt.Sprintf("0x%040x", i)
+	token := subgraph.AccountToken{
+		Id:                  "0xmarket-" + account,
+		Symbol:              symbol,
+		AccrualBlockNumber:  accrualBlock,
+		PTokenBalance:       decimal.NewFromInt(1),
+		StoredBorrowBalance: decimal.NewFromInt(int64(i + 1)),
+	}
+	token.Market.Id = "0xmarket"
+	token.Account.Id = account
+	return token
+}
+
+func accountTokens(n int, symbol, accrualBlock string) []subgraph.AccountToken {
+	result := make([]subgraph.AccountToken, n)
+	for i := range result {
+		result[i] = accountToken(i, symbol, accrualBlock)
+	}
+	return result
+}
+
+func fixedHead(block uint64) func(ctx context.Context) (uint64, error) {
+	return func(ctx context.Context) (uint64, error) { return block, nil }
+}
+
+func TestAccountTokensExactPage(t *testing.T) {
+	server := subgraphtest.NewServer(100)
+	defer server.Close()
+	server.AddAccountTokens(accountTokens(1000, "pUSDC", "90")...)
+
+	tokens, err := subgraph.NewClient(server.URL, subgraph.Options{}).AccountTokens(context.Background(), "pUSDC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 1000 {
+		t.Fatalf("tokens = %d, want 1000", len(tokens))
+	}
+	// _meta 一次，满页后再查一次空页
+	requests := server.Requests()
+	if len(requests) != 3 {
+		t.Fatalf("requests = %d, want 3", len(requests))
+	}
+	last := requests[2].Variables
+	if last["cursor"] != tokens[999].Id {
+		t.Errorf("second page cursor = %v, want %s", last["cursor"], tokens[999].Id)
+	}
+	for _, r := range requests[1:] {
+		if r.Variables["block"] != float64(100) {
+			t.Errorf("page block = %v, want 100", r.Variables["block"])
+		}
+	}
+}
+
+func TestAccountTokensDuplicateAccrualBlocks(t *testing.T) {
+	server := subgraphtest.NewServer(100)
+	defer server.Close()
+	// 所有记录的 accrualBlockNumber 相同，跨越三页
+	server.AddAccountTokens(accountTokens(2500, "pUSDC", "90")...)
+	server.AddAccountTokens(accountTokens(10, "pETH", "90")...)
+	zero := accountToken(5000, "pUSDC", "90")
+	zero.StoredBorrowBalance = decimal.Zero
+	server.AddAccountTokens(zero)
+
+	tokens, err := subgraph.NewClient(server.URL, subgraph.Options{}).AccountTokens(context.Background(), "pUSDC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 2500 {
+		t.Fatalf("tokens = %d, want 2500", len(tokens))
+	}
+	seen := make(map[string]bool, len(tokens))
+	for _, token := range tokens {
+		if seen[token.Id] {
+			t.Fatalf("duplicate token %s", token.Id)
+		}
+		seen[token.Id] = true
+		if token.Symbol != "pUSDC" {
+			t.Fatalf("unexpected symbol %s", token.Symbol)
+		}
+	}
+}
+
+func TestPartialErrors(t *testing.T) {
+	server := subgraphtest.NewServer(100)
+	defer server.Close()
+	server.AddAccountTokens(accountTokens(3, "pUSDC", "90")...)
+	server.SetErrors("indexing_error")
+
+	_, err := subgraph.NewClient(server.URL, subgraph.Options{Retries: 2}).AccountTokens(context.Background(), "pUSDC")
+	var queryErr *subgraph.QueryError
+	if !errors.As(err, &queryErr) {
+		t.Fatalf("err = %v, want QueryError", err)
+	}
+	// GraphQL 错误不重试
+	if n := len(server.Requests()); n != 1 {
+		t.Errorf("requests = %d, want 1", n)
+	}
+}
+
+func TestHTTPErrorRetried(t *testing.T) {
+	server := subgraphtest.NewServer(100)
+	defer server.Close()
+	server.SetStatus(http.StatusBadGateway)
+
+	_, err := subgraph.NewClient(server.URL, subgraph.Options{Retries: 2, Backoff: time.Millisecond}).Meta(context.Background())
+	var reqErr *subgraph.RequestError
+	if !errors.As(err, &reqErr) || reqErr.StatusCode != http.StatusBadGateway {
+		t.Fatalf("err = %v, want RequestError 502", err)
+	}
+	if n := len(server.Requests()); n != 3 {
+		t.Errorf("requests = %d, want 3", n)
+	}
+}
+
+func TestSlowResponseFailover(t *testing.T) {
+	slow := subgraphtest.NewServer(100)
+	defer slow.Close()
+	slow.SetDelay(time.Second)
+	backup := subgraphtest.NewServer(100)
+	defer backup.Close()
+	backup.AddAccountTokens(accountTokens(2, "pUSDC", "90")...)
+
+	opts := subgraph.Options{Timeout: 50 * time.Millisecond, Backoff: time.Millisecond}
+	pool := subgraph.NewPool()
+	pool.Add(subgraph.NewClient(slow.URL, opts), 0)
+	pool.Add(subgraph.NewClient(backup.URL, opts), 1)
+
+	tokens, err := pool.AccountTokens(context.Background(), "pUSDC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 2 {
+		t.Fatalf("tokens = %d, want 2", len(tokens))
+	}
+	status := pool.Status()
+	if status[0].Healthy || status[0].URL != slow.URL {
+		t.Errorf("slow source status = %+v, want unhealthy", status[0])
+	}
+	if !status[1].Healthy {
+		t.Errorf("backup source status = %+v, want healthy", status[1])
+	}
+}
+
+// CrossCheck 与 CheckHealth 并发运行，go test -race 下不能有数据竞争
+func TestCrossCheckConcurrentHealth(t *testing.T) {
+	primary := subgraphtest.NewServer(100)
+	defer primary.Close()
+	primary.AddAccountTokens(accountTokens(3, "pUSDC", "90")...)
+	backup := subgraphtest.NewServer(100)
+	defer backup.Close()
+	backup.AddAccountTokens(accountTokens(3, "pUSDC", "90")...)
+
+	pool := subgraph.NewPool()
+	pool.Add(subgraph.NewClient(primary.URL, subgraph.Options{}), 0)
+	pool.Add(subgraph.NewClient(backup.URL, subgraph.Options{}), 1)
+
+	ctx := context.Background()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			pool.CheckHealth(ctx)
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		mismatches, err := pool.CrossCheck(ctx, "pUSDC", 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(mismatches) != 0 {
+			t.Fatalf("mismatches = %+v", mismatches)
+		}
+	}
+	<-done
+}
+
+func TestStaleMeta(t *testing.T) {
+	stale := subgraphtest.NewServer(900)
+	defer stale.Close()
+	stale.AddAccountTokens(accountTokens(2, "pUSDC", "90")...)
+
+	opts := subgraph.Options{Head: fixedHead(1000), MaxBlockLag: 10}
+	_, err := subgraph.NewClient(stale.URL, opts).AccountTokens(context.Background(), "pUSDC")
+	var staleErr *subgraph.StaleError
+	if !errors.As(err, &staleErr) || staleErr.Block != 900 || staleErr.Head != 1000 {
+		t.Fatalf("err = %v, want StaleError at 900", err)
+	}
+
+	stale.SetBlock(995)
+	tokens, err := subgraph.NewClient(stale.URL, opts).AccountTokens(context.Background(), "pUSDC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 2 {
+		t.Fatalf("tokens = %d, want 2", len(tokens))
+	}
+}
+
+func TestMarkets(t *testing.T) {
+	server := subgraphtest.NewServer(1000)
+	defer server.Close()
+	server.SetMarkets(
+		subgraph.Market{Id: "0x01", Symbol: "pUSDC", AccrualBlockNumber: 999},
+		subgraph.Market{Id: "0x02", Symbol: "pETH", AccrualBlockNumber: 998},
+	)
+	opts := subgraph.Options{Head: fixedHead(1000), MaxBlockLag: 10}
+	client := subgraph.NewClient(server.URL, opts)
+
+	markets, err := client.Markets(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(markets) != 2 || markets[0].Symbol != "pUSDC" {
+		t.Fatalf("markets = %+v", markets)
+	}
+
+	server.SetBlock(900)
+	var staleErr *subgraph.StaleError
+	if _, err := client.Markets(context.Background()); !errors.As(err, &staleErr) {
+		t.Fatalf("err = %v, want StaleError", err)
+	}
+
+	server.SetBlock(1000)
+	server.SetMarkets(subgraph.Market{Id: "0x01"})
+	var schemaErr *subgraph.SchemaError
+	if _, err := client.Markets(context.Background()); !errors.As(err, &schemaErr) {
+		t.Fatalf("err = %v, want SchemaError", err)
+	}
+}
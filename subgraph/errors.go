@@ -0,0 +1,60 @@
+package subgraph
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RequestError 网络、超时或非 200 响应，可以重试
+type RequestError struct {
+	URL        string
+	StatusCode int
+	Err        error
+}
+
+func (e *RequestError) Error() string {
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("subgraph %s: http status %d", e.URL, e.StatusCode)
+	}
+	return fmt.Sprintf("subgraph %s: %s", e.URL, e.Err)
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+// QueryError subgraph 返回的 GraphQL errors
+type QueryError struct {
+	URL      string
+	Messages []string
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("subgraph %s: %s", e.URL, strings.Join(e.Messages, "; "))
+}
+
+// SchemaError 响应无法解析或缺少期望的字段
+type SchemaError struct {
+	URL string
+	Err error
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("subgraph %s: unexpected response: %s", e.URL, e.Err)
+}
+
+func (e *SchemaError) Unwrap() error {
+	return e.Err
+}
+
+// StaleError subgraph 索引的区块落后链上太多
+type StaleError struct {
+	URL    string
+	Block  uint64
+	Head   uint64
+	MaxLag uint64
+}
+
+func (e *StaleError) Error() string {
+	return fmt.Sprintf("subgraph %s: indexed block %d is %d behind head %d (max %d)", e.URL, e.Block, e.Head-e.Block, e.Head, e.MaxLag)
+}
@@ -0,0 +1,211 @@
+package subgraph
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrNoHealthySource = errors.New("no healthy subgraph source")
+
+type SourceStatus struct {
+	URL       string
+	Priority  int
+	Healthy   bool
+	Block     uint64
+	Error     string
+	CheckedAt time.Time
+}
+
+type source struct {
+	client *Client
+	status SourceStatus
+}
+
+// Pool 按优先级（数字越小越优先）使用多个 subgraph，出错或落后时自动切换到下一个
+type Pool struct {
+	mu      sync.RWMutex
+	sources []*source
+}
+
+func NewPool() *Pool {
+	return &Pool{}
+}
+
+func (p *Pool) Add(client *Client, priority int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sources = append(p.sources, &source{
+		client: client,
+		status: SourceStatus{URL: client.URL(), Priority: priority, Healthy: true},
+	})
+	sort.SliceStable(p.sources, func(i, j int) bool {
+		return p.sources[i].status.Priority < p.sources[j].status.Priority
+	})
+}
+
+func (p *Pool) setStatus(s *source, block uint64, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s.status.Healthy = err == nil
+	s.status.CheckedAt = time.Now()
+	s.status.Error = ""
+	if err != nil {
+		s.status.Error = err.Error()
+	}
+	if block > 0 {
+		s.status.Block = block
+	}
+}
+
+// CheckHealth 检查每个 source 的 _meta 落后程度
+func (p *Pool) CheckHealth(ctx context.Context) {
+	p.mu.RLock()
+	sources := append([]*source(nil), p.sources...)
+	p.mu.RUnlock()
+	for _, s := range sources {
+		block, err := s.client.CheckHealth(ctx)
+		p.setStatus(s, block, err)
+	}
+}
+
+func (p *Pool) Status() []SourceStatus {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	result := make([]SourceStatus, 0, len(p.sources))
+	for _, s := range p.sources {
+		result = append(result, s.status)
+	}
+	return result
+}
+
+// split 持锁按健康状态把 source 分为两组，各自保持优先级顺序；status 由 CheckHealth 并发修改，不能在锁外读取
+func (p *Pool) split() (healthy, unhealthy []*source) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	healthy = make([]*source, 0, len(p.sources))
+	for _, s := range p.sources {
+		if s.status.Healthy {
+			healthy = append(healthy, s)
+		} else {
+			unhealthy = append(unhealthy, s)
+		}
+	}
+	return healthy, unhealthy
+}
+
+// candidates 健康的 source 优先，全部不健康时仍按优先级尝试
+func (p *Pool) candidates() []*source {
+	healthy, unhealthy := p.split()
+	return append(healthy, unhealthy...)
+}
+
+func (p *Pool) do(fn func(c *Client) error) error {
+	candidates := p.candidates()
+	if len(candidates) == 0 {
+		return ErrNoHealthySource
+	}
+	var err error
+	for _, s := range candidates {
+		if err = fn(s.client); err == nil {
+			p.setStatus(s, 0, nil)
+			return nil
+		}
+		p.setStatus(s, 0, err)
+	}
+	return err
+}
+
+func (p *Pool) AccountTokens(ctx context.Context, symbol string) ([]AccountToken, error) {
+	var result []AccountToken
+	err := p.do(func(c *Client) (err error) {
+		result, err = c.AccountTokens(ctx, symbol)
+		return err
+	})
+	return result, err
+}
+
+func (p *Pool) Markets(ctx context.Context) ([]Market, error) {
+	var result []Market
+	err := p.do(func(c *Client) (err error) {
+		result, err = c.Markets(ctx)
+		return err
+	})
+	return result, err
+}
+
+type Mismatch struct {
+	Borrower string
+	Symbol   string
+	Primary  string
+	Backup   string
+	Reason   string
+}
+
+// CrossCheck 在两个优先级最高的健康 source 的同一区块上抽样对比借款人，
+// 返回不一致的记录。只有一个 source 时不做校验。
+func (p *Pool) CrossCheck(ctx context.Context, symbol string, sample int) ([]Mismatch, error) {
+	healthy, _ := p.split()
+	if len(healthy) < 2 {
+		return nil, nil
+	}
+	primary, backup := healthy[0].client, healthy[1].client
+
+	primaryBlock, err := primary.Meta(ctx)
+	if err != nil {
+		return nil, err
+	}
+	backupBlock, err := backup.Meta(ctx)
+	if err != nil {
+		return nil, err
+	}
+	block := primaryBlock
+	if backupBlock < block {
+		block = backupBlock
+	}
+
+	primaryTokens, err := primary.AccountTokensAt(ctx, symbol, block)
+	if err != nil {
+		return nil, err
+	}
+	backupTokens, err := backup.AccountTokensAt(ctx, symbol, block)
+	if err != nil {
+		return nil, err
+	}
+
+	backupByID := make(map[string]AccountToken, len(backupTokens))
+	for _, token := range backupTokens {
+		backupByID[strings.ToLower(token.Id)] = token
+	}
+	rand.Shuffle(len(primaryTokens), func(i, j int) {
+		primaryTokens[i], primaryTokens[j] = primaryTokens[j], primaryTokens[i]
+	})
+	if sample > 0 && len(primaryTokens) > sample {
+		primaryTokens = primaryTokens[:sample]
+	}
+
+	mismatches := make([]Mismatch, 0)
+	for _, token := range primaryTokens {
+		m := Mismatch{Borrower: token.Account.Id, Symbol: symbol, Primary: primary.URL(), Backup: backup.URL()}
+		other, ok := backupByID[strings.ToLower(token.Id)]
+		switch {
+		case !ok:
+			m.Reason = "missing in backup"
+		case !other.StoredBorrowBalance.Equal(token.StoredBorrowBalance):
+			m.Reason = "storedBorrowBalance " + token.StoredBorrowBalance.String() + " != " + other.StoredBorrowBalance.String()
+		case !other.PTokenBalance.Equal(token.PTokenBalance):
+			m.Reason = "pTokenBalance " + token.PTokenBalance.String() + " != " + other.PTokenBalance.String()
+		default:
+			continue
+		}
+		mismatches = append(mismatches, m)
+	}
+	if len(backupTokens) != 0 && len(primaryTokens) == 0 {
+		mismatches = append(mismatches, Mismatch{Symbol: symbol, Primary: primary.URL(), Backup: backup.URL(), Reason: "no borrowers in primary"})
+	}
+	return mismatches, nil
+}
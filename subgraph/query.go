@@ -0,0 +1,140 @@
+package subgraph
+
+import (
+	"context"
+	"errors"
+
+	"github.com/shopspring/decimal"
+)
+
+const pageSize = 1000
+
+type Account struct {
+	Id string
+}
+
+type Market struct {
+	Id                 string
+	Name               string
+	Symbol             string
+	UnderlyingAddress  string
+	UnderlyingName     string
+	UnderlyingSymbol   string
+	AccrualBlockNumber uint
+	BlockTimestamp     uint
+}
+
+type AccountToken struct {
+	Id                  string
+	Symbol              string
+	AccrualBlockNumber  string
+	PTokenBalance       decimal.Decimal
+	StoredBorrowBalance decimal.Decimal
+	Market              Market
+	Account             Account
+}
+
+const accountTokensQuery = `
+query ($symbol: String!, $cursor: String!, $block: Int!) {
+	_meta(block: {number: $block}) { block { number } }
+	accountPTokens(first: 1000, orderBy: id, orderDirection: asc, block: {number: $block},
+		where: {id_gt: $cursor, storedBorrowBalance_gt: 0, symbol: $symbol}) {
+		id
+		symbol
+		pTokenBalance
+		accrualBlockNumber
+		storedBorrowBalance
+		market {
+			id
+			underlyingAddress
+			underlyingSymbol
+		}
+		account {
+			id
+		}
+	}
+}`
+
+const marketsQuery = `
+query {
+	_meta { block { number } }
+	markets(orderBy: accrualBlockNumber, orderDirection: desc) {
+		id
+		name
+		symbol
+		underlyingAddress
+		underlyingName
+		underlyingSymbol
+		accrualBlockNumber
+		blockTimestamp
+	}
+}`
+
+// AccountTokens 按 id 游标分页查询某个市场所有有借款的账户，
+// 所有分页固定在第一次查询时 _meta 返回的区块上，避免翻页过程中数据变化
+func (c *Client) AccountTokens(ctx context.Context, symbol string) ([]AccountToken, error) {
+	block, err := c.CheckHealth(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.AccountTokensAt(ctx, symbol, block)
+}
+
+// AccountTokensAt 查询指定区块上的数据，用于多个 subgraph 之间的交叉校验
+func (c *Client) AccountTokensAt(ctx context.Context, symbol string, block uint64) ([]AccountToken, error) {
+	result := make([]AccountToken, 0)
+	cursor := ""
+	for {
+		var out struct {
+			Meta           *meta           `json:"_meta"`
+			AccountPTokens *[]AccountToken `json:"accountPTokens"`
+		}
+		vars := map[string]interface{}{"symbol": symbol, "cursor": cursor, "block": block}
+		if err := c.Run(ctx, accountTokensQuery, vars, &out); err != nil {
+			return nil, err
+		}
+		if _, err := out.Meta.number(); err != nil {
+			return nil, &SchemaError{URL: c.url, Err: err}
+		}
+		if out.AccountPTokens == nil {
+			return nil, &SchemaError{URL: c.url, Err: errors.New("missing accountPTokens")}
+		}
+		page := *out.AccountPTokens
+		for _, token := range page {
+			if token.Id == "" || token.Account.Id == "" || token.Market.Id == "" {
+				return nil, &SchemaError{URL: c.url, Err: errors.New("accountPToken missing id, account or market")}
+			}
+		}
+		result = append(result, page...)
+		if len(page) < pageSize {
+			return result, nil
+		}
+		cursor = page[len(page)-1].Id
+	}
+}
+
+func (c *Client) Markets(ctx context.Context) ([]Market, error) {
+	var out struct {
+		Meta    *meta     `json:"_meta"`
+		Markets *[]Market `json:"markets"`
+	}
+	if err := c.Run(ctx, marketsQuery, nil, &out); err != nil {
+		return nil, err
+	}
+	block, err := out.Meta.number()
+	if err != nil {
+		return nil, &SchemaError{URL: c.url, Err: err}
+	}
+	if err := c.checkLag(ctx, block); err != nil {
+		return nil, err
+	}
+	if out.Markets == nil {
+		return nil, &SchemaError{URL: c.url, Err: errors.New("missing markets")}
+	}
+	for _, market := range *out.Markets {
+		if market.Id == "" || market.Symbol == "" {
+			return nil, &SchemaError{URL: c.url, Err: errors.New("market missing id or symbol")}
+		}
+	}
+	return *out.Markets, nil
+}
@@ -0,0 +1,157 @@
+// Package subgraphtest 进程内的假 subgraph，支持 accountPTokens 和 markets 查询，
+// 可以模拟分页、部分错误、慢响应和落后的 _meta 区块，用于测试 subgraph 客户端和 handler。
+package subgraphtest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"liquidator/subgraph"
+)
+
+const pageSize = 1000
+
+// Request 服务端收到的一次查询
+type Request struct {
+	Query     string
+	Variables map[string]interface{}
+}
+
+// Server 假 subgraph，所有字段通过方法修改，可以在测试过程中随时调整
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	block    uint64
+	tokens   []subgraph.AccountToken
+	markets  []subgraph.Market
+	errors   []string
+	status   int
+	delay    time.Duration
+	requests []Request
+}
+
+// NewServer 启动假 subgraph，_meta 返回的区块号为 block
+func NewServer(block uint64) *Server {
+	s := &Server{block: block}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// SetBlock 修改 _meta 返回的区块号，小于链上区块时模拟落后的 subgraph
+func (s *Server) SetBlock(block uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.block = block
+}
+
+// AddAccountTokens 添加借款记录，查询时按 id 排序
+func (s *Server) AddAccountTokens(tokens ...subgraph.AccountToken) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens = append(s.tokens, tokens...)
+	sort.Slice(s.tokens, func(i, j int) bool { return s.tokens[i].Id < s.tokens[j].Id })
+}
+
+func (s *Server) SetMarkets(markets ...subgraph.Market) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.markets = markets
+}
+
+// SetErrors 之后的响应在 data 之外同时返回这些 GraphQL errors，为空时恢复正常
+func (s *Server) SetErrors(messages ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errors = messages
+}
+
+// SetStatus 之后的响应使用该 HTTP 状态码，0 表示恢复正常
+func (s *Server) SetStatus(status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = status
+}
+
+// SetDelay 之后的每个响应延迟 delay 返回，客户端断开时提前结束
+func (s *Server) SetDelay(delay time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delay = delay
+}
+
+// Requests 返回收到的所有查询
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	var req Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	delay, status := s.delay, s.status
+	s.mu.Unlock()
+
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if status != 0 && status != http.StatusOK {
+		w.WriteHeader(status)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data := map[string]interface{}{
+		"_meta": map[string]interface{}{"block": map[string]uint64{"number": s.block}},
+	}
+	if strings.Contains(req.Query, "accountPTokens") {
+		data["accountPTokens"] = s.accountTokens(req.Variables)
+	}
+	if strings.Contains(req.Query, "markets(") {
+		data["markets"] = s.markets
+	}
+	resp := map[string]interface{}{"data": data}
+	if len(s.errors) > 0 {
+		errs := make([]map[string]string, 0, len(s.errors))
+		for _, message := range s.errors {
+			errs = append(errs, map[string]string{"message": message})
+		}
+		resp["errors"] = errs
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// accountTokens 与 accountTokensQuery 的过滤条件一致：symbol 相同、id 大于游标、有借款，每页最多 1000 条
+func (s *Server) accountTokens(vars map[string]interface{}) []subgraph.AccountToken {
+	symbol, _ := vars["symbol"].(string)
+	cursor, _ := vars["cursor"].(string)
+	result := make([]subgraph.AccountToken, 0)
+	for _, token := range s.tokens {
+		if token.Symbol != symbol || token.Id <= cursor || token.StoredBorrowBalance.Sign() <= 0 {
+			continue
+		}
+		result = append(result, token)
+		if len(result) == pageSize {
+			break
+		}
+	}
+	return result
+}
@@ -0,0 +1,22 @@
+package utils
+
+import (
+	"runtime/debug"
+
+	"liquidator/log"
+)
+
+// Recover 捕获 panic 并记录日志，避免单条链或单个协议的异常拖垮整个进程
+func Recover(name string) {
+	if r := recover(); r != nil {
+		log.Error("[%s] panic recovered: %v\n%s", name, r, debug.Stack())
+	}
+}
+
+// Go 启动带 panic 保护的 goroutine
+func Go(name string, fn func()) {
+	go func() {
+		defer Recover(name)
+		fn()
+	}()
+}